@@ -0,0 +1,127 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/routers"
+)
+
+var (
+	loadOnce sync.Once
+	spec     *openapi3.T
+	// routes of the specification, indexed by method and gin route template
+	routes  map[string]*routers.Route
+	loadErr error
+)
+
+func load() {
+	loader := openapi3.NewLoader()
+	spec, loadErr = loader.LoadFromData(openapiSpec)
+	if loadErr != nil {
+		loadErr = fmt.Errorf("failed to parse the embedded openapi specification: %w", loadErr)
+		return
+	}
+	// examples are referenced from the schemas, which is not valid per the specification
+	if loadErr = spec.Validate(context.Background(), openapi3.DisableExamplesValidation()); loadErr != nil {
+		loadErr = fmt.Errorf("invalid embedded openapi specification: %w", loadErr)
+		return
+	}
+
+	basePath := ""
+	if len(spec.Servers) > 0 {
+		if serverUrl, err := url.Parse(spec.Servers[0].URL); err == nil {
+			basePath = strings.TrimSuffix(serverUrl.Path, "/")
+		}
+	}
+
+	routes = make(map[string]*routers.Route)
+	for path, pathItem := range spec.Paths.Map() {
+		segments := strings.Split(path, "/")
+		for i, segment := range segments {
+			if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+				segments[i] = ":" + strings.Trim(segment, "{}")
+			}
+		}
+		route := basePath + strings.Join(segments, "/")
+		// the path items may be served elsewhere than the rest of the api
+		if len(pathItem.Servers) > 0 {
+			if serverUrl, err := url.Parse(pathItem.Servers[0].URL); err == nil {
+				route = strings.TrimSuffix(serverUrl.Path, "/") + strings.Join(segments, "/")
+			}
+		}
+		for method, operation := range pathItem.Operations() {
+			routes[method+" "+route] = &routers.Route{
+				Spec:      spec,
+				Path:      path,
+				PathItem:  pathItem,
+				Method:    method,
+				Operation: operation,
+			}
+		}
+	}
+}
+
+// Mount serves the operations of the paths with the prefix under the base path instead,
+// e.g. ("/fhir", "/r4") maps the route "/fhir/metadata" to "/r4/metadata". It is meant for the startup.
+func Mount(prefix string, basePath string) error {
+	if _, err := Spec(); err != nil {
+		return err
+	}
+	if prefix == basePath {
+		return nil
+	}
+	mounted := make(map[string]*routers.Route)
+	for key, specRoute := range routes {
+		method, route, _ := strings.Cut(key, " ")
+		if rest, ok := strings.CutPrefix(route, prefix); ok && (rest == "" || strings.HasPrefix(rest, "/")) {
+			delete(routes, key)
+			mounted[method+" "+basePath+rest] = specRoute
+		}
+	}
+	for key, specRoute := range mounted {
+		routes[key] = specRoute
+	}
+	return nil
+}
+
+// Spec returns the parsed and validated embedded specification
+func Spec() (*openapi3.T, error) {
+	loadOnce.Do(load)
+	return spec, loadErr
+}
+
+// Route returns the specification of the gin route, e.g. ("GET", "/api/donors/:donorId")
+func Route(method string, route string) (*routers.Route, bool) {
+	if _, err := Spec(); err != nil {
+		return nil, false
+	}
+	specRoute, ok := routes[method+" "+route]
+	return specRoute, ok
+}
+
+// OperationId returns the id of the operation served by the gin route
+func OperationId(method string, route string) (string, bool) {
+	specRoute, ok := Route(method, route)
+	if !ok || specRoute.Operation.OperationID == "" {
+		return "", false
+	}
+	return specRoute.Operation.OperationID, true
+}
+
+// IsOperationId reports whether the specification declares the operation
+func IsOperationId(operationId string) bool {
+	if _, err := Spec(); err != nil {
+		return false
+	}
+	for _, specRoute := range routes {
+		if specRoute.Operation.OperationID == operationId {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,48 @@
+package api
+
+import "testing"
+
+func TestMount(t *testing.T) {
+	if err := Mount("/fhir", "/r4"); err != nil {
+		t.Fatal(err)
+	}
+	// restored for the other tests
+	defer func() {
+		if err := Mount("/r4", "/fhir"); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	tests := []struct {
+		method    string
+		route     string
+		operation string
+	}{
+		{"GET", "/r4/metadata", "getFhirCapabilities"},
+		{"GET", "/r4/Patient/:id", "readFhirPatient"},
+		{"GET", "/fhir/metadata", ""},
+		{"GET", "/api/donors", "getDonors"},
+		{"POST", "/api/donors/import", "importDonors"},
+	}
+	for _, test := range tests {
+		if operation, _ := OperationId(test.method, test.route); operation != test.operation {
+			t.Errorf("OperationId(%v, %v) = %q, want %q", test.method, test.route, operation, test.operation)
+		}
+	}
+}
+
+func TestMountKeepsOtherPrefixes(t *testing.T) {
+	// only the whole segments are the prefix
+	if err := Mount("/fh", "/r4"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := OperationId("GET", "/fhir/metadata"); !ok {
+		t.Error("the route /fhir/metadata was moved by the prefix /fh")
+	}
+	if err := Mount("/fhir", "/fhir"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := OperationId("GET", "/fhir/metadata"); !ok {
+		t.Error("the route /fhir/metadata was moved onto itself")
+	}
+}
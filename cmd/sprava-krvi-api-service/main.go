@@ -1,9 +1,15 @@
 package main
 
 import (
-	"log"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
 	"os"
+	"os/signal"
 	"strings"
+	"sync/atomic"
+	"syscall"
 
 	"github.com/Marek-FIIT/sprava-krvi-webapi/api"
 	"github.com/Marek-FIIT/sprava-krvi-webapi/internal/sprava_krvi"
@@ -12,50 +18,430 @@ import (
 	"context"
 	"time"
 
+	"github.com/Marek-FIIT/sprava-krvi-webapi/internal/auth"
+	"github.com/Marek-FIIT/sprava-krvi-webapi/internal/config"
 	"github.com/Marek-FIIT/sprava-krvi-webapi/internal/db_service"
+	"github.com/Marek-FIIT/sprava-krvi-webapi/internal/fhir"
+	"github.com/Marek-FIIT/sprava-krvi-webapi/internal/health"
+	"github.com/Marek-FIIT/sprava-krvi-webapi/internal/logging"
+	"github.com/Marek-FIIT/sprava-krvi-webapi/internal/metrics"
+	"github.com/Marek-FIIT/sprava-krvi-webapi/internal/migrations"
+	"github.com/Marek-FIIT/sprava-krvi-webapi/internal/outbox"
+	"github.com/Marek-FIIT/sprava-krvi-webapi/internal/problem"
+	"github.com/Marek-FIIT/sprava-krvi-webapi/internal/rbac"
+	"github.com/Marek-FIIT/sprava-krvi-webapi/internal/tracing"
+	"github.com/Marek-FIIT/sprava-krvi-webapi/internal/validation"
+	"github.com/Marek-FIIT/sprava-krvi-webapi/internal/webhooks"
 	"github.com/gin-contrib/cors"
 )
 
 func main() {
-	log.Printf("Server started")
-	port := os.Getenv("API_PORT")
-	if port == "" {
-		port = "8080"
+	cfg, err := config.Load(os.Args[1:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
 	}
-	environment := os.Getenv("API_ENVIRONMENT")
-	if !strings.EqualFold(environment, "production") { // case insensitive comparison
+
+	if err := logging.Setup(logging.Config{Level: cfg.Log.Level, Format: cfg.Log.Format}); err != nil {
+		slog.Error("Failed to setup logging", "error", err)
+		os.Exit(1)
+	}
+	slog.Info("Server started", "config", cfg.Effective())
+	if !cfg.IsProduction() {
 		gin.SetMode(gin.DebugMode)
 	}
+	// cancelled by SIGINT or SIGTERM, starts the graceful shutdown
+	signalCtx, stopSignals := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stopSignals()
+	// cancelled after the in-flight requests are done, stops the background workers
+	workerCtx, stopWorkers := context.WithCancel(context.Background())
+	defer stopWorkers()
+	// cancelled once the shutdown starts, ends the event streams the server would wait for otherwise
+	streamsCtx, stopStreams := context.WithCancel(context.Background())
+	defer stopStreams()
+
+	shutdownTracing, err := tracing.Setup(workerCtx, tracing.Config{Exporter: cfg.Tracing.Exporter, File: cfg.Tracing.File})
+	if err != nil {
+		slog.Error("Failed to setup tracing", "error", err)
+		os.Exit(1)
+	}
+
 	engine := gin.New()
-	engine.Use(gin.Recovery())
+	// correlate the logs, error responses and audit records with the request
+	engine.Use(logging.Middleware())
+	engine.Use(gin.CustomRecovery(func(ctx *gin.Context, recovered any) {
+		if recovered == http.ErrAbortHandler {
+			// the handler already sent a part of the response, the server closes the connection
+			panic(recovered)
+		}
+		problem.Abort(ctx, problem.Internal("Unexpected error", fmt.Errorf("panic: %v", recovered)))
+	}))
 
 	corsMiddleware := cors.New(cors.Config{
-		AllowOrigins:     []string{"*"},
+		AllowOrigins:     cfg.Server.CorsOrigins,
 		AllowMethods:     []string{"GET", "PUT", "POST", "DELETE", "PATCH"},
-		AllowHeaders:     []string{"Origin", "Authorization", "Content-Type"},
-		ExposeHeaders:    []string{""},
+		AllowHeaders:     []string{"Origin", "Authorization", "Content-Type", logging.RequestIdHeader},
+		ExposeHeaders:    []string{logging.RequestIdHeader},
 		AllowCredentials: false,
 		MaxAge:           12 * time.Hour,
 	})
 	engine.Use(corsMiddleware)
+	engine.Use(tracing.Middleware("/healthz", "/readyz", "/metrics"))
+	if cfg.Metrics.Enabled {
+		engine.Use(metrics.Middleware())
+	}
+
+	// single connection pool shared by all collections
+	mongoClient, err := db_service.NewMongoClient(db_service.MongoClientConfig{
+		Uri:            cfg.MongoDb.Uri,
+		ServerHost:     cfg.MongoDb.Host,
+		ServerPort:     cfg.MongoDb.Port,
+		UserName:       cfg.MongoDb.Username,
+		Password:       cfg.MongoDb.Password,
+		AuthSource:     cfg.MongoDb.AuthSource,
+		AuthMechanism:  cfg.MongoDb.AuthMechanism,
+		ReplicaSet:     cfg.MongoDb.ReplicaSet,
+		ReadPreference: cfg.MongoDb.ReadPreference,
+		Tls:            cfg.MongoDb.Tls.Enabled,
+		TlsCaFile:      cfg.MongoDb.Tls.CaFile,
+		TlsCertFile:    cfg.MongoDb.Tls.CertFile,
+		TlsKeyFile:     cfg.MongoDb.Tls.KeyFile,
+		TlsInsecure:    cfg.MongoDb.Tls.Insecure,
+		MaxPoolSize:    uint64(cfg.MongoDb.MaxPoolSize),
+		MinPoolSize:    uint64(cfg.MongoDb.MinPoolSize),
+		ConnectTimeout: seconds(cfg.MongoDb.ConnectTimeoutSeconds),
+	})
+	if err != nil {
+		slog.Error("Failed to setup the MongoDB client", "error", err)
+		os.Exit(1)
+	}
+
+	collections := cfg.MongoDb.Collections
+	migrationsConfig := migrations.Config{
+		Client: mongoClient,
+		DbName: cfg.MongoDb.Database,
+		Collections: migrations.Collections{
+			Donor: collections.Donor,
+			Unit:  collections.Unit,
+			Audit: collections.Audit,
+		},
+		DryRun: cfg.MongoDb.MigrateDryRun,
+	}
+	if cfg.MongoDb.MigrateDryRun {
+		results, err := migrations.Run(signalCtx, migrationsConfig)
+		if err != nil {
+			slog.Error("Failed to plan the migrations", "error", err)
+			os.Exit(1)
+		}
+		slog.Info("Migrations dry run finished", "pending", len(results))
+		mongoClient.Disconnect(context.Background())
+		return
+	}
+
+	dbServiceAudit := newDbService[db_service.AuditEntry](cfg, mongoClient, collections.Audit, db_service.AuditIndexes)
+	auditLog := db_service.NewAuditLog(dbServiceAudit)
+	dbServiceOutbox := newDbService[db_service.OutboxEvent](cfg, mongoClient, collections.Outbox,
+		db_service.OutboxIndexes(time.Duration(cfg.Outbox.RetentionHours)*time.Hour))
+	outboxCounters := db_service.NewMongoCounters(mongoServiceConfig(cfg, mongoClient, collections.OutboxState, nil))
 
 	// setup context update  middleware
-	dbServiceDonors := db_service.NewMongoService[sprava_krvi.Donor](db_service.MongoServiceConfig{Collection: "donor"})
-	defer dbServiceDonors.Disconnect(context.Background())
+	dbServiceDonors := db_service.NewAuditedService(
+		db_service.NewOutboxService(
+			newDbService[sprava_krvi.Donor](cfg, mongoClient, collections.Donor, sprava_krvi.DonorIndexes),
+			dbServiceOutbox,
+			outboxCounters,
+			collections.Donor,
+			sprava_krvi.DonorEvents,
+		),
+		auditLog,
+		collections.Donor,
+	)
 	engine.Use(func(ctx *gin.Context) {
 		ctx.Set("db_service_donors", dbServiceDonors)
+		ctx.Set(sprava_krvi.TransactionsKey, cfg.MongoDb.Transactions)
 		ctx.Next()
 	})
 
-	dbServiceUnits := db_service.NewMongoService[sprava_krvi.Unit](db_service.MongoServiceConfig{Collection: "unit"})
-	defer dbServiceUnits.Disconnect(context.Background())
+	// changes of the units streamed to the clients, the change streams keep also the deleted units by their pre-images
+	unitsConfig := mongoServiceConfig(cfg, mongoClient, collections.Unit, sprava_krvi.UnitIndexes)
+	unitsConfig.PreImages = strings.EqualFold(cfg.Events.Source, "mongo")
+	unitsBase := instrument(cfg, db_service.NewMongoService[sprava_krvi.Unit](unitsConfig), collections.Unit)
+	var unitChanges db_service.ChangeFeed[sprava_krvi.Unit]
+	if strings.EqualFold(cfg.Events.Source, "memory") {
+		slog.Warn("The unit events stream serves only the changes made by this replica, run a single replica or the mongo source")
+		// published once the transactions of the outer services commit
+		bus := db_service.NewChangeBus[sprava_krvi.Unit](streamsCtx, cfg.Events.HistorySize)
+		unitsBase = db_service.NewBroadcastService(unitsBase, bus)
+		unitChanges = bus
+	} else {
+		unitChanges = db_service.NewMongoChangeFeed[sprava_krvi.Unit](streamsCtx, unitsConfig)
+	}
+
+	dbServiceUnits := db_service.NewAuditedService(
+		db_service.NewOutboxService(
+			unitsBase,
+			dbServiceOutbox,
+			outboxCounters,
+			collections.Unit,
+			sprava_krvi.UnitEvents,
+		),
+		auditLog,
+		collections.Unit,
+	)
 	engine.Use(func(ctx *gin.Context) {
 		ctx.Set("db_service_units", dbServiceUnits)
+		ctx.Set("change_feed_units", unitChanges)
+		ctx.Set(sprava_krvi.EventsHeartbeatKey, seconds(cfg.Events.HeartbeatSeconds))
+		ctx.Next()
+	})
+	// the units past their expiration are expired in background by one replica at a time
+	if cfg.Events.ExpirySeconds > 0 {
+		go sprava_krvi.ExpireUnits(workerCtx, dbServiceUnits, outboxCounters, seconds(cfg.Events.ExpirySeconds))
+	}
+
+	// events of the units and the donors sent to the webhook subscriptions in background
+	var dispatcher *webhooks.Dispatcher
+	dbServiceWebhooks := newDbService[webhooks.Subscription](cfg, mongoClient, collections.Webhook, webhooks.SubscriptionIndexes)
+	dbServiceWebhookDeliveries := newDbService[webhooks.Delivery](cfg, mongoClient, collections.WebhookDelivery, webhooks.DeliveryIndexes)
+	webhookTargets, err := webhooks.NewTargets(cfg.Webhooks.AllowedTargets)
+	if err != nil {
+		slog.Error("Failed to setup webhooks", "error", err)
+		os.Exit(1)
+	}
+	engine.Use(func(ctx *gin.Context) {
+		ctx.Set("db_service_webhooks", dbServiceWebhooks)
+		ctx.Set("db_service_webhook_deliveries", dbServiceWebhookDeliveries)
+		ctx.Set(webhooks.TargetsKey, webhookTargets)
 		ctx.Next()
 	})
+	if cfg.Webhooks.Enabled {
+		dispatcher = webhooks.NewDispatcher(webhooks.Config{
+			Subscriptions:  dbServiceWebhooks,
+			Deliveries:     dbServiceWebhookDeliveries,
+			Timeout:        seconds(cfg.Webhooks.TimeoutSeconds),
+			MaxAttempts:    cfg.Webhooks.MaxAttempts,
+			InitialBackoff: seconds(cfg.Webhooks.InitialBackoffSeconds),
+			MaxBackoff:     seconds(cfg.Webhooks.MaxBackoffSeconds),
+			PollInterval:   seconds(cfg.Webhooks.PollSeconds),
+			Targets:        webhookTargets,
+			Leases:         outboxCounters,
+		})
+		engine.Use(func(ctx *gin.Context) {
+			ctx.Set(webhooks.DispatcherKey, dispatcher)
+			ctx.Next()
+		})
+		go dispatcher.Run(workerCtx)
+	}
+
+	// domain events recorded in the outbox together with the changes of the units and the donors
+	if cfg.Outbox.Relay {
+		var sinks []outbox.Sink
+		for _, name := range cfg.Outbox.Sinks {
+			switch strings.ToLower(name) {
+			case "log":
+				sinks = append(sinks, outbox.NewLogSink())
+			case "webhook":
+				sinks = append(sinks, dispatcher)
+			case "nats":
+				sink, err := outbox.NewNatsSink(outbox.NatsConfig{
+					Url:           cfg.Outbox.NatsUrl,
+					SubjectPrefix: cfg.Outbox.NatsSubject,
+					Timeout:       seconds(cfg.MongoDb.TimeoutSeconds),
+				})
+				if err != nil {
+					slog.Error("Failed to setup the nats sink", "error", err)
+					os.Exit(1)
+				}
+				sinks = append(sinks, sink)
+			}
+		}
+		relay := outbox.NewRelay(outbox.Config{
+			Store:        dbServiceOutbox,
+			Sinks:        sinks,
+			PollInterval: seconds(cfg.Outbox.PollSeconds),
+			BatchSize:    cfg.Outbox.BatchSize,
+			Leases:       outboxCounters,
+		})
+		go relay.Run(workerCtx)
+	}
+
+	// authentication and authorization of the api callers
+	var apiHandlers []gin.HandlerFunc
+	authenticator, err := auth.NewAuthenticator(workerCtx, auth.Config{
+		JwksFile:        cfg.Auth.JwksFile,
+		JwksUrl:         cfg.Auth.JwksUrl,
+		RefreshInterval: time.Duration(cfg.Auth.JwksRefreshMinutes) * time.Minute,
+		Issuer:          cfg.Auth.Issuer,
+		Audience:        cfg.Auth.Audience,
+		RolesClaim:      cfg.Auth.RolesClaim,
+	})
+	switch {
+	case err == nil:
+		policy, err := rbac.NewPolicy(rbac.Config{PolicyFile: cfg.Rbac.PolicyFile})
+		if err != nil {
+			slog.Error("Failed to load the access control policy", "error", err)
+			os.Exit(1)
+		}
+		apiHandlers = append(apiHandlers, authenticator.Middleware(), policy.Middleware())
+	case errors.Is(err, auth.ErrNotConfigured) && !cfg.IsProduction():
+		slog.Warn("Authentication is disabled", "reason", err)
+	default:
+		slog.Error("Failed to setup authentication", "error", err)
+		os.Exit(1)
+	}
+
+	// the specification declares the FHIR facade under its default path, the operations are looked up where served
+	if err := api.Mount(fhir.DefaultBasePath, cfg.Fhir.BasePath); err != nil {
+		slog.Error("Failed to load the api specification", "error", err)
+		os.Exit(1)
+	}
+
+	// the size of the imported files is limited before the validation reads them
+	apiHandlers = append(apiHandlers, sprava_krvi.LimitImportSize())
+
+	// validation of the requests against the api specification
+	if cfg.Validation.Requests {
+		if _, err := api.Spec(); err != nil {
+			slog.Error("Failed to load the api specification", "error", err)
+			os.Exit(1)
+		}
+		apiHandlers = append(apiHandlers, validation.Middleware(validation.Config{ValidateResponses: cfg.Validation.Responses}))
+	}
 
 	// request routings
-	sprava_krvi.AddRoutes(engine)
+	sprava_krvi.AddRoutes(engine, apiHandlers...)
+	fhir.AddRoutes(engine, cfg.Fhir.BasePath, apiHandlers...)
+	engine.NoRoute(func(ctx *gin.Context) {
+		problem.Abort(ctx, problem.NotFound("No such resource"))
+	})
 	engine.GET("/openapi", api.HandleOpenApi)
-	engine.Run(":" + port)
+
+	// health probes
+	checker := health.NewChecker(health.Config{Timeout: seconds(cfg.Health.TimeoutSeconds)})
+	checker.AddCheck("mongodb", dbServiceDonors.HealthCheck)
+	var bootstrap []func(context.Context) error
+	if cfg.MongoDb.Migrate {
+		bootstrap = append(bootstrap, func(ctx context.Context) error {
+			_, err := migrations.Run(ctx, migrationsConfig)
+			return err
+		})
+	}
+	if cfg.MongoDb.EnsureSchema {
+		bootstrap = append(bootstrap, dbServiceDonors.EnsureSchema, dbServiceUnits.EnsureSchema, dbServiceAudit.EnsureSchema,
+			dbServiceWebhooks.EnsureSchema, dbServiceWebhookDeliveries.EnsureSchema, dbServiceOutbox.EnsureSchema)
+	}
+	if len(bootstrap) > 0 {
+		checker.AddCheck("mongodb_schema", ensureSchema(workerCtx, bootstrap...))
+	}
+	engine.GET("/healthz", checker.HandleLiveness)
+	engine.GET("/readyz", checker.HandleReadiness)
+
+	// metrics, the domain gauges are refreshed in background instead of on every scrape
+	if cfg.Metrics.Enabled {
+		engine.GET("/metrics", metrics.HandleMetrics())
+		go sprava_krvi.RefreshMetrics(workerCtx, dbServiceDonors, dbServiceUnits, seconds(cfg.Metrics.RefreshSeconds))
+	}
+
+	server := &http.Server{
+		Addr:    fmt.Sprintf(":%v", cfg.Server.Port),
+		Handler: engine.Handler(),
+	}
+	server.RegisterOnShutdown(stopStreams)
+	go func() {
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("Server failed", "error", err)
+			os.Exit(1)
+		}
+	}()
+
+	<-signalCtx.Done()
+	stopSignals() // a second signal terminates immediately
+
+	// let the load balancer notice the service is not ready before refusing new connections
+	drainDelay := seconds(cfg.Server.ShutdownDrainSeconds)
+	slog.Info("Shutdown requested", "drain_delay", drainDelay.String())
+	checker.StartDraining()
+	time.Sleep(drainDelay)
+
+	shutdownTimeout := seconds(cfg.Server.ShutdownTimeoutSeconds)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	// stops accepting new requests and waits for the in-flight ones
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		slog.Warn("In-flight requests not finished in time", "timeout", shutdownTimeout.String(), "error", err)
+	}
+	stopWorkers()
+
+	if err := mongoClient.Disconnect(shutdownCtx); err != nil {
+		slog.Error("Failed to disconnect from MongoDB", "error", err)
+	}
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		slog.Error("Failed to flush the traces", "error", err)
+	}
+	slog.Info("Server stopped")
+}
+
+// newDbService binds the collection to the shared client and instruments it, the auditing is left to the caller
+func newDbService[DocType interface{}](cfg *config.Config, client *db_service.MongoClient, collection string, indexes []db_service.Index) db_service.DbService[DocType] {
+	svc := db_service.NewMongoService[DocType](mongoServiceConfig(cfg, client, collection, indexes))
+	return instrument(cfg, svc, collection)
+}
+
+func mongoServiceConfig(cfg *config.Config, client *db_service.MongoClient, collection string, indexes []db_service.Index) db_service.MongoServiceConfig {
+	return db_service.MongoServiceConfig{
+		Client:     client,
+		DbName:     cfg.MongoDb.Database,
+		Collection: collection,
+		Timeout:    seconds(cfg.MongoDb.TimeoutSeconds),
+		Indexes:    indexes,
+
+		Transactions: cfg.MongoDb.Transactions,
+	}
+}
+
+// instrument wraps the plain service by the tracing and the metrics
+func instrument[DocType interface{}](cfg *config.Config, svc db_service.DbService[DocType], collection string) db_service.DbService[DocType] {
+	svc = tracing.NewTracedService(svc, collection)
+	if cfg.Metrics.Enabled {
+		svc = metrics.NewMeteredService(svc, collection)
+	}
+	return svc
+}
+
+// ensureSchema keeps migrating the data and creating the collections and indexes in background until
+// all steps succeed, so that the service starts also before the database. The returned check fails until then.
+func ensureSchema(ctx context.Context, steps ...func(context.Context) error) health.CheckFunc {
+	var ensured atomic.Bool
+	go func() {
+		for {
+			var err error
+			for _, step := range steps {
+				if err = step(ctx); err != nil {
+					break
+				}
+			}
+			if err == nil {
+				ensured.Store(true)
+				return
+			}
+			slog.WarnContext(ctx, "Failed to ensure the MongoDB schema, will retry", "error", err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(5 * time.Second):
+			}
+		}
+	}()
+
+	return func(context.Context) error {
+		if !ensured.Load() {
+			return errors.New("data migrations, collections and indexes are not ensured yet")
+		}
+		return nil
+	}
+}
+
+func seconds(value int) time.Duration {
+	return time.Duration(value) * time.Second
 }
@@ -22,6 +22,26 @@ while(true) {
     }
 }
 
+// the transactions and the change streams need a replica set, the bundled MongoDB is started as a single-node
+// one and initiated here, the replica sets initiated elsewhere are kept as they are
+const admin = connection.getDB("admin")
+let replicaSet
+try {
+    replicaSet = admin.runCommand({ replSetGetStatus: 1 })
+} catch (exception) {
+    replicaSet = exception
+}
+if (replicaSet.codeName === "NotYetInitialized") {
+    print(`Initiating the replica set at ${mongoHost}:${mongoPort}`)
+    admin.runCommand({ replSetInitiate: { _id: "rs0", members: [{ _id: 0, host: `${mongoHost}:${mongoPort}` }] } })
+} else if (!replicaSet.ok) {
+    print(`MongoDB is not a replica set, the webapi needs API_MONGODB_TRANSACTIONS=false: ${replicaSet.errmsg || replicaSet.message}`)
+}
+while (!admin.runCommand({ hello: 1 }).isWritablePrimary) {
+    print("Waiting for the replica set primary")
+    sleep(1000)
+}
+
 // if database and collection exists, exit with success - already initialized
 const databases = connection.getDBNames()
 if (databases.includes(database)) {
@@ -38,10 +58,20 @@ if (databases.includes(database)) {
 const db = connection.getDB(database)
 db.createCollection('donor')
 db.createCollection('unit')
+db.createCollection('audit')
+
+// the audit trail is append-only, the user of the webapi service should get this role on the audit
+// collection instead of the readWrite, see db_service.AuditLog
+db.createRole({
+    role: "auditAppender",
+    privileges: [{
+        resource: { db: database, collection: "audit" },
+        actions: ["find", "insert", "createCollection", "createIndex", "listIndexes"]
+    }],
+    roles: []
+})
 
-// create indexes
-db['donor'].createIndex({ "id": 1 })
-db['unit'].createIndex({ "id": 1 })
+// indexes are ensured by the webapi service at startup, see API_MONGODB_ENSURE_SCHEMA
 
 //insert sample data
 let result1 = db['donor'].insertMany([
@@ -0,0 +1,162 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/Marek-FIIT/sprava-krvi-webapi/internal/db_service"
+	"github.com/Marek-FIIT/sprava-krvi-webapi/internal/problem"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const identityKey = "identity"
+
+// Identity - the authenticated caller of the request
+type Identity struct {
+	Subject string
+	Roles   []string
+}
+
+func (this *Identity) HasRole(role string) bool {
+	for _, r := range this.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+type Config struct {
+	// JWKS is loaded from the file if set, otherwise from the URL
+	JwksFile        string
+	JwksUrl         string
+	RefreshInterval time.Duration
+	Issuer          string
+	Audience        string
+	// dot separated path of the roles claim, e.g. realm_access.roles
+	RolesClaim string
+}
+
+type Authenticator struct {
+	Config
+	keys *keySet
+}
+
+var ErrNotConfigured = errors.New("neither JWKS file nor URL is configured")
+
+// NewAuthenticator loads the JWKS and, if loaded from URL, keeps refreshing it until the context is done
+func NewAuthenticator(ctx context.Context, config Config) (*Authenticator, error) {
+	authenticator := &Authenticator{Config: config}
+
+	if authenticator.RolesClaim == "" {
+		authenticator.RolesClaim = "roles"
+	}
+
+	if authenticator.RefreshInterval == 0 {
+		authenticator.RefreshInterval = 15 * time.Minute
+	}
+
+	if authenticator.JwksFile == "" && authenticator.JwksUrl == "" {
+		return nil, ErrNotConfigured
+	}
+
+	authenticator.keys = newKeySet(authenticator.JwksFile, authenticator.JwksUrl)
+	if err := authenticator.keys.load(ctx); err != nil {
+		return nil, err
+	}
+	if authenticator.JwksFile == "" {
+		go authenticator.keys.refresh(ctx, authenticator.RefreshInterval)
+	}
+
+	slog.Info("Auth config",
+		"jwks", authenticator.JwksFile+authenticator.JwksUrl,
+		"issuer", authenticator.Issuer,
+		"audience", authenticator.Audience,
+	)
+	return authenticator, nil
+}
+
+// Middleware rejects requests without a valid bearer token
+// and stores the identity of the caller in the request context
+func (this *Authenticator) Middleware() gin.HandlerFunc {
+	options := []jwt.ParserOption{
+		jwt.WithValidMethods([]string{"RS256", "ES256"}),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(30 * time.Second),
+	}
+	if this.Issuer != "" {
+		options = append(options, jwt.WithIssuer(this.Issuer))
+	}
+	if this.Audience != "" {
+		options = append(options, jwt.WithAudience(this.Audience))
+	}
+	parser := jwt.NewParser(options...)
+
+	return func(ctx *gin.Context) {
+		header := ctx.GetHeader("Authorization")
+		tokenString, found := strings.CutPrefix(header, "Bearer ")
+		if !found || tokenString == "" {
+			this.unauthorized(ctx, "Bearer token is required")
+			return
+		}
+
+		claims := jwt.MapClaims{}
+		if _, err := parser.ParseWithClaims(tokenString, claims, this.keys.keyfunc); err != nil {
+			this.unauthorized(ctx, "Invalid bearer token")
+			return
+		}
+
+		subject, err := claims.GetSubject()
+		if err != nil || subject == "" {
+			this.unauthorized(ctx, "Bearer token has no subject")
+			return
+		}
+
+		identity := &Identity{
+			Subject: subject,
+			Roles:   lookupRoles(claims, this.RolesClaim),
+		}
+		ctx.Set(identityKey, identity)
+		ctx.Set(db_service.AuditActorKey, identity.Subject)
+		ctx.Next()
+	}
+}
+
+func (this *Authenticator) unauthorized(ctx *gin.Context, message string) {
+	ctx.Header("WWW-Authenticate", `Bearer realm="sprava-krvi"`)
+	problem.Abort(ctx, problem.Unauthorized(message))
+}
+
+// GetIdentity returns the authenticated caller, if any
+func GetIdentity(ctx context.Context) (*Identity, bool) {
+	identity, ok := ctx.Value(identityKey).(*Identity)
+	return identity, ok
+}
+
+func lookupRoles(claims jwt.MapClaims, path string) []string {
+	var value interface{} = map[string]interface{}(claims)
+	for _, key := range strings.Split(path, ".") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = object[key]
+	}
+
+	var roles []string
+	switch value := value.(type) {
+	case []interface{}:
+		for _, role := range value {
+			if role, ok := role.(string); ok {
+				roles = append(roles, role)
+			}
+		}
+	case string:
+		roles = strings.Fields(value)
+	}
+	return roles
+}
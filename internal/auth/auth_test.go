@@ -0,0 +1,186 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func encodeBigInt(value *big.Int) string {
+	return base64.RawURLEncoding.EncodeToString(value.Bytes())
+}
+
+// testAuthenticator trusts the returned RSA and EC keys by the JWKS file
+func testAuthenticator(t *testing.T) (*Authenticator, *rsa.PrivateKey, *ecdsa.PrivateKey) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	set := jsonWebKeySet{Keys: []jsonWebKey{
+		{Kty: "RSA", Kid: "rsa", Use: "sig", N: encodeBigInt(rsaKey.N), E: encodeBigInt(big.NewInt(int64(rsaKey.E)))},
+		{Kty: "EC", Kid: "ec", Crv: "P-256", X: encodeBigInt(ecKey.X), Y: encodeBigInt(ecKey.Y)},
+		// skipped, not meant for the signatures
+		{Kty: "RSA", Kid: "encryption", Use: "enc", N: encodeBigInt(rsaKey.N), E: "AQAB"},
+	}}
+	data, err := json.Marshal(set)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(file, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	authenticator, err := NewAuthenticator(context.Background(), Config{
+		JwksFile:   file,
+		Issuer:     "https://idp.example",
+		Audience:   "sprava-krvi",
+		RolesClaim: "realm_access.roles",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return authenticator, rsaKey, ecKey
+}
+
+func TestMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	authenticator, rsaKey, ecKey := testAuthenticator(t)
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims := func(change func(claims jwt.MapClaims)) jwt.MapClaims {
+		claims := jwt.MapClaims{
+			"sub":          "alice",
+			"iss":          "https://idp.example",
+			"aud":          "sprava-krvi",
+			"exp":          time.Now().Add(time.Hour).Unix(),
+			"realm_access": map[string]interface{}{"roles": []interface{}{"lab", "reception"}},
+		}
+		if change != nil {
+			change(claims)
+		}
+		return claims
+	}
+	sign := func(method jwt.SigningMethod, kid string, key interface{}, claims jwt.MapClaims) string {
+		token := jwt.NewWithClaims(method, claims)
+		if kid != "" {
+			token.Header["kid"] = kid
+		}
+		signed, err := token.SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return "Bearer " + signed
+	}
+
+	tests := []struct {
+		name          string
+		authorization string
+		want          int
+	}{
+		{"RS256", sign(jwt.SigningMethodRS256, "rsa", rsaKey, claims(nil)), http.StatusOK},
+		{"ES256", sign(jwt.SigningMethodES256, "ec", ecKey, claims(nil)), http.StatusOK},
+		{"no token", "", http.StatusUnauthorized},
+		{"not a bearer token", "Basic YWxpY2U6c2VjcmV0", http.StatusUnauthorized},
+		{"unknown key", sign(jwt.SigningMethodRS256, "rotated", rsaKey, claims(nil)), http.StatusUnauthorized},
+		{"key of the encryption", sign(jwt.SigningMethodRS256, "encryption", rsaKey, claims(nil)), http.StatusUnauthorized},
+		{"signed by another key", sign(jwt.SigningMethodRS256, "rsa", otherKey, claims(nil)), http.StatusUnauthorized},
+		{"symmetric algorithm", sign(jwt.SigningMethodHS256, "rsa", []byte("secret"), claims(nil)), http.StatusUnauthorized},
+		{"expired", sign(jwt.SigningMethodRS256, "rsa", rsaKey, claims(func(claims jwt.MapClaims) {
+			claims["exp"] = time.Now().Add(-time.Hour).Unix()
+		})), http.StatusUnauthorized},
+		{"no expiration", sign(jwt.SigningMethodRS256, "rsa", rsaKey, claims(func(claims jwt.MapClaims) {
+			delete(claims, "exp")
+		})), http.StatusUnauthorized},
+		{"other issuer", sign(jwt.SigningMethodRS256, "rsa", rsaKey, claims(func(claims jwt.MapClaims) {
+			claims["iss"] = "https://other.example"
+		})), http.StatusUnauthorized},
+		{"other audience", sign(jwt.SigningMethodRS256, "rsa", rsaKey, claims(func(claims jwt.MapClaims) {
+			claims["aud"] = "other"
+		})), http.StatusUnauthorized},
+		{"no subject", sign(jwt.SigningMethodRS256, "rsa", rsaKey, claims(func(claims jwt.MapClaims) {
+			delete(claims, "sub")
+		})), http.StatusUnauthorized},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			engine := gin.New()
+			engine.Use(authenticator.Middleware())
+			engine.GET("/api/donors", func(ctx *gin.Context) {
+				identity, ok := GetIdentity(ctx)
+				if !ok {
+					t.Fatal("no identity of the authenticated caller")
+				}
+				ctx.String(http.StatusOK, fmt.Sprint(identity.Subject, identity.Roles))
+			})
+
+			request := httptest.NewRequest(http.MethodGet, "/api/donors", nil)
+			if test.authorization != "" {
+				request.Header.Set("Authorization", test.authorization)
+			}
+			recorder := httptest.NewRecorder()
+			engine.ServeHTTP(recorder, request)
+			if recorder.Code != test.want {
+				t.Fatalf("responded %v %v, want %v", recorder.Code, recorder.Body.String(), test.want)
+			}
+			switch {
+			case test.want == http.StatusOK && recorder.Body.String() != "alice[lab reception]":
+				t.Errorf("authenticated %v, want alice[lab reception]", recorder.Body.String())
+			case test.want == http.StatusUnauthorized && !strings.HasPrefix(recorder.Header().Get("WWW-Authenticate"), "Bearer"):
+				t.Error("the bearer challenge is missing")
+			}
+		})
+	}
+}
+
+func TestLookupRoles(t *testing.T) {
+	tests := []struct {
+		name   string
+		claims jwt.MapClaims
+		path   string
+		want   string
+	}{
+		{"list", jwt.MapClaims{"roles": []interface{}{"admin", "lab"}}, "roles", "[admin lab]"},
+		{"nested", jwt.MapClaims{"realm_access": map[string]interface{}{"roles": []interface{}{"lab"}}}, "realm_access.roles", "[lab]"},
+		{"space separated", jwt.MapClaims{"scope": "reception lab"}, "scope", "[reception lab]"},
+		{"not strings skipped", jwt.MapClaims{"roles": []interface{}{"lab", 7}}, "roles", "[lab]"},
+		{"missing", jwt.MapClaims{}, "realm_access.roles", "[]"},
+		{"not an object", jwt.MapClaims{"realm_access": "lab"}, "realm_access.roles", "[]"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := fmt.Sprint(lookupRoles(test.claims, test.path)); got != test.want {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestNewAuthenticatorRequiresKeys(t *testing.T) {
+	if _, err := NewAuthenticator(context.Background(), Config{}); err != ErrNotConfigured {
+		t.Errorf("got %v, want %v", err, ErrNotConfigured)
+	}
+}
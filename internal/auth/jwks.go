@@ -0,0 +1,194 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	// RSA
+	N string `json:"n"`
+	E string `json:"e"`
+	// EC
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+type jsonWebKeySet struct {
+	Keys []jsonWebKey `json:"keys"`
+}
+
+// keySet holds the public keys of the token issuer, indexed by the key id
+type keySet struct {
+	file   string
+	url    string
+	client *http.Client
+	keys   map[string]interface{}
+	lock   sync.RWMutex
+}
+
+func newKeySet(file string, url string) *keySet {
+	return &keySet{
+		file:   file,
+		url:    url,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (this *keySet) load(ctx context.Context) error {
+	var data []byte
+	var err error
+	if this.file != "" {
+		data, err = os.ReadFile(this.file)
+	} else {
+		data, err = this.fetch(ctx)
+	}
+	if err != nil {
+		return err
+	}
+
+	var set jsonWebKeySet
+	if err := json.Unmarshal(data, &set); err != nil {
+		return fmt.Errorf("invalid JWKS: %w", err)
+	}
+
+	keys := make(map[string]interface{})
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			slog.Warn("Skipping JWKS key", "kid", jwk.Kid, "error", err)
+			continue
+		}
+		keys[jwk.Kid] = key
+	}
+	if len(keys) == 0 {
+		return errors.New("JWKS does not contain any usable signing key")
+	}
+
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.keys = keys
+	return nil
+}
+
+func (this *keySet) fetch(ctx context.Context) ([]byte, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, this.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	response, err := this.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("JWKS request failed with status %v", response.Status)
+	}
+	return io.ReadAll(io.LimitReader(response.Body, 1<<20))
+}
+
+// refresh periodically reloads the keys until the context is cancelled,
+// so that rotated keys of the issuer are picked up
+func (this *keySet) refresh(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := this.load(ctx); err != nil {
+				slog.Error("Failed to refresh JWKS", "error", err)
+			}
+		}
+	}
+}
+
+// keyfunc resolves the verification key of the token for jwt.Parse
+func (this *keySet) keyfunc(token *jwt.Token) (interface{}, error) {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" && len(this.keys) == 1 {
+		for _, key := range this.keys {
+			return key, nil
+		}
+	}
+	if key, ok := this.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+func (this *jsonWebKey) publicKey() (interface{}, error) {
+	switch this.Kty {
+	case "RSA":
+		n, err := decodeBigInt(this.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(this.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch this.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", this.Crv)
+		}
+		x, err := decodeBigInt(this.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(this.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("point is not on the curve")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", this.Kty)
+	}
+}
+
+func decodeBigInt(value string) (*big.Int, error) {
+	bytes, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(bytes), nil
+}
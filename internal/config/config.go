@@ -0,0 +1,501 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config - effective configuration of the service.
+//
+// Every option is resolved in the order: default, YAML file, environment variable, command line flag.
+// The flag name is the dotted YAML path of the option, e.g. -mongodb.host
+type Config struct {
+	Environment string `yaml:"environment" env:"API_ENVIRONMENT" usage:"production enforces authentication"`
+
+	Server     ServerConfig     `yaml:"server"`
+	Log        LogConfig        `yaml:"log"`
+	MongoDb    MongoDbConfig    `yaml:"mongodb"`
+	Auth       AuthConfig       `yaml:"auth"`
+	Rbac       RbacConfig       `yaml:"rbac"`
+	Validation ValidationConfig `yaml:"validation"`
+	Health     HealthConfig     `yaml:"health"`
+	Metrics    MetricsConfig    `yaml:"metrics"`
+	Tracing    TracingConfig    `yaml:"tracing"`
+	Webhooks   WebhooksConfig   `yaml:"webhooks"`
+	Outbox     OutboxConfig     `yaml:"outbox"`
+	Events     EventsConfig     `yaml:"events"`
+	Fhir       FhirConfig       `yaml:"fhir"`
+}
+
+type ServerConfig struct {
+	Port                   int      `yaml:"port" env:"API_PORT"`
+	CorsOrigins            []string `yaml:"cors_origins" env:"API_CORS_ORIGINS" usage:"comma separated allowed origins, * allows any"`
+	ShutdownDrainSeconds   int      `yaml:"shutdown_drain_seconds" env:"API_SHUTDOWN_DRAIN_SECONDS" usage:"delay between failing the readiness probe and refusing new requests"`
+	ShutdownTimeoutSeconds int      `yaml:"shutdown_timeout_seconds" env:"API_SHUTDOWN_TIMEOUT_SECONDS" usage:"time given to the in-flight requests on shutdown"`
+}
+
+type LogConfig struct {
+	Level  string `yaml:"level" env:"API_LOG_LEVEL" usage:"debug, info, warn or error"`
+	Format string `yaml:"format" env:"API_LOG_FORMAT" usage:"json or text"`
+}
+
+type MongoDbConfig struct {
+	Uri                   string            `yaml:"uri" env:"API_MONGODB_URI" secret:"true" usage:"full connection string, takes precedence over the host and port"`
+	Host                  string            `yaml:"host" env:"API_MONGODB_HOST"`
+	Port                  int               `yaml:"port" env:"API_MONGODB_PORT"`
+	Username              string            `yaml:"username" env:"API_MONGODB_USERNAME" usage:"overrides the credentials of the connection string"`
+	Password              string            `yaml:"password" env:"API_MONGODB_PASSWORD" secret:"true"`
+	AuthSource            string            `yaml:"auth_source" env:"API_MONGODB_AUTH_SOURCE"`
+	AuthMechanism         string            `yaml:"auth_mechanism" env:"API_MONGODB_AUTH_MECHANISM" usage:"SCRAM-SHA-1, SCRAM-SHA-256, MONGODB-X509, MONGODB-AWS, PLAIN or GSSAPI"`
+	ReplicaSet            string            `yaml:"replica_set" env:"API_MONGODB_REPLICA_SET"`
+	ReadPreference        string            `yaml:"read_preference" env:"API_MONGODB_READ_PREFERENCE" usage:"primary, primaryPreferred, secondary, secondaryPreferred or nearest"`
+	Tls                   MongoDbTlsConfig  `yaml:"tls"`
+	MaxPoolSize           int               `yaml:"max_pool_size" env:"API_MONGODB_MAX_POOL_SIZE" usage:"0 keeps the driver default"`
+	MinPoolSize           int               `yaml:"min_pool_size" env:"API_MONGODB_MIN_POOL_SIZE"`
+	ConnectTimeoutSeconds int               `yaml:"connect_timeout_seconds" env:"API_MONGODB_CONNECT_TIMEOUT_SECONDS"`
+	Database              string            `yaml:"database" env:"API_MONGODB_DATABASE"`
+	TimeoutSeconds        int               `yaml:"timeout_seconds" env:"API_MONGODB_TIMEOUT_SECONDS" usage:"timeout of a single database operation"`
+	EnsureSchema          bool              `yaml:"ensure_schema" env:"API_MONGODB_ENSURE_SCHEMA" usage:"create the collections and indexes at startup, disable for read-only users"`
+	Migrate               bool              `yaml:"migrate" env:"API_MONGODB_MIGRATE" usage:"apply the pending data migrations at startup"`
+	MigrateDryRun         bool              `yaml:"migrate_dry_run" env:"API_MONGODB_MIGRATE_DRY_RUN" usage:"report the pending data migrations and exit without applying them"`
+	Transactions          bool              `yaml:"transactions" env:"API_MONGODB_TRANSACTIONS" usage:"write the documents with their outbox events in transactions, needs a replica set"`
+	Collections           CollectionsConfig `yaml:"collections"`
+}
+
+type MongoDbTlsConfig struct {
+	Enabled  bool   `yaml:"enabled" env:"API_MONGODB_TLS"`
+	CaFile   string `yaml:"ca_file" env:"API_MONGODB_TLS_CA_FILE"`
+	CertFile string `yaml:"cert_file" env:"API_MONGODB_TLS_CERT_FILE"`
+	KeyFile  string `yaml:"key_file" env:"API_MONGODB_TLS_KEY_FILE" usage:"defaults to the certificate file holding also the key"`
+	Insecure bool   `yaml:"insecure" env:"API_MONGODB_TLS_INSECURE" usage:"skip the verification of the server certificate, never in production"`
+}
+
+type CollectionsConfig struct {
+	Donor string `yaml:"donor" env:"API_MONGODB_COLLECTION_DONOR"`
+	Unit  string `yaml:"unit" env:"API_MONGODB_COLLECTION_UNIT"`
+	Audit string `yaml:"audit" env:"API_MONGODB_COLLECTION_AUDIT"`
+
+	Webhook         string `yaml:"webhook" env:"API_MONGODB_COLLECTION_WEBHOOK"`
+	WebhookDelivery string `yaml:"webhook_delivery" env:"API_MONGODB_COLLECTION_WEBHOOK_DELIVERY"`
+	Outbox          string `yaml:"outbox" env:"API_MONGODB_COLLECTION_OUTBOX"`
+	OutboxState     string `yaml:"outbox_state" env:"API_MONGODB_COLLECTION_OUTBOX_STATE" usage:"sequence of the outbox events and the lease of the relay"`
+}
+
+type AuthConfig struct {
+	JwksFile           string `yaml:"jwks_file" env:"API_AUTH_JWKS_FILE" usage:"JWKS used to verify the bearer tokens, takes precedence over the URL"`
+	JwksUrl            string `yaml:"jwks_url" env:"API_AUTH_JWKS_URL"`
+	JwksRefreshMinutes int    `yaml:"jwks_refresh_minutes" env:"API_AUTH_JWKS_REFRESH_MINUTES"`
+	Issuer             string `yaml:"issuer" env:"API_AUTH_ISSUER"`
+	Audience           string `yaml:"audience" env:"API_AUTH_AUDIENCE"`
+	RolesClaim         string `yaml:"roles_claim" env:"API_AUTH_ROLES_CLAIM" usage:"dot separated path of the roles claim"`
+}
+
+type RbacConfig struct {
+	PolicyFile string `yaml:"policy_file" env:"API_RBAC_POLICY_FILE" usage:"roles to operations mapping, the embedded default policy is used if not set"`
+}
+
+type ValidationConfig struct {
+	Requests  bool `yaml:"requests" env:"API_VALIDATE_REQUESTS" usage:"validate the requests against the api specification"`
+	Responses bool `yaml:"responses" env:"API_VALIDATE_RESPONSES" usage:"validate the responses against the api specification, meant for the tests"`
+}
+
+type HealthConfig struct {
+	TimeoutSeconds int `yaml:"timeout_seconds" env:"API_HEALTH_TIMEOUT_SECONDS"`
+}
+
+type MetricsConfig struct {
+	Enabled        bool `yaml:"enabled" env:"API_METRICS_ENABLED"`
+	RefreshSeconds int  `yaml:"refresh_seconds" env:"API_METRICS_REFRESH_SECONDS" usage:"interval of recomputing the domain gauges"`
+}
+
+type TracingConfig struct {
+	Exporter string `yaml:"exporter" env:"API_TRACING_EXPORTER" usage:"none, stdout, file or otlp"`
+	File     string `yaml:"file" env:"API_TRACING_FILE"`
+}
+
+type WebhooksConfig struct {
+	Enabled               bool     `yaml:"enabled" env:"API_WEBHOOKS_ENABLED" usage:"record and send the events to the webhook subscriptions, needs the outbox relay with the webhook sink"`
+	MaxAttempts           int      `yaml:"max_attempts" env:"API_WEBHOOKS_MAX_ATTEMPTS" usage:"attempts before the delivery is moved to the dead-letter list"`
+	InitialBackoffSeconds int      `yaml:"initial_backoff_seconds" env:"API_WEBHOOKS_INITIAL_BACKOFF_SECONDS" usage:"delay before the first retry, doubled with every next one"`
+	MaxBackoffSeconds     int      `yaml:"max_backoff_seconds" env:"API_WEBHOOKS_MAX_BACKOFF_SECONDS"`
+	TimeoutSeconds        int      `yaml:"timeout_seconds" env:"API_WEBHOOKS_TIMEOUT_SECONDS" usage:"timeout of a single delivery request"`
+	PollSeconds           int      `yaml:"poll_seconds" env:"API_WEBHOOKS_POLL_SECONDS" usage:"interval of looking up the due retries"`
+	AllowedTargets        []string `yaml:"allowed_targets" env:"API_WEBHOOKS_ALLOWED_TARGETS" usage:"comma separated hosts or CIDR ranges the webhooks may target despite being loopback, link-local or private, including the internal proxy of HTTPS_PROXY"`
+}
+
+type OutboxConfig struct {
+	Relay          bool     `yaml:"relay" env:"API_OUTBOX_RELAY" usage:"publish the recorded domain events, the replicas take turns by a lease, needs the mongodb transactions"`
+	Sinks          []string `yaml:"sinks" env:"API_OUTBOX_SINKS" usage:"comma separated sinks of the events: log, webhook or nats"`
+	PollSeconds    int      `yaml:"poll_seconds" env:"API_OUTBOX_POLL_SECONDS" usage:"interval of looking up the new events"`
+	BatchSize      int      `yaml:"batch_size" env:"API_OUTBOX_BATCH_SIZE"`
+	RetentionHours int      `yaml:"retention_hours" env:"API_OUTBOX_RETENTION_HOURS" usage:"time the published events are kept"`
+	NatsUrl        string   `yaml:"nats_url" env:"API_OUTBOX_NATS_URL" secret:"true" usage:"nats://[user:password@]host:port of the nats sink"`
+	NatsSubject    string   `yaml:"nats_subject" env:"API_OUTBOX_NATS_SUBJECT" usage:"prefix of the subjects, followed by the event type"`
+}
+
+type EventsConfig struct {
+	Source           string `yaml:"source" env:"API_EVENTS_SOURCE" usage:"feed of the unit events stream: mongo change streams, or memory for a single replica without them"`
+	HistorySize      int    `yaml:"history_size" env:"API_EVENTS_HISTORY_SIZE" usage:"changes kept by the memory feed to resume the streams after"`
+	HeartbeatSeconds int    `yaml:"heartbeat_seconds" env:"API_EVENTS_HEARTBEAT_SECONDS" usage:"interval of the comments keeping the idle streams open"`
+	ExpirySeconds    int    `yaml:"expiry_seconds" env:"API_EVENTS_EXPIRY_SECONDS" usage:"interval of expiring the units past their expiration, which emits the unit.expired events, 0 disables it"`
+}
+
+type FhirConfig struct {
+	BasePath string `yaml:"base_path" env:"API_FHIR_BASE_PATH" usage:"path of the FHIR facade, advertised by its capability statement"`
+}
+
+func Default() *Config {
+	return &Config{
+		Environment: "development",
+		Server: ServerConfig{
+			Port:                   8080,
+			CorsOrigins:            []string{"*"},
+			ShutdownDrainSeconds:   5,
+			ShutdownTimeoutSeconds: 25,
+		},
+		Log: LogConfig{
+			Level:  "info",
+			Format: "json",
+		},
+		MongoDb: MongoDbConfig{
+			Host:                  "localhost",
+			Port:                  27017,
+			Database:              "ss-sprava-krvi",
+			TimeoutSeconds:        10,
+			ConnectTimeoutSeconds: 10,
+			EnsureSchema:          true,
+			Migrate:               true,
+			Transactions:          true,
+			Collections: CollectionsConfig{
+				Donor: "donor",
+				Unit:  "unit",
+				Audit: "audit",
+
+				Webhook:         "webhook",
+				WebhookDelivery: "webhook_delivery",
+				Outbox:          "outbox",
+				OutboxState:     "outbox_state",
+			},
+		},
+		Auth: AuthConfig{
+			JwksRefreshMinutes: 15,
+			RolesClaim:         "roles",
+		},
+		Validation: ValidationConfig{
+			Requests: true,
+		},
+		Health: HealthConfig{
+			TimeoutSeconds: 2,
+		},
+		Metrics: MetricsConfig{
+			Enabled:        true,
+			RefreshSeconds: 60,
+		},
+		Tracing: TracingConfig{
+			Exporter: "none",
+			File:     "traces.json",
+		},
+		Webhooks: WebhooksConfig{
+			Enabled:               true,
+			MaxAttempts:           8,
+			InitialBackoffSeconds: 30,
+			MaxBackoffSeconds:     3600,
+			TimeoutSeconds:        10,
+			PollSeconds:           10,
+		},
+		Outbox: OutboxConfig{
+			Relay:          true,
+			Sinks:          []string{"webhook"},
+			PollSeconds:    1,
+			BatchSize:      100,
+			RetentionHours: 168,
+			NatsSubject:    "sprava-krvi",
+		},
+		Events: EventsConfig{
+			Source:           "mongo",
+			HistorySize:      1000,
+			HeartbeatSeconds: 15,
+			ExpirySeconds:    300,
+		},
+		Fhir: FhirConfig{
+			BasePath: "/fhir",
+		},
+	}
+}
+
+// Load resolves the configuration from the YAML file, the environment and the command line arguments
+// and validates the result. The YAML file is given by the -config flag or the API_CONFIG_FILE variable.
+func Load(args []string) (*Config, error) {
+	config := Default()
+	options := options(config)
+
+	flags, file, err := parseFlags(args, options)
+	if err != nil {
+		return nil, err
+	}
+	if file == "" {
+		file = os.Getenv("API_CONFIG_FILE")
+	}
+
+	if file != "" {
+		if err := config.loadFile(file); err != nil {
+			return nil, err
+		}
+	}
+
+	var errs []error
+	for _, option := range options {
+		if value, ok := os.LookupEnv(option.env); ok && option.env != "" {
+			if err := option.set(value); err != nil {
+				errs = append(errs, fmt.Errorf("%v: %w", option.env, err))
+			}
+		}
+	}
+	for _, option := range options {
+		if value, ok := flags[option.name]; ok {
+			if err := option.set(value); err != nil {
+				errs = append(errs, fmt.Errorf("-%v: %w", option.name, err))
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("invalid configuration: %w", errors.Join(errs...))
+	}
+
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+func (this *Config) loadFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open the configuration file: %w", err)
+	}
+	defer file.Close()
+
+	decoder := yaml.NewDecoder(file)
+	decoder.KnownFields(true)
+	if err := decoder.Decode(this); err != nil {
+		return fmt.Errorf("invalid configuration file %v: %w", path, err)
+	}
+	return nil
+}
+
+func (this *Config) IsProduction() bool {
+	return strings.EqualFold(this.Environment, "production")
+}
+
+// Validate reports all invalid options at once
+func (this *Config) Validate() error {
+	var errs []error
+	invalid := func(option string, format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf("%v: %v", option, fmt.Sprintf(format, args...)))
+	}
+	oneOf := func(option string, value string, allowed ...string) {
+		for _, a := range allowed {
+			if strings.EqualFold(value, a) {
+				return
+			}
+		}
+		invalid(option, "%q is not one of %v", value, strings.Join(allowed, ", "))
+	}
+	positive := func(option string, value int) {
+		if value <= 0 {
+			invalid(option, "has to be positive, got %v", value)
+		}
+	}
+	isPort := func(option string, value int) {
+		if value < 1 || value > 65535 {
+			invalid(option, "has to be a port number, got %v", value)
+		}
+	}
+
+	oneOf("environment", this.Environment, "development", "test", "production")
+
+	isPort("server.port", this.Server.Port)
+	if len(this.Server.CorsOrigins) == 0 {
+		invalid("server.cors_origins", "at least one origin is required")
+	}
+	for _, origin := range this.Server.CorsOrigins {
+		if origin == "*" {
+			continue
+		}
+		if u, err := url.Parse(origin); err != nil || u.Scheme == "" || u.Host == "" {
+			invalid("server.cors_origins", "%q is not an origin", origin)
+		}
+	}
+	if this.Server.ShutdownDrainSeconds < 0 {
+		invalid("server.shutdown_drain_seconds", "cannot be negative, got %v", this.Server.ShutdownDrainSeconds)
+	}
+	positive("server.shutdown_timeout_seconds", this.Server.ShutdownTimeoutSeconds)
+
+	oneOf("log.level", this.Log.Level, "debug", "info", "warn", "error")
+	oneOf("log.format", this.Log.Format, "json", "text")
+
+	if this.MongoDb.Uri != "" {
+		if u, err := url.Parse(this.MongoDb.Uri); err != nil || (u.Scheme != "mongodb" && u.Scheme != "mongodb+srv") {
+			invalid("mongodb.uri", "has to be a mongodb:// or mongodb+srv:// connection string")
+		}
+	} else {
+		if this.MongoDb.Host == "" {
+			invalid("mongodb.host", "is required")
+		}
+		isPort("mongodb.port", this.MongoDb.Port)
+	}
+	if this.MongoDb.AuthMechanism != "" {
+		oneOf("mongodb.auth_mechanism", this.MongoDb.AuthMechanism, "SCRAM-SHA-1", "SCRAM-SHA-256", "MONGODB-X509", "MONGODB-AWS", "PLAIN", "GSSAPI")
+	}
+	if this.MongoDb.ReadPreference != "" {
+		oneOf("mongodb.read_preference", this.MongoDb.ReadPreference, "primary", "primaryPreferred", "secondary", "secondaryPreferred", "nearest")
+	}
+	if this.MongoDb.Tls.KeyFile != "" && this.MongoDb.Tls.CertFile == "" {
+		invalid("mongodb.tls.cert_file", "is required when the key file is set")
+	}
+	if this.IsProduction() && this.MongoDb.Tls.Insecure {
+		invalid("mongodb.tls.insecure", "is not allowed in production")
+	}
+	if this.MongoDb.MaxPoolSize < 0 || this.MongoDb.MinPoolSize < 0 {
+		invalid("mongodb.max_pool_size", "pool sizes cannot be negative")
+	} else if this.MongoDb.MaxPoolSize > 0 && this.MongoDb.MinPoolSize > this.MongoDb.MaxPoolSize {
+		invalid("mongodb.min_pool_size", "cannot exceed the max_pool_size %v", this.MongoDb.MaxPoolSize)
+	}
+	positive("mongodb.connect_timeout_seconds", this.MongoDb.ConnectTimeoutSeconds)
+	if this.MongoDb.Database == "" {
+		invalid("mongodb.database", "is required")
+	}
+	if this.MongoDb.Password != "" && this.MongoDb.Username == "" {
+		invalid("mongodb.username", "is required when the password is set")
+	}
+	positive("mongodb.timeout_seconds", this.MongoDb.TimeoutSeconds)
+	collections := map[string]string{}
+	for option, name := range map[string]string{
+		"mongodb.collections.donor": this.MongoDb.Collections.Donor,
+		"mongodb.collections.unit":  this.MongoDb.Collections.Unit,
+		"mongodb.collections.audit": this.MongoDb.Collections.Audit,
+
+		"mongodb.collections.webhook":          this.MongoDb.Collections.Webhook,
+		"mongodb.collections.webhook_delivery": this.MongoDb.Collections.WebhookDelivery,
+		"mongodb.collections.outbox":           this.MongoDb.Collections.Outbox,
+		"mongodb.collections.outbox_state":     this.MongoDb.Collections.OutboxState,
+	} {
+		if name == "" {
+			invalid(option, "is required")
+		} else if other, ok := collections[name]; ok {
+			invalid(option, "collection %q is already used by %v", name, other)
+		}
+		collections[name] = option
+	}
+
+	if this.Auth.JwksUrl != "" {
+		if u, err := url.Parse(this.Auth.JwksUrl); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			invalid("auth.jwks_url", "%q is not a http(s) URL", this.Auth.JwksUrl)
+		}
+	}
+	if this.IsProduction() && this.Auth.JwksFile == "" && this.Auth.JwksUrl == "" {
+		invalid("auth", "either jwks_file or jwks_url is required in production")
+	}
+	positive("auth.jwks_refresh_minutes", this.Auth.JwksRefreshMinutes)
+	if this.Auth.RolesClaim == "" {
+		invalid("auth.roles_claim", "is required")
+	}
+
+	positive("health.timeout_seconds", this.Health.TimeoutSeconds)
+	positive("metrics.refresh_seconds", this.Metrics.RefreshSeconds)
+
+	oneOf("tracing.exporter", this.Tracing.Exporter, "none", "stdout", "file", "otlp")
+	if strings.EqualFold(this.Tracing.Exporter, "file") && this.Tracing.File == "" {
+		invalid("tracing.file", "is required by the file exporter")
+	}
+
+	positive("webhooks.max_attempts", this.Webhooks.MaxAttempts)
+	positive("webhooks.initial_backoff_seconds", this.Webhooks.InitialBackoffSeconds)
+	if this.Webhooks.MaxBackoffSeconds < this.Webhooks.InitialBackoffSeconds {
+		invalid("webhooks.max_backoff_seconds", "cannot be less than the initial_backoff_seconds %v", this.Webhooks.InitialBackoffSeconds)
+	}
+	positive("webhooks.timeout_seconds", this.Webhooks.TimeoutSeconds)
+	positive("webhooks.poll_seconds", this.Webhooks.PollSeconds)
+	for _, target := range this.Webhooks.AllowedTargets {
+		if _, _, err := net.ParseCIDR(target); strings.Contains(target, "/") && err != nil {
+			invalid("webhooks.allowed_targets", "%q is neither a host nor a CIDR range", target)
+		}
+	}
+
+	// the events recorded without the transactions may be published out of order or for the failed writes
+	if this.Outbox.Relay && !this.MongoDb.Transactions {
+		invalid("outbox.relay", "needs the mongodb transactions, which need a replica set")
+	}
+	positive("outbox.poll_seconds", this.Outbox.PollSeconds)
+	positive("outbox.batch_size", this.Outbox.BatchSize)
+	positive("outbox.retention_hours", this.Outbox.RetentionHours)
+	sinks := map[string]bool{}
+	for _, sink := range this.Outbox.Sinks {
+		oneOf("outbox.sinks", sink, "log", "webhook", "nats")
+		if sinks[strings.ToLower(sink)] {
+			invalid("outbox.sinks", "%q is listed more than once", sink)
+		}
+		sinks[strings.ToLower(sink)] = true
+	}
+	if sinks["webhook"] && !this.Webhooks.Enabled {
+		invalid("outbox.sinks", "the webhook sink needs the webhooks enabled")
+	}
+	// the deliveries are recorded only from the events published by the relay to the webhook sink
+	if this.Webhooks.Enabled && (!this.Outbox.Relay || !sinks["webhook"]) {
+		invalid("webhooks.enabled", "needs the outbox relay with the webhook sink, which need the mongodb transactions")
+	}
+	if sinks["nats"] {
+		if u, err := url.Parse(this.Outbox.NatsUrl); err != nil || u.Scheme != "nats" || u.Host == "" {
+			invalid("outbox.nats_url", "has to be a nats:// URL for the nats sink")
+		}
+		if this.Outbox.NatsSubject == "" {
+			invalid("outbox.nats_subject", "is required by the nats sink")
+		}
+	}
+
+	oneOf("events.source", this.Events.Source, "mongo", "memory")
+	positive("events.history_size", this.Events.HistorySize)
+	positive("events.heartbeat_seconds", this.Events.HeartbeatSeconds)
+	if this.Events.ExpirySeconds < 0 {
+		invalid("events.expiry_seconds", "cannot be negative, got %v", this.Events.ExpirySeconds)
+	}
+
+	if !strings.HasPrefix(this.Fhir.BasePath, "/") || strings.HasSuffix(this.Fhir.BasePath, "/") {
+		invalid("fhir.base_path", "has to start and cannot end with a slash")
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid configuration: %w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// Effective lists the options by their dotted path with the secrets redacted, meant for logging
+func (this *Config) Effective() map[string]string {
+	effective := make(map[string]string)
+	for _, option := range options(this) {
+		value := option.String()
+		if option.secret && value != "" {
+			value = redact(value)
+		}
+		effective[option.name] = value
+	}
+	return effective
+}
+
+// redact keeps the non-secret parts of the connection strings
+func redact(value string) string {
+	if u, err := url.Parse(value); err == nil && u.Scheme != "" && u.Host != "" {
+		if u.User != nil {
+			u.User = url.UserPassword(u.User.Username(), "REDACTED")
+		}
+		return u.String()
+	}
+	return "[REDACTED]"
+}
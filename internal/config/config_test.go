@@ -0,0 +1,163 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadPrecedence(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.yaml")
+	err := os.WriteFile(file, []byte(`
+server:
+  port: 8081
+log:
+  level: debug
+  format: text
+mongodb:
+  database: from-file
+`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("API_CONFIG_FILE", file)
+	t.Setenv("API_LOG_LEVEL", "warn")
+	t.Setenv("API_MONGODB_DATABASE", "from-env")
+	t.Setenv("API_OUTBOX_SINKS", " webhook , log ")
+
+	config, err := Load([]string{"-mongodb.database", "from-flag", "-metrics.enabled=false"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		option string
+		got    interface{}
+		want   interface{}
+	}{
+		{"default", config.MongoDb.Port, 27017},
+		{"file", config.Server.Port, 8081},
+		{"file not overridden", config.Log.Format, "text"},
+		{"environment over file", config.Log.Level, "warn"},
+		{"flag over environment", config.MongoDb.Database, "from-flag"},
+		{"boolean flag", config.Metrics.Enabled, false},
+		{"list", strings.Join(config.Outbox.Sinks, ","), "webhook,log"},
+	}
+	for _, test := range tests {
+		if test.got != test.want {
+			t.Errorf("%v: got %v, want %v", test.option, test.got, test.want)
+		}
+	}
+}
+
+func TestLoadRejectsInvalidValues(t *testing.T) {
+	tests := []struct {
+		name  string
+		env   map[string]string
+		args  []string
+		error string
+	}{
+		{"integer", map[string]string{"API_PORT": "eighty"}, nil, "API_PORT"},
+		{"boolean", nil, []string{"-metrics.enabled=maybe"}, "metrics.enabled"},
+		{"unknown flag", nil, []string{"-mongodb.hostname", "db"}, "mongodb.hostname"},
+		{"extra argument", nil, []string{"serve"}, "unexpected arguments"},
+		{"validation", map[string]string{"API_LOG_FORMAT": "xml"}, nil, "log.format"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for key, value := range test.env {
+				t.Setenv(key, value)
+			}
+			if _, err := Load(test.args); err == nil || !strings.Contains(err.Error(), test.error) {
+				t.Errorf("got %v, want an error of %v", err, test.error)
+			}
+		})
+	}
+}
+
+func TestLoadRejectsUnknownFileOptions(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(file, []byte("mongodb:\n  hots: db\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load([]string{"-config", file}); err == nil || !strings.Contains(err.Error(), "hots") {
+		t.Errorf("got %v, want the unknown option reported", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(config *Config)
+		option string
+	}{
+		{"default", func(config *Config) {}, ""},
+		{"environment", func(config *Config) { config.Environment = "staging" }, "environment"},
+		{"port", func(config *Config) { config.Server.Port = 70000 }, "server.port"},
+		{"origin", func(config *Config) { config.Server.CorsOrigins = []string{"example.com"} }, "server.cors_origins"},
+		{"connection string", func(config *Config) { config.MongoDb.Uri = "postgres://db" }, "mongodb.uri"},
+		{"password without user", func(config *Config) { config.MongoDb.Password = "secret" }, "mongodb.username"},
+		{"shared collection", func(config *Config) { config.MongoDb.Collections.Audit = "donor" }, "mongodb.collections.audit"},
+		{"insecure production", func(config *Config) {
+			config.Environment = "production"
+			config.Auth.JwksUrl = "https://idp.example/jwks.json"
+			config.MongoDb.Tls.Insecure = true
+		}, "mongodb.tls.insecure"},
+		{"production without keys", func(config *Config) { config.Environment = "production" }, "auth"},
+		{"backoff", func(config *Config) { config.Webhooks.MaxBackoffSeconds = 1 }, "webhooks.max_backoff_seconds"},
+		{"allowed target", func(config *Config) { config.Webhooks.AllowedTargets = []string{"10.0.0.0/40"} }, "webhooks.allowed_targets"},
+		{"relay without transactions", func(config *Config) { config.MongoDb.Transactions = false }, "outbox.relay"},
+		{"webhooks without relay", func(config *Config) {
+			config.MongoDb.Transactions = false
+			config.Outbox.Relay = false
+		}, "webhooks.enabled"},
+		{"webhooks without sink", func(config *Config) { config.Outbox.Sinks = []string{"log"} }, "webhooks.enabled"},
+		{"negative expiry", func(config *Config) { config.Events.ExpirySeconds = -1 }, "events.expiry_seconds"},
+		{"standalone without webhooks", func(config *Config) {
+			config.MongoDb.Transactions = false
+			config.Outbox.Relay = false
+			config.Outbox.Sinks = nil
+			config.Webhooks.Enabled = false
+		}, ""},
+		{"repeated sink", func(config *Config) { config.Outbox.Sinks = []string{"webhook", "Webhook"} }, "outbox.sinks"},
+		{"nats sink", func(config *Config) { config.Outbox.Sinks = []string{"webhook", "nats"} }, "outbox.nats_url"},
+		{"events source", func(config *Config) { config.Events.Source = "kafka" }, "events.source"},
+		{"fhir base path", func(config *Config) { config.Fhir.BasePath = "/fhir/" }, "fhir.base_path"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := Default()
+			test.change(config)
+			err := config.Validate()
+			switch {
+			case test.option == "" && err != nil:
+				t.Errorf("got %v, want valid", err)
+			case test.option != "" && (err == nil || !strings.Contains(err.Error(), test.option+":")):
+				t.Errorf("got %v, want %v reported", err, test.option)
+			}
+		})
+	}
+}
+
+func TestEffectiveRedactsSecrets(t *testing.T) {
+	config := Default()
+	config.MongoDb.Uri = "mongodb://api:secret@db:27017/?replicaSet=rs0"
+	config.MongoDb.Username = "api"
+	config.MongoDb.Password = "secret"
+
+	effective := config.Effective()
+	tests := []struct {
+		option string
+		want   string
+	}{
+		{"mongodb.uri", "mongodb://api:REDACTED@db:27017/?replicaSet=rs0"},
+		{"mongodb.password", "[REDACTED]"},
+		{"mongodb.username", "api"},
+		{"outbox.nats_url", ""},
+	}
+	for _, test := range tests {
+		if got := effective[test.option]; got != test.want {
+			t.Errorf("%v: got %q, want %q", test.option, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// option - single configurable field of the Config, addressed by its dotted YAML path
+type option struct {
+	name   string
+	env    string
+	usage  string
+	secret bool
+	value  reflect.Value
+}
+
+func options(config *Config) []*option {
+	var result []*option
+	var collect func(prefix string, value reflect.Value)
+	collect = func(prefix string, value reflect.Value) {
+		for i := 0; i < value.NumField(); i++ {
+			field := value.Type().Field(i)
+			name := prefix + field.Tag.Get("yaml")
+			if field.Type.Kind() == reflect.Struct {
+				collect(name+".", value.Field(i))
+				continue
+			}
+			result = append(result, &option{
+				name:   name,
+				env:    field.Tag.Get("env"),
+				usage:  field.Tag.Get("usage"),
+				secret: field.Tag.Get("secret") == "true",
+				value:  value.Field(i),
+			})
+		}
+	}
+	collect("", reflect.ValueOf(config).Elem())
+	return result
+}
+
+func (this *option) set(raw string) error {
+	switch this.value.Kind() {
+	case reflect.String:
+		this.value.SetString(raw)
+	case reflect.Int:
+		value, err := strconv.Atoi(strings.TrimSpace(raw))
+		if err != nil {
+			return fmt.Errorf("%q is not an integer", raw)
+		}
+		this.value.SetInt(int64(value))
+	case reflect.Bool:
+		value, err := strconv.ParseBool(strings.TrimSpace(raw))
+		if err != nil {
+			return fmt.Errorf("%q is not a boolean", raw)
+		}
+		this.value.SetBool(value)
+	case reflect.Slice:
+		var values []string
+		for _, value := range strings.Split(raw, ",") {
+			if value = strings.TrimSpace(value); value != "" {
+				values = append(values, value)
+			}
+		}
+		this.value.Set(reflect.ValueOf(values))
+	default:
+		return fmt.Errorf("unsupported option type %v", this.value.Type())
+	}
+	return nil
+}
+
+func (this *option) String() string {
+	if this.value.Kind() == reflect.Slice {
+		return strings.Join(this.value.Interface().([]string), ",")
+	}
+	return fmt.Sprint(this.value.Interface())
+}
+
+// recorded - flag.Value keeping the raw value, so that the flags can be applied after the file and environment
+type recorded struct {
+	name     string
+	values   map[string]string
+	isBool   bool
+	fallback string
+}
+
+// String is the default printed by the usage
+func (this *recorded) String() string { return this.fallback }
+
+func (this *recorded) Set(value string) error {
+	this.values[this.name] = value
+	return nil
+}
+
+func (this *recorded) IsBoolFlag() bool { return this.isBool }
+
+func parseFlags(args []string, options []*option) (map[string]string, string, error) {
+	flags := flag.NewFlagSet("sprava-krvi-webapi-srv", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+
+	values := make(map[string]string)
+	file := flags.String("config", "", "YAML configuration file, also API_CONFIG_FILE")
+	for _, option := range options {
+		usage := option.usage
+		if option.env != "" {
+			usage = strings.TrimSpace(fmt.Sprintf("%v (%v)", usage, option.env))
+		}
+		flags.Var(&recorded{name: option.name, values: values, isBool: option.value.Kind() == reflect.Bool, fallback: option.String()}, option.name, usage)
+	}
+
+	if err := flags.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			var usage strings.Builder
+			flags.SetOutput(&usage)
+			flags.PrintDefaults()
+			return nil, "", fmt.Errorf("usage of the service:\n%v", usage.String())
+		}
+		return nil, "", err
+	}
+	if flags.NArg() > 0 {
+		return nil, "", fmt.Errorf("unexpected arguments: %v", flags.Args())
+	}
+	return values, *file, nil
+}
@@ -0,0 +1,357 @@
+package db_service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// context keys read by the audit trail, set by the request middlewares
+const (
+	AuditActorKey     = "actor"
+	AuditRequestIdKey = "request_id"
+	// optional explanation of the changes made by the request, e.g. a merge of duplicates
+	AuditReasonKey = "audit_reason"
+)
+
+const (
+	AuditOperationCreate = "create"
+	AuditOperationUpdate = "update"
+	AuditOperationDelete = "delete"
+)
+
+// AuditChange - change of a single document field, named as in the json representation
+// of the document with nested fields separated by dots
+type AuditChange struct {
+	Field  string      `json:"field" bson:"field"`
+	Before interface{} `json:"before,omitempty" bson:"before,omitempty"`
+	After  interface{} `json:"after,omitempty" bson:"after,omitempty"`
+}
+
+// AuditEntry - single record of the append-only audit collection
+type AuditEntry struct {
+	Id         string        `json:"id" bson:"id"`
+	Collection string        `json:"collection" bson:"collection"`
+	DocumentId string        `json:"document_id" bson:"document_id"`
+	Operation  string        `json:"operation" bson:"operation"`
+	Actor      string        `json:"actor" bson:"actor"`
+	RequestId  string        `json:"request_id,omitempty" bson:"request_id,omitempty"`
+	Reason     string        `json:"reason,omitempty" bson:"reason,omitempty"`
+	Timestamp  time.Time     `json:"timestamp" bson:"timestamp"`
+	Changes    []AuditChange `json:"changes" bson:"changes"`
+}
+
+// AuditIndexes - indexes of the audit collection, supporting the history of a document
+var AuditIndexes = []Index{
+	{Name: "id_unique", Keys: bson.D{{Key: "id", Value: 1}}, Unique: true},
+	{Name: "document_history", Keys: bson.D{{Key: "collection", Value: 1}, {Key: "document_id", Value: 1}, {Key: "timestamp", Value: 1}}},
+}
+
+// AuditTrail is implemented by the db services created with NewAuditedService
+type AuditTrail[DocType interface{}] interface {
+	// History returns the audit entries of the document, oldest first
+	History(ctx context.Context, id string) ([]*AuditEntry, error)
+	// DocumentAt reconstructs the document as it was at the given time
+	DocumentAt(ctx context.Context, id string, at time.Time) (*DocType, error)
+}
+
+// AuditLog - append-only store of the audit entries, the recorded entries are never changed nor deleted
+type AuditLog interface {
+	Append(ctx context.Context, entry *AuditEntry) error
+	Find(ctx context.Context, query Query) ([]*AuditEntry, error)
+}
+
+type auditLog struct {
+	store DbService[AuditEntry]
+}
+
+// NewAuditLog exposes only the appending and the reading of the entries kept by the store
+func NewAuditLog(store DbService[AuditEntry]) AuditLog {
+	return &auditLog{store: store}
+}
+
+func (this *auditLog) Append(ctx context.Context, entry *AuditEntry) error {
+	return this.store.CreateDocument(ctx, entry.Id, entry)
+}
+
+func (this *auditLog) Find(ctx context.Context, query Query) ([]*AuditEntry, error) {
+	return this.store.FindDocuments(ctx, query)
+}
+
+type auditedSvc[DocType interface{}] struct {
+	DbService[DocType]
+	auditLog   AuditLog
+	collection string
+}
+
+// NewAuditedService wraps the service so that every create, update and delete operation is recorded
+// in the audit log in the same transaction, see MongoServiceConfig.Transactions. Without the transactions
+// a failure of the audit log leaves the change made but not recorded.
+func NewAuditedService[DocType interface{}](svc DbService[DocType], auditLog AuditLog, collection string) DbService[DocType] {
+	return &auditedSvc[DocType]{
+		DbService:  svc,
+		auditLog:   auditLog,
+		collection: collection,
+	}
+}
+
+func GetAuditTrail[DocType interface{}](ctx context.Context, ctxKey string) (AuditTrail[DocType], error) {
+	db, err := GetDbService[DocType](ctx, ctxKey)
+	if err != nil {
+		return nil, err
+	}
+
+	trail, ok := db.(AuditTrail[DocType])
+	if !ok {
+		return nil, errors.New("db_service does not keep an audit trail")
+	}
+
+	return trail, nil
+}
+
+func (this *auditedSvc[DocType]) CreateDocument(ctx context.Context, id string, document *DocType) error {
+	return this.WithTransaction(ctx, func(ctx context.Context) error {
+		if err := this.DbService.CreateDocument(ctx, id, document); err != nil {
+			return err
+		}
+		return this.record(ctx, AuditOperationCreate, id, nil, document)
+	})
+}
+
+func (this *auditedSvc[DocType]) CreateDocuments(ctx context.Context, ids []string, documents []*DocType) error {
+	return this.WithTransaction(ctx, func(ctx context.Context) error {
+		if err := this.DbService.CreateDocuments(ctx, ids, documents); err != nil {
+			return err
+		}
+		for index, document := range documents {
+			if err := this.record(ctx, AuditOperationCreate, ids[index], nil, document); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (this *auditedSvc[DocType]) UpdateDocument(ctx context.Context, id string, document *DocType) error {
+	return this.WithTransaction(ctx, func(ctx context.Context) error {
+		before, err := this.DbService.FindDocument(ctx, id)
+		if err != nil {
+			return err
+		}
+		if err := this.DbService.UpdateDocument(ctx, id, document); err != nil {
+			return err
+		}
+		return this.record(ctx, AuditOperationUpdate, id, before, document)
+	})
+}
+
+func (this *auditedSvc[DocType]) DeleteDocument(ctx context.Context, id string) error {
+	return this.WithTransaction(ctx, func(ctx context.Context) error {
+		before, err := this.DbService.FindDocument(ctx, id)
+		if err != nil {
+			return err
+		}
+		if err := this.DbService.DeleteDocument(ctx, id); err != nil {
+			return err
+		}
+		return this.record(ctx, AuditOperationDelete, id, before, nil)
+	})
+}
+
+func (this *auditedSvc[DocType]) BeginTransaction(ctx context.Context) (Transaction[DocType], error) {
+	transaction, err := this.DbService.BeginTransaction(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &auditedTransaction[DocType]{Transaction: transaction, svc: this}, nil
+}
+
+func (this *auditedSvc[DocType]) History(ctx context.Context, id string) ([]*AuditEntry, error) {
+	query := NewQuery().
+		Eq("collection", this.collection).
+		Eq("document_id", id).
+		OrderBy("timestamp", false)
+	return this.auditLog.Find(ctx, query)
+}
+
+func (this *auditedSvc[DocType]) DocumentAt(ctx context.Context, id string, at time.Time) (*DocType, error) {
+	entries, err := this.History(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	// start from the current state and undo the changes made after the requested time,
+	// this works also for documents created before the audit trail was introduced
+	var state map[string]interface{}
+	current, err := this.DbService.FindDocument(ctx, id)
+	switch err {
+	case nil:
+		if state, err = flattenDocument(current); err != nil {
+			return nil, err
+		}
+	case ErrNotFound:
+		// deleted document, the delete entry holds its last state
+	default:
+		return nil, err
+	}
+
+	for i := len(entries) - 1; i >= 0 && entries[i].Timestamp.After(at); i-- {
+		entry := entries[i]
+		switch entry.Operation {
+		case AuditOperationCreate:
+			state = nil
+		case AuditOperationDelete:
+			state = map[string]interface{}{}
+			for _, change := range entry.Changes {
+				state[change.Field] = change.Before
+			}
+		case AuditOperationUpdate:
+			if state == nil {
+				state = map[string]interface{}{}
+			}
+			for _, change := range entry.Changes {
+				if change.Before == nil {
+					delete(state, change.Field)
+				} else {
+					state[change.Field] = change.Before
+				}
+			}
+		}
+	}
+
+	if state == nil {
+		return nil, ErrNotFound
+	}
+
+	data, err := json.Marshal(unflattenDocument(state))
+	if err != nil {
+		return nil, err
+	}
+	var document DocType
+	if err := json.Unmarshal(data, &document); err != nil {
+		return nil, err
+	}
+	return &document, nil
+}
+
+func (this *auditedSvc[DocType]) record(ctx context.Context, operation string, id string, before *DocType, after *DocType) error {
+	changes, err := diffDocuments(before, after)
+	if err != nil {
+		return err
+	}
+
+	entry := &AuditEntry{
+		Id:         uuid.New().String(),
+		Collection: this.collection,
+		DocumentId: id,
+		Operation:  operation,
+		Actor:      "anonymous",
+		Timestamp:  time.Now(),
+		Changes:    changes,
+	}
+	if actor, ok := ctx.Value(AuditActorKey).(string); ok && actor != "" {
+		entry.Actor = actor
+	}
+	if requestId, ok := ctx.Value(AuditRequestIdKey).(string); ok {
+		entry.RequestId = requestId
+	}
+	if reason, ok := ctx.Value(AuditReasonKey).(string); ok {
+		entry.Reason = reason
+	}
+
+	return this.auditLog.Append(ctx, entry)
+}
+
+// auditedTransaction records the entries in the transaction, so that they are committed together with the changes
+type auditedTransaction[DocType interface{}] struct {
+	Transaction[DocType]
+	svc *auditedSvc[DocType]
+}
+
+func (this *auditedTransaction[DocType]) CreateDocument(ctx context.Context, id string, document *DocType) error {
+	if err := this.Transaction.CreateDocument(ctx, id, document); err != nil {
+		return err
+	}
+	return this.svc.record(this.Transaction.Context(ctx), AuditOperationCreate, id, nil, document)
+}
+
+func diffDocuments[DocType interface{}](before *DocType, after *DocType) ([]AuditChange, error) {
+	beforeFields, err := flattenDocument(before)
+	if err != nil {
+		return nil, err
+	}
+	afterFields, err := flattenDocument(after)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := []AuditChange{}
+	for field, value := range afterFields {
+		if previous, found := beforeFields[field]; !found || !reflect.DeepEqual(previous, value) {
+			changes = append(changes, AuditChange{Field: field, Before: previous, After: value})
+		}
+	}
+	for field, value := range beforeFields {
+		if _, found := afterFields[field]; !found {
+			changes = append(changes, AuditChange{Field: field, Before: value})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Field < changes[j].Field })
+	return changes, nil
+}
+
+// flattenDocument converts the document to its json representation
+// with nested objects flattened into dot separated keys
+func flattenDocument[DocType interface{}](document *DocType) (map[string]interface{}, error) {
+	fields := map[string]interface{}{}
+	if document == nil {
+		return fields, nil
+	}
+
+	data, err := json.Marshal(document)
+	if err != nil {
+		return nil, err
+	}
+	var nested map[string]interface{}
+	if err := json.Unmarshal(data, &nested); err != nil {
+		return nil, err
+	}
+
+	var flatten func(prefix string, value map[string]interface{})
+	flatten = func(prefix string, value map[string]interface{}) {
+		for key, item := range value {
+			if inner, ok := item.(map[string]interface{}); ok {
+				flatten(prefix+key+".", inner)
+			} else {
+				fields[prefix+key] = item
+			}
+		}
+	}
+	flatten("", nested)
+	return fields, nil
+}
+
+func unflattenDocument(fields map[string]interface{}) map[string]interface{} {
+	document := map[string]interface{}{}
+	for field, value := range fields {
+		path := strings.Split(field, ".")
+		current := document
+		for _, key := range path[:len(path)-1] {
+			inner, ok := current[key].(map[string]interface{})
+			if !ok {
+				inner = map[string]interface{}{}
+				current[key] = inner
+			}
+			current = inner
+		}
+		current[path[len(path)-1]] = value
+	}
+	return document
+}
@@ -0,0 +1,177 @@
+package db_service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+type auditedPerson struct {
+	Id      string `json:"id"`
+	Name    string `json:"name"`
+	Note    string `json:"note,omitempty"`
+	Address struct {
+		City string `json:"city"`
+	} `json:"address"`
+}
+
+// memoryAuditLog - audit log of the entries in memory, in the order of their recording
+type memoryAuditLog struct {
+	entries []*AuditEntry
+	// called on every append, e.g. to check the transaction of the entry
+	appended func(ctx context.Context)
+}
+
+func (this *memoryAuditLog) Append(ctx context.Context, entry *AuditEntry) error {
+	if this.appended != nil {
+		this.appended(ctx)
+	}
+	this.entries = append(this.entries, entry)
+	return nil
+}
+
+func (this *memoryAuditLog) Find(ctx context.Context, query Query) ([]*AuditEntry, error) {
+	return this.entries, nil
+}
+
+// memoryPeople - documents in memory, run without the transactions
+type memoryPeople struct {
+	DbService[auditedPerson]
+	documents   map[string]auditedPerson
+	transaction Transaction[auditedPerson]
+}
+
+func (this *memoryPeople) FindDocument(ctx context.Context, id string) (*auditedPerson, error) {
+	document, ok := this.documents[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &document, nil
+}
+
+func (this *memoryPeople) CreateDocument(ctx context.Context, id string, document *auditedPerson) error {
+	this.documents[id] = *document
+	return nil
+}
+
+func (this *memoryPeople) UpdateDocument(ctx context.Context, id string, document *auditedPerson) error {
+	this.documents[id] = *document
+	return nil
+}
+
+func (this *memoryPeople) DeleteDocument(ctx context.Context, id string) error {
+	delete(this.documents, id)
+	return nil
+}
+
+func (this *memoryPeople) WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	return fn(ctx)
+}
+
+func (this *memoryPeople) BeginTransaction(ctx context.Context) (Transaction[auditedPerson], error) {
+	return this.transaction, nil
+}
+
+func TestDocumentAt(t *testing.T) {
+	log := &memoryAuditLog{}
+	svc := NewAuditedService[auditedPerson](&memoryPeople{documents: make(map[string]auditedPerson)}, log, "people")
+	ctx := context.Background()
+
+	person := &auditedPerson{Id: "1", Name: "Peter", Note: "first donation"}
+	person.Address.City = "Bratislava"
+	if err := svc.CreateDocument(ctx, "1", person); err != nil {
+		t.Fatal(err)
+	}
+	updated := *person
+	updated.Name, updated.Note = "Peter Marcin", ""
+	updated.Address.City = "Košice"
+	if err := svc.UpdateDocument(ctx, "1", &updated); err != nil {
+		t.Fatal(err)
+	}
+	if err := svc.DeleteDocument(ctx, "1"); err != nil {
+		t.Fatal(err)
+	}
+	// the changes were made a day apart
+	start := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	for index, entry := range log.entries {
+		entry.Timestamp = start.AddDate(0, 0, index)
+	}
+
+	trail, ok := svc.(AuditTrail[auditedPerson])
+	if !ok {
+		t.Fatal("the audited service does not keep the audit trail")
+	}
+	tests := []struct {
+		name string
+		at   time.Time
+		want *auditedPerson
+	}{
+		{"before the creation", start.Add(-time.Hour), nil},
+		{"created", start.Add(time.Hour), person},
+		{"updated", start.AddDate(0, 0, 1).Add(time.Hour), &updated},
+		{"deleted", start.AddDate(0, 0, 2).Add(time.Hour), nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			document, err := trail.DocumentAt(ctx, "1", test.at)
+			switch {
+			case test.want == nil && !errors.Is(err, ErrNotFound):
+				t.Errorf("got %+v %v, want not found", document, err)
+			case test.want != nil && err != nil:
+				t.Errorf("got %v, want %+v", err, *test.want)
+			case test.want != nil && *document != *test.want:
+				t.Errorf("got %+v, want %+v", *document, *test.want)
+			}
+		})
+	}
+}
+
+type transactionKey struct{}
+
+// memoryTransaction - transaction marking the contexts bound to it
+type memoryTransaction struct {
+	committed bool
+}
+
+func (this *memoryTransaction) CreateDocument(ctx context.Context, id string, document *auditedPerson) error {
+	return nil
+}
+
+func (this *memoryTransaction) Context(ctx context.Context) context.Context {
+	return context.WithValue(ctx, transactionKey{}, this)
+}
+
+func (this *memoryTransaction) Commit() error {
+	this.committed = true
+	return nil
+}
+
+func (this *memoryTransaction) Rollback() error {
+	return nil
+}
+
+func TestAuditedTransactionRecordsBeforeCommit(t *testing.T) {
+	transaction := &memoryTransaction{}
+	log := &memoryAuditLog{appended: func(ctx context.Context) {
+		if ctx.Value(transactionKey{}) != transaction || transaction.committed {
+			t.Error("the audit entry is not recorded in the transaction")
+		}
+	}}
+	svc := NewAuditedService[auditedPerson](&memoryPeople{transaction: transaction}, log, "people")
+
+	ctx := context.Background()
+	audited, err := svc.BeginTransaction(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := audited.CreateDocument(ctx, "1", &auditedPerson{Id: "1", Name: "Peter"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := audited.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if len(log.entries) != 1 || log.entries[0].Operation != AuditOperationCreate {
+		t.Errorf("recorded %+v, want the single creation", log.entries)
+	}
+}
@@ -0,0 +1,357 @@
+package db_service
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrResumeNotPossible - the change to resume after is not known to the feed, e.g. it is too old
+var ErrResumeNotPossible = errors.New("the change feed cannot be resumed after the given change")
+
+// server error codes of the change streams which cannot be resumed
+const (
+	codeChangeStreamHistoryLost = 286
+	codeInvalidResumeToken      = 260
+)
+
+// Change - change of a document delivered by a ChangeFeed
+type Change[DocType interface{}] struct {
+	// resumes the feed after this change, see ChangeFeed.Changes
+	Id string
+	// AuditOperationCreate, AuditOperationUpdate or AuditOperationDelete
+	Operation  string
+	DocumentId string
+	// nil on the creation, or when the database did not keep the document before the change
+	Before *DocType
+	// nil on the deletion
+	After     *DocType
+	Timestamp time.Time
+}
+
+// ChangeFeed streams the changes of the documents of a collection
+type ChangeFeed[DocType interface{}] interface {
+	// Changes calls fn with the changes following the one with the resumeAfter id, or with the changes
+	// made from now on if empty, until the context or the feed is done or fn fails. It fails with
+	// ErrResumeNotPossible when the change to resume after is not known, also while streaming
+	// if the changes are not read fast enough.
+	Changes(ctx context.Context, resumeAfter string, fn func(change *Change[DocType]) error) error
+}
+
+func GetChangeFeed[DocType interface{}](ctx context.Context, ctxKey string) (ChangeFeed[DocType], error) {
+	value := ctx.Value(ctxKey)
+	if value == nil {
+		return nil, errors.New("change feed not found")
+	}
+
+	feed, ok := value.(ChangeFeed[DocType])
+	if !ok {
+		return nil, errors.New("cannot cast change feed context to db_service.ChangeFeed")
+	}
+
+	return feed, nil
+}
+
+type mongoChangeFeed[DocType interface{}] struct {
+	MongoServiceConfig
+	done context.Context
+}
+
+// NewMongoChangeFeed streams the changes by the change streams of the collection, which need a replica set
+// or a sharded cluster. The deleted documents are identified by their pre-images, which EnsureSchema enables
+// on MongoDB 6.0 and newer by MongoServiceConfig.PreImages, the deletions without them are skipped.
+// The feed ends the streams once the done context is cancelled.
+func NewMongoChangeFeed[DocType interface{}](done context.Context, config MongoServiceConfig) ChangeFeed[DocType] {
+	if config.DbName == "" {
+		config.DbName = "ss-sprava-krvi"
+	}
+	if config.Timeout == 0 {
+		config.Timeout = 10 * time.Second
+	}
+	return &mongoChangeFeed[DocType]{MongoServiceConfig: config, done: done}
+}
+
+// changeEvent - the fields of the change stream event used by the feed
+type changeEvent struct {
+	Id struct {
+		Data string `bson:"_data"`
+	} `bson:"_id"`
+	OperationType            string              `bson:"operationType"`
+	ClusterTime              primitive.Timestamp `bson:"clusterTime"`
+	FullDocument             bson.Raw            `bson:"fullDocument"`
+	FullDocumentBeforeChange bson.Raw            `bson:"fullDocumentBeforeChange"`
+}
+
+var changeOperations = map[string]string{
+	"insert":  AuditOperationCreate,
+	"update":  AuditOperationUpdate,
+	"replace": AuditOperationUpdate,
+	"delete":  AuditOperationDelete,
+}
+
+func (this *mongoChangeFeed[DocType]) Changes(ctx context.Context, resumeAfter string, fn func(change *Change[DocType]) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	defer context.AfterFunc(this.done, cancel)()
+
+	streamOptions := options.ChangeStream().
+		SetFullDocument(options.UpdateLookup).
+		SetFullDocumentBeforeChange(options.WhenAvailable)
+	if resumeAfter != "" {
+		// the id is the hex encoded _data of the resume token
+		if _, err := hex.DecodeString(resumeAfter); err != nil {
+			return ErrResumeNotPossible
+		}
+		streamOptions.SetResumeAfter(bson.D{{Key: "_data", Value: resumeAfter}})
+	}
+	pipeline := mongo.Pipeline{{{Key: "$match", Value: bson.D{
+		{Key: "operationType", Value: bson.D{{Key: "$in", Value: []string{"insert", "update", "replace", "delete"}}}},
+	}}}}
+
+	// only opening the stream is limited by the timeout, it is read until the context is done
+	openCtx, openCancel := context.WithTimeout(ctx, this.Timeout)
+	defer openCancel()
+	if this.Client == nil {
+		return errNoClient
+	}
+	client, err := this.Client.Connect(openCtx)
+	if err != nil {
+		return err
+	}
+	collection := client.Database(this.DbName).Collection(this.Collection)
+	stream, err := collection.Watch(openCtx, pipeline, streamOptions)
+	if hasErrorCode(err, codeChangeStreamHistoryLost, codeInvalidResumeToken) {
+		return ErrResumeNotPossible
+	} else if err != nil {
+		return err
+	}
+	defer stream.Close(context.Background())
+
+	for stream.Next(ctx) {
+		var event changeEvent
+		if err := stream.Decode(&event); err != nil {
+			return err
+		}
+		change, err := this.toChange(&event)
+		if err != nil {
+			return err
+		}
+		if change.DocumentId == "" {
+			slog.WarnContext(ctx, "Skipping the change of an unknown document, the pre-images are not enabled",
+				"collection", this.Collection, "operation", event.OperationType)
+			continue
+		}
+		if err := fn(change); err != nil {
+			return err
+		}
+	}
+	if ctx.Err() != nil {
+		return nil
+	}
+	if hasErrorCode(stream.Err(), codeChangeStreamHistoryLost) {
+		return ErrResumeNotPossible
+	}
+	return stream.Err()
+}
+
+func (this *mongoChangeFeed[DocType]) toChange(event *changeEvent) (*Change[DocType], error) {
+	change := &Change[DocType]{
+		Id:        event.Id.Data,
+		Operation: changeOperations[event.OperationType],
+		Timestamp: time.Unix(int64(event.ClusterTime.T), 0),
+	}
+	for _, document := range []struct {
+		raw    bson.Raw
+		target **DocType
+	}{
+		{event.FullDocumentBeforeChange, &change.Before},
+		{event.FullDocument, &change.After},
+	} {
+		if len(document.raw) == 0 {
+			continue
+		}
+		*document.target = new(DocType)
+		if err := bson.Unmarshal(document.raw, *document.target); err != nil {
+			return nil, fmt.Errorf("failed to read the changed document: %w", err)
+		}
+		if id, ok := document.raw.Lookup("id").StringValueOK(); ok {
+			change.DocumentId = id
+		}
+	}
+	// the document looked up for an update is nil if it was deleted meanwhile
+	if change.Operation == AuditOperationUpdate && change.After == nil {
+		change.After = change.Before
+	}
+	return change, nil
+}
+
+// ChangeBus - in-process ChangeFeed of the changes made by the services created with NewBroadcastService,
+// meant for a single replica without the change streams. The ids of the changes are unique to the bus,
+// it keeps the given number of the latest ones to resume after.
+type ChangeBus[DocType interface{}] struct {
+	done     context.Context
+	size     int
+	prefix   string
+	mutex    sync.Mutex
+	sequence uint64
+	history  []*Change[DocType]
+	// closed and replaced on every change to wake up the streams
+	changed chan struct{}
+}
+
+// NewChangeBus creates the bus keeping the size latest changes, it ends the streams once the done context is cancelled
+func NewChangeBus[DocType interface{}](done context.Context, size int) *ChangeBus[DocType] {
+	return &ChangeBus[DocType]{
+		done:    done,
+		size:    size,
+		prefix:  uuid.New().String()[:8],
+		changed: make(chan struct{}),
+	}
+}
+
+func (this *ChangeBus[DocType]) Publish(change *Change[DocType]) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.sequence++
+	change.Id = fmt.Sprintf("%v-%v", this.prefix, this.sequence)
+	this.history = append(this.history, change)
+	if len(this.history) > this.size {
+		this.history = this.history[len(this.history)-this.size:]
+	}
+	close(this.changed)
+	this.changed = make(chan struct{})
+}
+
+func (this *ChangeBus[DocType]) Changes(ctx context.Context, resumeAfter string, fn func(change *Change[DocType]) error) error {
+	this.mutex.Lock()
+	last := this.sequence
+	this.mutex.Unlock()
+	if resumeAfter != "" {
+		prefix, sequence, _ := strings.Cut(resumeAfter, "-")
+		resumed, err := strconv.ParseUint(sequence, 10, 64)
+		if err != nil || prefix != this.prefix || resumed > last {
+			return ErrResumeNotPossible
+		}
+		last = resumed
+	}
+
+	for {
+		changes, changed, err := this.after(last)
+		if err != nil {
+			return err
+		}
+		for _, change := range changes {
+			if err := fn(change); err != nil {
+				return err
+			}
+			last++
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-this.done.Done():
+			return nil
+		case <-changed:
+		}
+	}
+}
+
+// after returns the changes following the sequence and the channel closed by the next change
+func (this *ChangeBus[DocType]) after(sequence uint64) ([]*Change[DocType], chan struct{}, error) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	missed := int(this.sequence - sequence)
+	if missed > len(this.history) {
+		return nil, nil, ErrResumeNotPossible
+	}
+	return this.history[len(this.history)-missed:], this.changed, nil
+}
+
+type broadcastSvc[DocType interface{}] struct {
+	DbService[DocType]
+	bus *ChangeBus[DocType]
+}
+
+// NewBroadcastService wraps the service so that every create, update and delete operation is published
+// to the bus once done, inside a transaction once it is committed, see AfterCommit.
+// Wrap it by the audited service, which has to stay outermost to be found by GetAuditTrail.
+func NewBroadcastService[DocType interface{}](svc DbService[DocType], bus *ChangeBus[DocType]) DbService[DocType] {
+	return &broadcastSvc[DocType]{
+		DbService: svc,
+		bus:       bus,
+	}
+}
+
+func (this *broadcastSvc[DocType]) CreateDocument(ctx context.Context, id string, document *DocType) error {
+	if err := this.DbService.CreateDocument(ctx, id, document); err != nil {
+		return err
+	}
+	this.publish(ctx, AuditOperationCreate, id, nil, document)
+	return nil
+}
+
+func (this *broadcastSvc[DocType]) CreateDocuments(ctx context.Context, ids []string, documents []*DocType) error {
+	if err := this.DbService.CreateDocuments(ctx, ids, documents); err != nil {
+		return err
+	}
+	for index, document := range documents {
+		this.publish(ctx, AuditOperationCreate, ids[index], nil, document)
+	}
+	return nil
+}
+
+func (this *broadcastSvc[DocType]) UpdateDocument(ctx context.Context, id string, document *DocType) error {
+	before, err := this.DbService.FindDocument(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err := this.DbService.UpdateDocument(ctx, id, document); err != nil {
+		return err
+	}
+	this.publish(ctx, AuditOperationUpdate, id, before, document)
+	return nil
+}
+
+func (this *broadcastSvc[DocType]) DeleteDocument(ctx context.Context, id string) error {
+	before, err := this.DbService.FindDocument(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err := this.DbService.DeleteDocument(ctx, id); err != nil {
+		return err
+	}
+	this.publish(ctx, AuditOperationDelete, id, before, nil)
+	return nil
+}
+
+func (this *broadcastSvc[DocType]) publish(ctx context.Context, operation string, id string, before *DocType, after *DocType) {
+	// the caller may keep changing the document, the subscribers get a copy
+	if after != nil {
+		copied := *after
+		after = &copied
+	}
+	change := &Change[DocType]{
+		Operation:  operation,
+		DocumentId: id,
+		Before:     before,
+		After:      after,
+		Timestamp:  time.Now(),
+	}
+	AfterCommit(ctx, func() {
+		this.bus.Publish(change)
+	})
+}
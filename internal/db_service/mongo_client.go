@@ -0,0 +1,201 @@
+package db_service
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.opentelemetry.io/contrib/instrumentation/go.mongodb.org/mongo-driver/mongo/otelmongo"
+)
+
+type MongoClientConfig struct {
+	// full connection string, mongodb:// or mongodb+srv://, takes precedence over the host and port
+	Uri        string
+	ServerHost string
+	ServerPort int
+	// override the credentials of the connection string if set
+	UserName      string
+	Password      string
+	AuthSource    string
+	AuthMechanism string
+
+	ReplicaSet string
+	// primary, primaryPreferred, secondary, secondaryPreferred or nearest
+	ReadPreference string
+
+	Tls         bool
+	TlsCaFile   string
+	TlsCertFile string
+	TlsKeyFile  string
+	// skips the verification of the server certificate, meant for the development only
+	TlsInsecure bool
+
+	MaxPoolSize    uint64
+	MinPoolSize    uint64
+	ConnectTimeout time.Duration
+}
+
+// MongoClient - connection pool shared by the services of all collections.
+// The connection is established by the first operation, so that the service starts without the database.
+type MongoClient struct {
+	options    *options.ClientOptions
+	client     atomic.Pointer[mongo.Client]
+	clientLock sync.Mutex
+}
+
+// NewMongoClient validates the configuration and loads the TLS files, it does not connect yet
+func NewMongoClient(config MongoClientConfig) (*MongoClient, error) {
+	clientOptions := options.Client().SetMonitor(otelmongo.NewMonitor())
+
+	if config.Uri != "" {
+		clientOptions.ApplyURI(config.Uri)
+	} else {
+		if config.ServerHost == "" {
+			config.ServerHost = "localhost"
+		}
+		if config.ServerPort == 0 {
+			config.ServerPort = 27017
+		}
+		clientOptions.SetHosts([]string{fmt.Sprintf("%v:%v", config.ServerHost, config.ServerPort)})
+	}
+
+	// credentials are kept out of the URI so that they never end up in error messages
+	if config.UserName != "" || config.AuthMechanism != "" {
+		clientOptions.SetAuth(options.Credential{
+			AuthMechanism: config.AuthMechanism,
+			AuthSource:    config.AuthSource,
+			Username:      config.UserName,
+			Password:      config.Password,
+			PasswordSet:   config.Password != "",
+		})
+	} else if config.AuthSource != "" && clientOptions.Auth != nil {
+		clientOptions.Auth.AuthSource = config.AuthSource
+	}
+
+	if config.ReplicaSet != "" {
+		clientOptions.SetReplicaSet(config.ReplicaSet)
+	}
+
+	if config.ReadPreference != "" {
+		mode, err := readpref.ModeFromString(config.ReadPreference)
+		if err != nil {
+			return nil, err
+		}
+		preference, err := readpref.New(mode)
+		if err != nil {
+			return nil, err
+		}
+		clientOptions.SetReadPreference(preference)
+	}
+
+	if config.Tls || config.TlsCaFile != "" || config.TlsCertFile != "" {
+		tlsConfig, err := loadTlsConfig(config)
+		if err != nil {
+			return nil, err
+		}
+		clientOptions.SetTLSConfig(tlsConfig)
+	}
+
+	if config.MaxPoolSize > 0 {
+		clientOptions.SetMaxPoolSize(config.MaxPoolSize)
+	}
+	if config.MinPoolSize > 0 {
+		clientOptions.SetMinPoolSize(config.MinPoolSize)
+	}
+	if config.ConnectTimeout == 0 {
+		config.ConnectTimeout = 10 * time.Second
+	}
+	clientOptions.SetConnectTimeout(config.ConnectTimeout)
+	clientOptions.SetServerSelectionTimeout(config.ConnectTimeout)
+
+	if err := clientOptions.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid MongoDB options: %w", err)
+	}
+	return &MongoClient{options: clientOptions}, nil
+}
+
+func loadTlsConfig(config MongoClientConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: config.TlsInsecure,
+	}
+
+	if config.TlsCaFile != "" {
+		pem, err := os.ReadFile(config.TlsCaFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the MongoDB CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("MongoDB CA file does not contain any PEM certificate")
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if config.TlsCertFile != "" || config.TlsKeyFile != "" {
+		if config.TlsKeyFile == "" {
+			// the key may be bundled with the certificate as mongo tools expect
+			config.TlsKeyFile = config.TlsCertFile
+		}
+		certificate, err := tls.LoadX509KeyPair(config.TlsCertFile, config.TlsKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load the MongoDB client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+	return tlsConfig, nil
+}
+
+// Connect returns the connected client, connecting on the first call
+func (this *MongoClient) Connect(ctx context.Context) (*mongo.Client, error) {
+	// optimistic check
+	client := this.client.Load()
+	if client != nil {
+		return client, nil
+	}
+
+	this.clientLock.Lock()
+	defer this.clientLock.Unlock()
+	// pesimistic check
+	client = this.client.Load()
+	if client != nil {
+		return client, nil
+	}
+
+	client, err := mongo.Connect(ctx, this.options)
+	if err != nil {
+		return nil, err
+	}
+	this.client.Store(client)
+	return client, nil
+}
+
+func (this *MongoClient) Disconnect(ctx context.Context) error {
+	this.clientLock.Lock()
+	defer this.clientLock.Unlock()
+
+	client := this.client.Swap(nil)
+	if client == nil {
+		return nil
+	}
+	return client.Disconnect(ctx)
+}
+
+// Ping checks the connection using the configured read preference,
+// the caller is expected to limit the duration by the context
+func (this *MongoClient) Ping(ctx context.Context) error {
+	client, err := this.Connect(ctx)
+	if err != nil {
+		return err
+	}
+	return client.Ping(ctx, nil)
+}
@@ -0,0 +1,114 @@
+package db_service
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Counters - named counters and leases, each stored as a document with the name as its id
+type Counters interface {
+	// Next increments the counter and returns its new value. In a transaction the counter stays locked
+	// until the commit, so the concurrent transactions commit its values in their order.
+	Next(ctx context.Context, name string) (int64, error)
+	// Acquire takes or renews the lease for the owner unless another owner holds it and it did not expire yet,
+	// it reports whether the owner holds the lease
+	Acquire(ctx context.Context, name string, owner string, lease time.Duration) (bool, error)
+	// Release gives the lease of the owner up before it expires
+	Release(ctx context.Context, name string, owner string) error
+}
+
+type mongoCounters struct {
+	MongoServiceConfig
+}
+
+// NewMongoCounters keeps the counters and the leases in the collection of the config, the indexes are not used
+func NewMongoCounters(config MongoServiceConfig) Counters {
+	if config.DbName == "" {
+		config.DbName = "ss-sprava-krvi"
+	}
+	if config.Timeout == 0 {
+		config.Timeout = 10 * time.Second
+	}
+	return &mongoCounters{MongoServiceConfig: config}
+}
+
+func (this *mongoCounters) collection(ctx context.Context) (*mongo.Collection, error) {
+	if this.Client == nil {
+		return nil, errNoClient
+	}
+	client, err := this.Client.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return client.Database(this.DbName).Collection(this.Collection), nil
+}
+
+func (this *mongoCounters) Next(ctx context.Context, name string) (int64, error) {
+	ctx, contextCancel := context.WithTimeout(ctx, this.Timeout)
+	defer contextCancel()
+	collection, err := this.collection(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	var counter struct {
+		Value int64 `bson:"value"`
+	}
+	err = collection.FindOneAndUpdate(ctx,
+		bson.D{{Key: "_id", Value: name}},
+		bson.D{{Key: "$inc", Value: bson.D{{Key: "value", Value: int64(1)}}}},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&counter)
+	return counter.Value, err
+}
+
+func (this *mongoCounters) Acquire(ctx context.Context, name string, owner string, lease time.Duration) (bool, error) {
+	ctx, contextCancel := context.WithTimeout(ctx, this.Timeout)
+	defer contextCancel()
+	collection, err := this.collection(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	now := time.Now()
+	filter := bson.D{
+		{Key: "_id", Value: name},
+		{Key: "$or", Value: bson.A{
+			bson.D{{Key: "owner", Value: owner}},
+			bson.D{{Key: "locked_until", Value: bson.D{{Key: "$lt", Value: now}}}},
+		}},
+	}
+	update := bson.D{{Key: "$set", Value: bson.D{
+		{Key: "owner", Value: owner},
+		{Key: "locked_until", Value: now.Add(lease)},
+	}}}
+	// the upsert conflicts on the id when the lease is held by another owner
+	err = collection.FindOneAndUpdate(ctx, filter, update, options.FindOneAndUpdate().SetUpsert(true)).Err()
+	switch {
+	case err == nil, errors.Is(err, mongo.ErrNoDocuments):
+		return true, nil
+	case mongo.IsDuplicateKeyError(err):
+		return false, nil
+	default:
+		return false, err
+	}
+}
+
+func (this *mongoCounters) Release(ctx context.Context, name string, owner string) error {
+	ctx, contextCancel := context.WithTimeout(ctx, this.Timeout)
+	defer contextCancel()
+	collection, err := this.collection(ctx)
+	if err != nil {
+		return err
+	}
+
+	filter := bson.D{{Key: "_id", Value: name}, {Key: "owner", Value: owner}}
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "locked_until", Value: time.Time{}}}}}
+	_, err = collection.UpdateOne(ctx, filter, update)
+	return err
+}
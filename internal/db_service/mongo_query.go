@@ -0,0 +1,86 @@
+package db_service
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// sorts after every other character, also in the ICU collations
+const maxCollationChar = "\uffff"
+
+var mongoOperators = map[Operator]string{
+	OpEq:     "$eq",
+	OpNe:     "$ne",
+	OpIn:     "$in",
+	OpLt:     "$lt",
+	OpLte:    "$lte",
+	OpGt:     "$gt",
+	OpGte:    "$gte",
+	OpExists: "$exists",
+}
+
+// mongoFind translates the query to the filter and the find options,
+// the conditions of the same field are merged so that the ranges work
+func mongoFind(query Query) (bson.D, *options.FindOptions, error) {
+	if err := query.Validate(); err != nil {
+		return nil, nil, err
+	}
+
+	filter := bson.D{}
+	fields := make(map[string]int)
+	for _, condition := range query.Conditions {
+		index, found := fields[condition.Field]
+		if !found {
+			index = len(filter)
+			fields[condition.Field] = index
+			filter = append(filter, bson.E{Key: condition.Field, Value: bson.D{}})
+		}
+		operators := filter[index].Value.(bson.D)
+		if condition.Operator == OpPrefix {
+			// a range instead of a regular expression, so that the collation and the indexes apply
+			prefix := condition.Value.(string)
+			operators = append(operators, bson.E{Key: "$gte", Value: prefix}, bson.E{Key: "$lt", Value: prefix + maxCollationChar})
+		} else {
+			operators = append(operators, bson.E{Key: mongoOperators[condition.Operator], Value: condition.Value})
+		}
+		filter[index].Value = operators
+	}
+	if query.Text != "" {
+		filter = append(filter, bson.E{Key: "$text", Value: bson.D{{Key: "$search", Value: query.Text}}})
+	}
+
+	findOptions := options.Find()
+	if len(query.Sort) > 0 {
+		sort := bson.D{}
+		for _, field := range query.Sort {
+			direction := 1
+			if field.Descending {
+				direction = -1
+			}
+			sort = append(sort, bson.E{Key: field.Field, Value: direction})
+		}
+		findOptions.SetSort(sort)
+	}
+	if query.Locale != "" {
+		findOptions.SetCollation(insensitiveCollation(query.Locale))
+	}
+	if query.Limit > 0 {
+		findOptions.SetLimit(query.Limit)
+	}
+	if query.Skip > 0 {
+		findOptions.SetSkip(query.Skip)
+	}
+	if len(query.Fields) > 0 {
+		projection := bson.D{}
+		for _, field := range query.Fields {
+			projection = append(projection, bson.E{Key: field, Value: 1})
+		}
+		findOptions.SetProjection(projection)
+	}
+	return filter, findOptions, nil
+}
+
+// insensitiveCollation ignores the case and the diacritics, e.g. Šťastný matches stastny
+func insensitiveCollation(locale string) *options.Collation {
+	return &options.Collation{Locale: locale, Strength: 1}
+}
@@ -0,0 +1,190 @@
+package db_service
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type searchedPerson struct {
+	Id        string `bson:"id"`
+	FirstName string `bson:"first_name"`
+	LastName  string `bson:"last_name"`
+}
+
+func TestMongoFind(t *testing.T) {
+	since := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		query   Query
+		filter  bson.D
+		options *options.FindOptions
+	}{
+		{
+			name:    "no conditions",
+			query:   NewQuery(),
+			filter:  bson.D{},
+			options: options.Find(),
+		},
+		{
+			name:  "conditions of the same field are merged",
+			query: NewQuery().Eq("blood_type", "A").Gte("last_donation", since).Lt("last_donation", since.AddDate(1, 0, 0)),
+			filter: bson.D{
+				{Key: "blood_type", Value: bson.D{{Key: "$eq", Value: "A"}}},
+				{Key: "last_donation", Value: bson.D{{Key: "$gte", Value: since}, {Key: "$lt", Value: since.AddDate(1, 0, 0)}}},
+			},
+			options: options.Find(),
+		},
+		{
+			name:    "prefix is a range",
+			query:   NewQuery().Prefix("last_name", "Nov").Insensitive("sk"),
+			filter:  bson.D{{Key: "last_name", Value: bson.D{{Key: "$gte", Value: "Nov"}, {Key: "$lt", Value: "Nov" + maxCollationChar}}}},
+			options: options.Find().SetCollation(&options.Collation{Locale: "sk", Strength: 1}),
+		},
+		{
+			name:    "list and existence",
+			query:   NewQuery().In("status", "available", "reserved").Exists("donor_id", true),
+			filter:  bson.D{{Key: "status", Value: bson.D{{Key: "$in", Value: []interface{}{"available", "reserved"}}}}, {Key: "donor_id", Value: bson.D{{Key: "$exists", Value: true}}}},
+			options: options.Find(),
+		},
+		{
+			name:    "text search",
+			query:   NewQuery().Search("Marcin").Eq("eligible", true),
+			filter:  bson.D{{Key: "eligible", Value: bson.D{{Key: "$eq", Value: true}}}, {Key: "$text", Value: bson.D{{Key: "$search", Value: "Marcin"}}}},
+			options: options.Find(),
+		},
+		{
+			name:    "sort, page and projection",
+			query:   NewQuery().OrderBy("last_name", false).OrderBy("created_at", true).Take(20).Offset(40).Select("id", "last_name"),
+			filter:  bson.D{},
+			options: options.Find().SetSort(bson.D{{Key: "last_name", Value: 1}, {Key: "created_at", Value: -1}}).SetLimit(20).SetSkip(40).SetProjection(bson.D{{Key: "id", Value: 1}, {Key: "last_name", Value: 1}}),
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			filter, findOptions, err := mongoFind(test.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fmt.Sprint(filter) != fmt.Sprint(test.filter) {
+				t.Errorf("filter %v, want %v", filter, test.filter)
+			}
+			if !reflect.DeepEqual(findOptions, test.options) {
+				t.Errorf("options %+v, want %+v", *findOptions, *test.options)
+			}
+		})
+	}
+}
+
+func TestMongoFindRejectsInvalidQueries(t *testing.T) {
+	tests := []struct {
+		name  string
+		query Query
+	}{
+		{"operator as the field", NewQuery().Eq("$where", "1")},
+		{"operator in the path", NewQuery().Eq("contents.$gt", 1)},
+		{"empty path segment", NewQuery().OrderBy("contents..volume", false)},
+		{"negative page", NewQuery().Offset(-1)},
+		{"projection of an operator", NewQuery().Select("$expr")},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, _, err := mongoFind(test.query); err == nil {
+				t.Error("expected the query to be rejected")
+			}
+		})
+	}
+}
+
+func TestTextSearchRejectsInsensitiveComparison(t *testing.T) {
+	query := NewQuery().Prefix("first_name", "pet").Insensitive("en").Search("Marcin")
+	if _, _, err := mongoFind(query); err == nil {
+		t.Fatal("expected the text search with the collation to be rejected")
+	}
+}
+
+// testMongoService binds a fresh collection of the server given by API_TEST_MONGODB_URI, the test is skipped without it
+func testMongoService[DocType interface{}](t *testing.T, indexes []Index) DbService[DocType] {
+	uri := os.Getenv("API_TEST_MONGODB_URI")
+	if uri == "" {
+		t.Skip("API_TEST_MONGODB_URI is not set")
+	}
+	client, err := NewMongoClient(MongoClientConfig{Uri: uri})
+	if err != nil {
+		t.Fatal(err)
+	}
+	collection := "test_" + uuid.New().String()[:8]
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if connected, err := client.Connect(ctx); err == nil {
+			connected.Database("ss-sprava-krvi-test").Collection(collection).Drop(ctx)
+		}
+		client.Disconnect(ctx)
+	})
+
+	svc := NewMongoService[DocType](MongoServiceConfig{
+		Client:     client,
+		DbName:     "ss-sprava-krvi-test",
+		Collection: collection,
+		Indexes:    indexes,
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := svc.EnsureSchema(ctx); err != nil {
+		t.Fatal(err)
+	}
+	return svc
+}
+
+func TestMongoSearches(t *testing.T) {
+	svc := testMongoService[searchedPerson](t, []Index{
+		{Name: "first_name_insensitive", Keys: bson.D{{Key: "first_name", Value: 1}}, Locale: "en"},
+		{Name: "name_text", Keys: bson.D{{Key: "first_name", Value: "text"}, {Key: "last_name", Value: "text"}}, TextLanguage: "none"},
+	})
+	ctx := context.Background()
+	for _, person := range []*searchedPerson{
+		{Id: "1", FirstName: "Šimon", LastName: "Šťastný"},
+		{Id: "2", FirstName: "Peter", LastName: "Marcin"},
+	} {
+		if err := svc.CreateDocument(ctx, person.Id, person); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name  string
+		query Query
+		ids   []string
+	}{
+		{"insensitive prefix", NewQuery().Prefix("first_name", "simo").Insensitive("en"), []string{"1"}},
+		{"text search", NewQuery().Search("Marcin"), []string{"2"}},
+		{"text search and exact filter", NewQuery().Search("Marcin").Eq("first_name", "Peter"), []string{"2"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			found, err := svc.FindDocuments(ctx, test.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var ids []string
+			for _, person := range found {
+				ids = append(ids, person.Id)
+			}
+			if len(ids) != len(test.ids) || (len(ids) > 0 && ids[0] != test.ids[0]) {
+				t.Errorf("found %v, want %v", ids, test.ids)
+			}
+		})
+	}
+
+	if _, err := svc.FindDocuments(ctx, NewQuery().Search("Marcin").Prefix("first_name", "pe").Insensitive("en")); err == nil {
+		t.Error("expected the text search with the collation to be rejected")
+	}
+}
@@ -0,0 +1,222 @@
+package db_service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Index - index the db service needs, created by EnsureSchema
+type Index struct {
+	Name   string
+	Keys   bson.D
+	Unique bool
+	// limits the index to the matching documents, e.g. to those having the unique field
+	PartialFilter bson.D
+	// case and accent insensitive index used by the queries of the same locale, see Query.Locale
+	Locale string
+	// language of the text index, none disables the stemming and the stop words
+	TextLanguage string
+	// deletes the documents once the indexed date is older, 0 keeps them
+	ExpireAfter time.Duration
+}
+
+// server error codes, see https://www.mongodb.com/docs/manual/reference/error-codes/
+const (
+	codeNamespaceExists        = 48
+	codeIndexOptionsConflict   = 85
+	codeIndexKeySpecsConflict  = 86
+	codeIndexNotFound          = 27
+	codeNamespaceNotFound      = 26
+	codeIndexAlreadyExistsName = 68
+	codeDuplicateKey           = 11000
+)
+
+// EnsureSchema creates the collection and the declared indexes if missing.
+// An index with the same keys but different options, e.g. created by an older version, is replaced.
+// A unique index violated by the existing documents is skipped with their ids logged, until they are fixed.
+func (this *mongoSvc[DocType]) EnsureSchema(ctx context.Context) error {
+	client, err := this.connect(ctx)
+	if err != nil {
+		return err
+	}
+	db := client.Database(this.DbName)
+
+	if err := db.CreateCollection(ctx, this.Collection); err != nil && !hasErrorCode(err, codeNamespaceExists) {
+		return err
+	}
+
+	if this.PreImages {
+		// needs MongoDB 6.0, the change streams work without the pre-images except for the deletions
+		command := bson.D{
+			{Key: "collMod", Value: this.Collection},
+			{Key: "changeStreamPreAndPostImages", Value: bson.D{{Key: "enabled", Value: true}}},
+		}
+		if err := db.RunCommand(ctx, command).Err(); err != nil {
+			slog.WarnContext(ctx, "Failed to enable the pre-images, the deletions are not streamed", "collection", this.Collection, "error", err)
+		}
+	}
+
+	collection := db.Collection(this.Collection)
+	for _, index := range this.Indexes {
+		model := mongo.IndexModel{
+			Keys:    index.Keys,
+			Options: options.Index().SetName(index.Name),
+		}
+		if index.Unique {
+			model.Options.SetUnique(true)
+		}
+		if index.PartialFilter != nil {
+			model.Options.SetPartialFilterExpression(index.PartialFilter)
+		}
+		if index.Locale != "" {
+			model.Options.SetCollation(insensitiveCollation(index.Locale))
+		}
+		if index.TextLanguage != "" {
+			model.Options.SetDefaultLanguage(index.TextLanguage)
+		}
+		if index.ExpireAfter > 0 {
+			model.Options.SetExpireAfterSeconds(int32(index.ExpireAfter.Seconds()))
+		}
+
+		_, err := collection.Indexes().CreateOne(ctx, model)
+		if hasErrorCode(err, codeIndexOptionsConflict, codeIndexKeySpecsConflict, codeIndexAlreadyExistsName) {
+			slog.WarnContext(ctx, "Replacing conflicting index", "collection", this.Collection, "index", index.Name)
+			if err := dropConflictingIndexes(ctx, collection, index); err != nil {
+				return err
+			}
+			_, err = collection.Indexes().CreateOne(ctx, model)
+		}
+		if index.Unique && hasErrorCode(err, codeDuplicateKey) {
+			// the existing documents have to be fixed by hand, e.g. the duplicate donors merged,
+			// the service runs meanwhile without the constraint instead of never getting ready
+			duplicates, reportErr := findDuplicates(ctx, collection, index)
+			slog.ErrorContext(ctx, "Unique index not created, the documents violate it",
+				"collection", this.Collection, "index", index.Name, "duplicates", duplicates, "error", errors.Join(err, reportErr))
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	slog.InfoContext(ctx, "MongoDB schema ensured", "collection", this.Collection, "indexes", len(this.Indexes))
+	return nil
+}
+
+// maximum of the duplicate groups reported by findDuplicates
+const maxReportedDuplicates = 20
+
+// findDuplicates lists the ids of the documents sharing the keys of the unique index, grouped by the keys.
+// Only the ids are reported, the keys may be personal data.
+func findDuplicates(ctx context.Context, collection *mongo.Collection, index Index) ([][]string, error) {
+	group := bson.D{}
+	for _, key := range index.Keys {
+		group = append(group, bson.E{Key: strings.ReplaceAll(key.Key, ".", "_"), Value: "$" + key.Key})
+	}
+	pipeline := mongo.Pipeline{}
+	if index.PartialFilter != nil {
+		pipeline = append(pipeline, bson.D{{Key: "$match", Value: index.PartialFilter}})
+	}
+	pipeline = append(pipeline,
+		bson.D{{Key: "$group", Value: bson.D{
+			{Key: "_id", Value: group},
+			{Key: "ids", Value: bson.D{{Key: "$push", Value: "$id"}}},
+			{Key: "count", Value: bson.D{{Key: "$sum", Value: 1}}},
+		}}},
+		bson.D{{Key: "$match", Value: bson.D{{Key: "count", Value: bson.D{{Key: "$gt", Value: 1}}}}}},
+		bson.D{{Key: "$limit", Value: maxReportedDuplicates}},
+	)
+	aggregateOptions := options.Aggregate()
+	if index.Locale != "" {
+		aggregateOptions.SetCollation(insensitiveCollation(index.Locale))
+	}
+
+	cursor, err := collection.Aggregate(ctx, pipeline, aggregateOptions)
+	if err != nil {
+		return nil, err
+	}
+	var groups []struct {
+		Ids []string `bson:"ids"`
+	}
+	if err := cursor.All(ctx, &groups); err != nil {
+		return nil, err
+	}
+	var duplicates [][]string
+	for _, group := range groups {
+		duplicates = append(duplicates, group.Ids)
+	}
+	return duplicates, nil
+}
+
+// dropConflictingIndexes drops the indexes having either the name or the keys of the declared index
+func dropConflictingIndexes(ctx context.Context, collection *mongo.Collection, index Index) error {
+	cursor, err := collection.Indexes().List(ctx)
+	if err != nil {
+		return err
+	}
+	var existing []struct {
+		Name string `bson:"name"`
+		Key  bson.D `bson:"key"`
+	}
+	if err := cursor.All(ctx, &existing); err != nil {
+		return err
+	}
+
+	for _, other := range existing {
+		if other.Name == "_id_" || (other.Name != index.Name && !sameKeys(index.Keys, other.Key)) {
+			continue
+		}
+		if _, err := collection.Indexes().DropOne(ctx, other.Name); err != nil && !hasErrorCode(err, codeIndexNotFound, codeNamespaceNotFound) {
+			return err
+		}
+	}
+	return nil
+}
+
+// sameKeys compares the index keys regardless of the numeric type,
+// the indexes created by mongosh have the directions stored as doubles
+func sameKeys(a bson.D, b bson.D) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Key != b[i].Key || fmt.Sprint(toNumber(a[i].Value)) != fmt.Sprint(toNumber(b[i].Value)) {
+			return false
+		}
+	}
+	return true
+}
+
+func toNumber(value interface{}) interface{} {
+	switch value := value.(type) {
+	case int:
+		return float64(value)
+	case int32:
+		return float64(value)
+	case int64:
+		return float64(value)
+	default:
+		return value
+	}
+}
+
+func hasErrorCode(err error, codes ...int) bool {
+	var serverError mongo.ServerError
+	if !errors.As(err, &serverError) {
+		return false
+	}
+	for _, code := range codes {
+		if serverError.HasErrorCode(code) {
+			return true
+		}
+	}
+	return false
+}
@@ -2,16 +2,13 @@ package db_service
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
-	"os"
+	"log/slog"
+	"strings"
+	"sync"
 
 	// "reflect"
-	"strconv"
-	"sync"
-	"sync/atomic"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
@@ -23,6 +20,8 @@ type Transaction[DocType interface{}] interface {
 	Commit() error
 	Rollback() error
 	CreateDocument(ctx context.Context, id string, document *DocType) error
+	// Context binds the context to the transaction, the operations of all db services made with it join the transaction
+	Context(ctx context.Context) context.Context
 }
 
 type mongoTransaction[DocType interface{}] struct {
@@ -33,24 +32,17 @@ type mongoTransaction[DocType interface{}] struct {
 }
 
 func (this *mongoTransaction[DocType]) CreateDocument(ctx context.Context, id string, document *DocType) error {
-	ctx, contextCancel := context.WithTimeout(ctx, this.Timeout)
+	ctx, contextCancel := context.WithTimeout(this.Context(ctx), this.Timeout)
 	defer contextCancel()
 
 	db := this.session.Client().Database(this.DbName)
 	collection := db.Collection(this.Collection)
-	result := collection.FindOne(ctx, bson.D{{Key: "id", Value: id}})
-	switch result.Err() {
-	case nil: // no error means there is conflicting document
-		return ErrConflict
-	case mongo.ErrNoDocuments:
-		// do nothing, this is expected
-	default: // other errors - return them
-		return result.Err()
-	}
-
 	_, err := collection.InsertOne(ctx, document)
-	log.Printf("Insert error%v", err)
-	return err
+	return conflictOf(err, id)
+}
+
+func (this *mongoTransaction[DocType]) Context(ctx context.Context) context.Context {
+	return mongo.NewSessionContext(ctx, this.session)
 }
 
 func (t *mongoTransaction[DocType]) Commit() error {
@@ -69,145 +61,101 @@ type DbService[DocType interface{}] interface {
 	CreateDocument(ctx context.Context, id string, document *DocType) error
 	CreateDocuments(ctx context.Context, ids []string, documents []*DocType) error
 	FindDocument(ctx context.Context, id string) (*DocType, error)
-	FindDocuments(ctx context.Context, filter interface{}) ([]*DocType, error)
+	FindDocuments(ctx context.Context, query Query) ([]*DocType, error)
+	// StreamDocuments calls the function for each found document as it is read, without holding
+	// all of them in memory. The iteration stops at the first error of the function, which is returned.
+	StreamDocuments(ctx context.Context, query Query, fn func(document *DocType) error) error
 	UpdateDocument(ctx context.Context, id string, document *DocType) error
 	DeleteDocument(ctx context.Context, id string) error
 	BeginTransaction(ctx context.Context) (Transaction[DocType], error)
+	// WithTransaction runs the function in a transaction, the operations of all db services
+	// called with the context passed to the function take part in it
+	WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error
+	HealthCheck(ctx context.Context) error
+	// EnsureSchema creates the collection and its indexes, skipped by the read-only deployments
+	EnsureSchema(ctx context.Context) error
 	Disconnect(ctx context.Context) error
 }
 
 var ErrNotFound = fmt.Errorf("document not found")
 var ErrConflict = fmt.Errorf("conflict: document already exists")
 
+// ConflictError - the documents were not created because they violate a unique index, matches ErrConflict
+type ConflictError struct {
+	// ids of the conflicting documents
+	Ids []string
+}
+
+func (this *ConflictError) Error() string {
+	return fmt.Sprintf("%v: %v", ErrConflict, strings.Join(this.Ids, ", "))
+}
+
+func (this *ConflictError) Is(target error) bool {
+	return target == ErrConflict
+}
+
 type MongoServiceConfig struct {
-	ServerHost string
-	ServerPort int
-	UserName   string
-	Password   string
+	// shared connection pool, see NewMongoClient
+	Client     *MongoClient
 	DbName     string
 	Collection string
 	Timeout    time.Duration
+	// indexes created by EnsureSchema
+	Indexes []Index
+	// WithTransaction runs the operations in a transaction, which needs a replica set or a sharded cluster.
+	// Disabled for a standalone server, the operations are run one by one and are not rolled back on a failure.
+	Transactions bool
+	// EnsureSchema enables the pre-images of the changed documents for the change streams, see NewMongoChangeFeed
+	PreImages bool
 }
 
 type mongoSvc[DocType interface{}] struct {
 	MongoServiceConfig
-	client     atomic.Pointer[mongo.Client]
-	clientLock sync.Mutex
 }
 
-func NewMongoService[DocType interface{}](config MongoServiceConfig) DbService[DocType] {
-	enviro := func(name string, defaultValue string) string {
-		if value, ok := os.LookupEnv(name); ok {
-			return value
-		}
-		return defaultValue
-	}
+var errNoClient = errors.New("mongo client is not configured")
 
+func NewMongoService[DocType interface{}](config MongoServiceConfig) DbService[DocType] {
 	svc := &mongoSvc[DocType]{}
 	svc.MongoServiceConfig = config
 
-	if svc.ServerHost == "" {
-		svc.ServerHost = enviro("API_MONGODB_HOST", "localhost")
-	}
-
-	if svc.ServerPort == 0 {
-		port := enviro("API_MONGODB_PORT", "27017")
-		if port, err := strconv.Atoi(port); err == nil {
-			svc.ServerPort = port
-		} else {
-			log.Printf("Invalid port value: %v", port)
-			svc.ServerPort = 27017
-		}
-	}
-
-	if svc.UserName == "" {
-		svc.UserName = enviro("API_MONGODB_USERNAME", "")
-	}
-
-	if svc.Password == "" {
-		svc.Password = enviro("API_MONGODB_PASSWORD", "")
-	}
-
 	if svc.DbName == "" {
-		svc.DbName = enviro("API_MONGODB_DATABASE", "ss-sprava-krvi")
+		svc.DbName = "ss-sprava-krvi"
 	}
 
-	// if svc.Collection == "" {
-	// 	svc.Collection = enviro("API_MONGODB_COLLECTION", "donor")
-	// }
-
 	if svc.Timeout == 0 {
-		seconds := enviro("API_MONGODB_TIMEOUT_SECONDS", "10")
-		if seconds, err := strconv.Atoi(seconds); err == nil {
-			svc.Timeout = time.Duration(seconds) * time.Second
-		} else {
-			log.Printf("Invalid timeout value: %v", seconds)
-			svc.Timeout = 10 * time.Second
-		}
+		svc.Timeout = 10 * time.Second
 	}
 
-	log.Printf(
-		"MongoDB config: //%v@%v:%v/%v/%v",
-		svc.UserName,
-		svc.ServerHost,
-		svc.ServerPort,
-		svc.DbName,
-		svc.Collection,
+	slog.Info("MongoDB config",
+		"database", svc.DbName,
+		"collection", svc.Collection,
+		"timeout", svc.Timeout.String(),
+		"transactions", svc.Transactions,
 	)
 	return svc
 }
 
 func (this *mongoSvc[DocType]) connect(ctx context.Context) (*mongo.Client, error) {
-	// optimistic check
-	client := this.client.Load()
-	if client != nil {
-		return client, nil
-	}
-
-	this.clientLock.Lock()
-	defer this.clientLock.Unlock()
-	// pesimistic check
-	client = this.client.Load()
-	if client != nil {
-		return client, nil
-	}
-
-	ctx, contextCancel := context.WithTimeout(ctx, this.Timeout)
-	defer contextCancel()
-
-	var uri = fmt.Sprintf("mongodb://%v:%v", this.ServerHost, this.ServerPort)
-	log.Printf("Using URI: " + uri)
-
-	if len(this.UserName) != 0 {
-		uri = fmt.Sprintf("mongodb://%v:%v@%v:%v", this.UserName, this.Password, this.ServerHost, this.ServerPort)
-	}
-
-	if client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri).SetConnectTimeout(10*time.Second)); err != nil {
-		return nil, err
-	} else {
-		this.client.Store(client)
-		return client, nil
+	if this.Client == nil {
+		return nil, errNoClient
 	}
+	return this.Client.Connect(ctx)
 }
 
+// Disconnect does nothing, the shared client is disconnected by its owner
 func (this *mongoSvc[DocType]) Disconnect(ctx context.Context) error {
-	client := this.client.Load()
-
-	if client != nil {
-		this.clientLock.Lock()
-		defer this.clientLock.Unlock()
-
-		client = this.client.Load()
-		defer this.client.Store(nil)
-		if client != nil {
-			if err := client.Disconnect(ctx); err != nil {
-				return err
-			}
-		}
-	}
 	return nil
 }
 
+// HealthCheck pings the database server, the caller is expected to limit the duration by the context
+func (this *mongoSvc[DocType]) HealthCheck(ctx context.Context) error {
+	if this.Client == nil {
+		return errNoClient
+	}
+	return this.Client.Ping(ctx)
+}
+
 func GetDbService[DocType interface{}](ctx context.Context, ctxKey string) (DbService[DocType], error) {
 	value := ctx.Value(ctxKey)
 	if value == nil {
@@ -221,6 +169,8 @@ func GetDbService[DocType interface{}](ctx context.Context, ctxKey string) (DbSe
 
 	return db, nil
 }
+
+// CreateDocument relies on the unique index on the id, see EnsureSchema, instead of looking the id up first
 func (this *mongoSvc[DocType]) CreateDocument(ctx context.Context, id string, document *DocType) error {
 	ctx, contextCancel := context.WithTimeout(ctx, this.Timeout)
 	defer contextCancel()
@@ -230,21 +180,23 @@ func (this *mongoSvc[DocType]) CreateDocument(ctx context.Context, id string, do
 	}
 	db := client.Database(this.DbName)
 	collection := db.Collection(this.Collection)
-	result := collection.FindOne(ctx, bson.D{{Key: "id", Value: id}})
-	switch result.Err() {
-	case nil: // no error means there is conflicting document
-		return ErrConflict
-	case mongo.ErrNoDocuments:
-		// do nothing, this is expected
-	default: // other errors - return them
-		return result.Err()
-	}
 
 	_, err = collection.InsertOne(ctx, document)
-	return err
+	return conflictOf(err, id)
 }
 
+// CreateDocuments creates all or none of the documents. In a transaction, if enabled, otherwise the created
+// documents are deleted again on a failure. The conflict lists all ids already existing.
 func (this *mongoSvc[DocType]) CreateDocuments(ctx context.Context, ids []string, documents []*DocType) error {
+	if len(documents) == 0 {
+		return nil
+	}
+	return this.WithTransaction(ctx, func(ctx context.Context) error {
+		return this.insertMany(ctx, ids, documents)
+	})
+}
+
+func (this *mongoSvc[DocType]) insertMany(ctx context.Context, ids []string, documents []*DocType) error {
 	ctx, contextCancel := context.WithTimeout(ctx, this.Timeout)
 	defer contextCancel()
 	client, err := this.connect(ctx)
@@ -254,22 +206,56 @@ func (this *mongoSvc[DocType]) CreateDocuments(ctx context.Context, ids []string
 	db := client.Database(this.DbName)
 	collection := db.Collection(this.Collection)
 
-	var interfaceDocs []interface{}
+	interfaceDocs := make([]interface{}, len(documents))
 	for index, doc := range documents {
-		result := collection.FindOne(ctx, bson.D{{Key: "id", Value: ids[index]}})
-		switch result.Err() {
-		case nil: // no error means there is conflicting document
-			return ErrConflict
-		case mongo.ErrNoDocuments:
-			// do nothing, this is expected
-		default: // other errors - return them
-			return result.Err()
+		interfaceDocs[index] = doc
+	}
+
+	// the unordered insert tries all documents, so that all conflicts are found
+	_, err = collection.InsertMany(ctx, interfaceDocs, options.InsertMany().SetOrdered(false))
+	var bulkError mongo.BulkWriteException
+	if err == nil || !errors.As(err, &bulkError) || len(bulkError.WriteErrors) == 0 {
+		return err
+	}
+
+	failed := make(map[int]bool)
+	conflict := &ConflictError{}
+	for _, writeError := range bulkError.WriteErrors {
+		failed[writeError.Index] = true
+		if writeError.HasErrorCode(codeDuplicateKey) {
+			conflict.Ids = append(conflict.Ids, ids[writeError.Index])
 		}
+	}
+	var result error = conflict
+	if len(conflict.Ids) < len(bulkError.WriteErrors) {
+		result = err
+	}
 
-		interfaceDocs = append(interfaceDocs, doc)
+	// the aborted transaction drops the created documents
+	if mongo.SessionFromContext(ctx) != nil {
+		return result
 	}
+	var created []string
+	for index, id := range ids {
+		if !failed[index] {
+			created = append(created, id)
+		}
+	}
+	if len(created) > 0 {
+		filter := bson.D{{Key: "id", Value: bson.D{{Key: "$in", Value: created}}}}
+		if _, deleteErr := collection.DeleteMany(ctx, filter); deleteErr != nil {
+			return fmt.Errorf("the documents %v were created despite the failure %v, deleting them failed: %w",
+				strings.Join(created, ", "), result, deleteErr)
+		}
+	}
+	return result
+}
 
-	_, err = collection.InsertMany(ctx, interfaceDocs)
+// conflictOf maps the duplicate key error of the unique index to the conflict
+func conflictOf(err error, id string) error {
+	if mongo.IsDuplicateKeyError(err) {
+		return &ConflictError{Ids: []string{id}}
+	}
 	return err
 }
 
@@ -297,7 +283,12 @@ func (this *mongoSvc[DocType]) FindDocument(ctx context.Context, id string) (*Do
 	return document, nil
 }
 
-func (this *mongoSvc[DocType]) FindDocuments(ctx context.Context, filter interface{}) ([]*DocType, error) {
+func (this *mongoSvc[DocType]) FindDocuments(ctx context.Context, query Query) ([]*DocType, error) {
+	filter, findOptions, err := mongoFind(query)
+	if err != nil {
+		return nil, err
+	}
+
 	ctx, contextCancel := context.WithTimeout(ctx, this.Timeout)
 	defer contextCancel()
 	client, err := this.connect(ctx)
@@ -307,38 +298,48 @@ func (this *mongoSvc[DocType]) FindDocuments(ctx context.Context, filter interfa
 	db := client.Database(this.DbName)
 	collection := db.Collection(this.Collection)
 
-	filterBytes, err := json.Marshal(filter)
+	result, err := collection.Find(ctx, filter, findOptions)
 	if err != nil {
-		return nil, errors.New("could not process filters")
+		return nil, err
 	}
-	var bsonFilter bson.D
-	err = bson.UnmarshalExtJSON(filterBytes, true, &bsonFilter)
-	if err != nil {
-		return nil, errors.New("could not process filters")
+	documents := []*DocType{}
+	if err := result.All(ctx, &documents); err != nil {
+		return nil, errors.New("some of the documents could not be read")
 	}
-	// log.Printf("bson filters: %v", bsonFilter)
-	result, err := collection.Find(ctx, bsonFilter)
-	switch err {
-	case nil:
-	case mongo.ErrNoDocuments:
-		return nil, ErrNotFound
-	default: // other errors - return them
-		return nil, err
+	return documents, nil
+}
+
+func (this *mongoSvc[DocType]) StreamDocuments(ctx context.Context, query Query, fn func(document *DocType) error) error {
+	filter, findOptions, err := mongoFind(query)
+	if err != nil {
+		return err
 	}
-	var documents []*DocType
-	for result.Next(ctx) {
-		var document *DocType
-		if err := result.Decode(&document); err != nil {
-			return nil, errors.New("some of the documents could not be read")
-		}
-		documents = append(documents, document)
+
+	// only opening the cursor is limited by the timeout, reading a large result may take longer
+	findCtx, contextCancel := context.WithTimeout(ctx, this.Timeout)
+	defer contextCancel()
+	client, err := this.connect(findCtx)
+	if err != nil {
+		return err
 	}
-	if len(documents) == 0 {
-		log.Printf("0")
-		return []*DocType{}, nil
+	db := client.Database(this.DbName)
+	collection := db.Collection(this.Collection)
 
+	cursor, err := collection.Find(findCtx, filter, findOptions)
+	if err != nil {
+		return err
 	}
-	return documents, nil
+	defer cursor.Close(context.Background())
+	for cursor.Next(ctx) {
+		document := new(DocType)
+		if err := cursor.Decode(document); err != nil {
+			return errors.New("some of the documents could not be read")
+		}
+		if err := fn(document); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
 }
 
 func (this *mongoSvc[DocType]) UpdateDocument(ctx context.Context, id string, document *DocType) error {
@@ -389,7 +390,7 @@ func (this *mongoSvc[DocType]) UpdateDocument(ctx context.Context, id string, do
 	// }
 
 	_, err = collection.ReplaceOne(ctx, bson.D{{Key: "id", Value: id}}, document)
-	return err
+	return conflictOf(err, id)
 }
 
 func (this *mongoSvc[DocType]) DeleteDocument(ctx context.Context, id string) error {
@@ -437,3 +438,63 @@ func (this *mongoSvc[DocType]) BeginTransaction(ctx context.Context) (Transactio
 		Collection: this.Collection,
 	}, nil
 }
+
+// WithTransaction commits the transaction if the function succeeds and retries it on the transient errors,
+// so the function may be called more than once. The services have to share the client, which has to
+// be connected to a replica set or a sharded cluster. A function called in a transaction already joins it.
+func (this *mongoSvc[DocType]) WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if !this.Transactions || mongo.SessionFromContext(ctx) != nil {
+		return fn(ctx)
+	}
+
+	client, err := this.connect(ctx)
+	if err != nil {
+		return err
+	}
+
+	session, err := client.StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(context.Background())
+
+	// the operations find the session in the context, the hooks of a retried attempt are dropped with it
+	var hooks *afterCommitHooks
+	_, err = session.WithTransaction(ctx, func(sessionCtx mongo.SessionContext) (interface{}, error) {
+		hooks = &afterCommitHooks{}
+		return nil, fn(context.WithValue(sessionCtx, afterCommitKey{}, hooks))
+	})
+	if err != nil {
+		return err
+	}
+	hooks.run()
+	return nil
+}
+
+type afterCommitKey struct{}
+
+type afterCommitHooks struct {
+	mutex sync.Mutex
+	fns   []func()
+}
+
+func (this *afterCommitHooks) run() {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	for _, fn := range this.fns {
+		fn()
+	}
+}
+
+// AfterCommit runs the function once the transaction of the context is committed, or right away outside
+// of a transaction, see DbService.WithTransaction. The functions of a rolled back transaction are not run.
+func AfterCommit(ctx context.Context, fn func()) {
+	hooks, ok := ctx.Value(afterCommitKey{}).(*afterCommitHooks)
+	if !ok {
+		fn()
+		return
+	}
+	hooks.mutex.Lock()
+	defer hooks.mutex.Unlock()
+	hooks.fns = append(hooks.fns, fn)
+}
@@ -0,0 +1,61 @@
+package db_service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestCreateDocumentsAllOrNone(t *testing.T) {
+	svc := testMongoService[searchedPerson](t, []Index{
+		{Name: "id_unique", Keys: bson.D{{Key: "id", Value: 1}}, Unique: true},
+	})
+	ctx := context.Background()
+	for _, id := range []string{"2", "4"} {
+		if err := svc.CreateDocument(ctx, id, &searchedPerson{Id: id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name      string
+		ids       []string
+		conflicts string
+		created   string
+	}{
+		{"all conflicts reported, none created", []string{"1", "2", "3", "4", "5"}, "[2 4]", "[2 4]"},
+		{"repeated in the request", []string{"6", "6"}, "[6]", "[2 4]"},
+		{"no conflict", []string{"1", "3"}, "", "[1 2 3 4]"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var people []*searchedPerson
+			for _, id := range test.ids {
+				people = append(people, &searchedPerson{Id: id})
+			}
+			err := svc.CreateDocuments(ctx, test.ids, people)
+			var conflict *ConflictError
+			switch {
+			case test.conflicts == "" && err != nil:
+				t.Fatalf("got %v, want created", err)
+			case test.conflicts != "" && (!errors.As(err, &conflict) || fmt.Sprint(conflict.Ids) != test.conflicts):
+				t.Fatalf("got %v, want the conflicts %v", err, test.conflicts)
+			}
+
+			found, err := svc.FindDocuments(ctx, NewQuery().OrderBy("id", false))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var ids []string
+			for _, person := range found {
+				ids = append(ids, person.Id)
+			}
+			if fmt.Sprint(ids) != test.created {
+				t.Errorf("stored %v, want %v", ids, test.created)
+			}
+		})
+	}
+}
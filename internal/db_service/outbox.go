@@ -0,0 +1,156 @@
+package db_service
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// DomainEvent - event of a document change, the data is published as json
+type DomainEvent struct {
+	Type string
+	Data interface{}
+}
+
+// OutboxEvents derives the events of the document change, before is nil on the creation and after on the deletion
+type OutboxEvents[DocType interface{}] func(before *DocType, after *DocType) []DomainEvent
+
+// OutboxEvent - domain event recorded in the outbox collection together with the document change
+type OutboxEvent struct {
+	// the consumers drop the repeated events by the id, the relay publishes them at least once
+	Id         string `json:"id" bson:"id"`
+	Type       string `json:"type" bson:"type"`
+	Collection string `json:"collection" bson:"collection"`
+	DocumentId string `json:"document_id" bson:"document_id"`
+	// json of the event data
+	Payload   string `json:"payload" bson:"payload"`
+	RequestId string `json:"request_id,omitempty" bson:"request_id,omitempty"`
+	// orders the events as committed, see OutboxSequence
+	Sequence   int64     `json:"sequence" bson:"sequence"`
+	OccurredAt time.Time `json:"occurred_at" bson:"occurred_at"`
+	Published  bool      `json:"published" bson:"published"`
+	// names of the sinks which already published the event
+	PublishedTo []string  `json:"published_to,omitempty" bson:"published_to,omitempty"`
+	PublishedAt time.Time `json:"published_at,omitempty" bson:"published_at,omitempty"`
+}
+
+// OutboxIndexes - indexes of the outbox collection, the published events expire after the retention
+func OutboxIndexes(retention time.Duration) []Index {
+	return []Index{
+		{Name: "id_unique", Keys: bson.D{{Key: "id", Value: 1}}, Unique: true},
+		{Name: "published_sequence", Keys: bson.D{{Key: "published", Value: 1}, {Key: "sequence", Value: 1}}},
+		{Name: "published_at_ttl", Keys: bson.D{{Key: "published_at", Value: 1}}, ExpireAfter: retention},
+	}
+}
+
+// OutboxSequence - name of the counter numbering the outbox events. It is incremented in the transaction
+// of the change, so the events are committed in the order of their numbers and the relay does not publish
+// a later event before an earlier one is committed. Without the transactions the order is not kept.
+const OutboxSequence = "outbox_sequence"
+
+type outboxSvc[DocType interface{}] struct {
+	DbService[DocType]
+	outbox     DbService[OutboxEvent]
+	counters   Counters
+	collection string
+	events     OutboxEvents[DocType]
+}
+
+// NewOutboxService wraps the service so that every create, update and delete operation records
+// its domain events in the outbox in the same transaction, see MongoServiceConfig.Transactions.
+// The events are numbered by the OutboxSequence of the counters.
+// Wrap it by the audited service, which has to stay outermost to be found by GetAuditTrail.
+func NewOutboxService[DocType interface{}](svc DbService[DocType], outbox DbService[OutboxEvent], counters Counters, collection string, events OutboxEvents[DocType]) DbService[DocType] {
+	return &outboxSvc[DocType]{
+		DbService:  svc,
+		outbox:     outbox,
+		counters:   counters,
+		collection: collection,
+		events:     events,
+	}
+}
+
+func (this *outboxSvc[DocType]) CreateDocument(ctx context.Context, id string, document *DocType) error {
+	return this.WithTransaction(ctx, func(ctx context.Context) error {
+		if err := this.DbService.CreateDocument(ctx, id, document); err != nil {
+			return err
+		}
+		return this.record(ctx, id, nil, document)
+	})
+}
+
+func (this *outboxSvc[DocType]) CreateDocuments(ctx context.Context, ids []string, documents []*DocType) error {
+	return this.WithTransaction(ctx, func(ctx context.Context) error {
+		if err := this.DbService.CreateDocuments(ctx, ids, documents); err != nil {
+			return err
+		}
+		for index, document := range documents {
+			if err := this.record(ctx, ids[index], nil, document); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (this *outboxSvc[DocType]) UpdateDocument(ctx context.Context, id string, document *DocType) error {
+	return this.WithTransaction(ctx, func(ctx context.Context) error {
+		before, err := this.DbService.FindDocument(ctx, id)
+		if err != nil {
+			return err
+		}
+		if err := this.DbService.UpdateDocument(ctx, id, document); err != nil {
+			return err
+		}
+		return this.record(ctx, id, before, document)
+	})
+}
+
+func (this *outboxSvc[DocType]) DeleteDocument(ctx context.Context, id string) error {
+	return this.WithTransaction(ctx, func(ctx context.Context) error {
+		before, err := this.DbService.FindDocument(ctx, id)
+		if err != nil {
+			return err
+		}
+		if err := this.DbService.DeleteDocument(ctx, id); err != nil {
+			return err
+		}
+		return this.record(ctx, id, before, nil)
+	})
+}
+
+func (this *outboxSvc[DocType]) record(ctx context.Context, id string, before *DocType, after *DocType) error {
+	var ids []string
+	var events []*OutboxEvent
+	for _, domainEvent := range this.events(before, after) {
+		payload, err := json.Marshal(domainEvent.Data)
+		if err != nil {
+			return err
+		}
+		sequence, err := this.counters.Next(ctx, OutboxSequence)
+		if err != nil {
+			return err
+		}
+		event := &OutboxEvent{
+			Id:         uuid.New().String(),
+			Type:       domainEvent.Type,
+			Collection: this.collection,
+			DocumentId: id,
+			Payload:    string(payload),
+			Sequence:   sequence,
+			OccurredAt: time.Now(),
+		}
+		if requestId, ok := ctx.Value(AuditRequestIdKey).(string); ok {
+			event.RequestId = requestId
+		}
+		ids = append(ids, event.Id)
+		events = append(events, event)
+	}
+	if len(events) == 0 {
+		return nil
+	}
+	return this.outbox.CreateDocuments(ctx, ids, events)
+}
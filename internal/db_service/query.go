@@ -0,0 +1,195 @@
+package db_service
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Operator - comparison of a document field with the condition value
+type Operator string
+
+const (
+	OpEq     Operator = "eq"
+	OpNe     Operator = "ne"
+	OpIn     Operator = "in"
+	OpLt     Operator = "lt"
+	OpLte    Operator = "lte"
+	OpGt     Operator = "gt"
+	OpGte    Operator = "gte"
+	OpExists Operator = "exists"
+	// the string field starts with the value
+	OpPrefix Operator = "prefix"
+)
+
+// Condition - single condition on a document field, the conditions of a query have to hold all
+type Condition struct {
+	// dot separated path of the stored field, e.g. contents.plasma
+	Field    string
+	Operator Operator
+	// compared value, a slice for OpIn and a bool for OpExists
+	Value interface{}
+}
+
+// SortField - ordering of the results by a single field
+type SortField struct {
+	Field      string
+	Descending bool
+}
+
+// Query - backend neutral description of the documents to find. Build it with NewQuery,
+// the methods return a modified copy so that a partially built query can be shared.
+type Query struct {
+	Conditions []Condition
+	// full text search, the backend needs a text index of the searched fields
+	Text string
+	// compare the strings case and accent insensitively by the rules of the locale,
+	// binary when empty. The indexes have to use the same locale to be used by the query.
+	Locale string
+	Sort   []SortField
+	// 0 means no limit
+	Limit int64
+	Skip  int64
+	// stored fields to return, all when empty
+	Fields []string
+}
+
+// NewQuery returns a query matching all documents
+func NewQuery() Query {
+	return Query{}
+}
+
+func (this Query) where(field string, operator Operator, value interface{}) Query {
+	// full slice expression forces a copy so the queries built from the same base do not share conditions
+	this.Conditions = append(this.Conditions[:len(this.Conditions):len(this.Conditions)], Condition{
+		Field:    field,
+		Operator: operator,
+		Value:    value,
+	})
+	return this
+}
+
+func (this Query) Eq(field string, value interface{}) Query {
+	return this.where(field, OpEq, value)
+}
+
+func (this Query) Ne(field string, value interface{}) Query {
+	return this.where(field, OpNe, value)
+}
+
+// In matches the documents having the field equal to any of the values
+func (this Query) In(field string, values ...interface{}) Query {
+	return this.where(field, OpIn, values)
+}
+
+func (this Query) Lt(field string, value interface{}) Query {
+	return this.where(field, OpLt, value)
+}
+
+func (this Query) Lte(field string, value interface{}) Query {
+	return this.where(field, OpLte, value)
+}
+
+func (this Query) Gt(field string, value interface{}) Query {
+	return this.where(field, OpGt, value)
+}
+
+func (this Query) Gte(field string, value interface{}) Query {
+	return this.where(field, OpGte, value)
+}
+
+func (this Query) Exists(field string, exists bool) Query {
+	return this.where(field, OpExists, exists)
+}
+
+func (this Query) Prefix(field string, prefix string) Query {
+	return this.where(field, OpPrefix, prefix)
+}
+
+// Search matches the documents containing the words of the text in any of the text indexed fields
+func (this Query) Search(text string) Query {
+	this.Text = text
+	return this
+}
+
+// Insensitive compares the strings case and accent insensitively, see Query.Locale
+func (this Query) Insensitive(locale string) Query {
+	this.Locale = locale
+	return this
+}
+
+func (this Query) OrderBy(field string, descending bool) Query {
+	this.Sort = append(this.Sort[:len(this.Sort):len(this.Sort)], SortField{Field: field, Descending: descending})
+	return this
+}
+
+func (this Query) Take(limit int64) Query {
+	this.Limit = limit
+	return this
+}
+
+func (this Query) Offset(skip int64) Query {
+	this.Skip = skip
+	return this
+}
+
+// Select limits the returned fields, the others are left empty in the documents
+func (this Query) Select(fields ...string) Query {
+	this.Fields = append(this.Fields[:len(this.Fields):len(this.Fields)], fields...)
+	return this
+}
+
+// Validate rejects the field names and operators a backend could misinterpret
+func (this Query) Validate() error {
+	for _, condition := range this.Conditions {
+		if err := validateField(condition.Field); err != nil {
+			return err
+		}
+		switch condition.Operator {
+		case OpEq, OpNe, OpLt, OpLte, OpGt, OpGte:
+		case OpIn:
+			if _, ok := condition.Value.([]interface{}); !ok {
+				return fmt.Errorf("query: values of %v have to be a slice", condition.Field)
+			}
+		case OpPrefix:
+			if _, ok := condition.Value.(string); !ok {
+				return fmt.Errorf("query: prefix of %v has to be a string", condition.Field)
+			}
+		case OpExists:
+			if _, ok := condition.Value.(bool); !ok {
+				return fmt.Errorf("query: exists condition of %v has to be a bool", condition.Field)
+			}
+		default:
+			return fmt.Errorf("query: unknown operator %q", condition.Operator)
+		}
+	}
+	for _, sort := range this.Sort {
+		if err := validateField(sort.Field); err != nil {
+			return err
+		}
+	}
+	for _, field := range this.Fields {
+		if err := validateField(field); err != nil {
+			return err
+		}
+	}
+	if this.Limit < 0 || this.Skip < 0 {
+		return fmt.Errorf("query: limit and skip cannot be negative")
+	}
+	// the text indexes support only the binary comparison of the strings
+	if this.Text != "" && this.Locale != "" {
+		return fmt.Errorf("query: text search cannot be combined with the insensitive comparison")
+	}
+	return nil
+}
+
+func validateField(field string) error {
+	if field == "" {
+		return fmt.Errorf("query: field name is required")
+	}
+	for _, part := range strings.Split(field, ".") {
+		if part == "" || strings.HasPrefix(part, "$") {
+			return fmt.Errorf("query: invalid field name %q", field)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,498 @@
+package fhir
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Marek-FIIT/sprava-krvi-webapi/internal/db_service"
+	"github.com/Marek-FIIT/sprava-krvi-webapi/internal/problem"
+	"github.com/Marek-FIIT/sprava-krvi-webapi/internal/rbac"
+	"github.com/Marek-FIIT/sprava-krvi-webapi/internal/sprava_krvi"
+	"github.com/gin-gonic/gin"
+)
+
+const ContentType = "application/fhir+json; fhirVersion=4.0"
+
+const fhirVersion = "4.0.1"
+
+// DefaultBasePath - path of the facade unless configured otherwise
+const DefaultBasePath = "/fhir"
+
+// context key of the path the facade is served under
+const basePathKey = "fhir_base_path"
+
+const (
+	defaultCount = 50
+	maxCount     = 500
+)
+
+// read-only FHIR R4 facade of the donors as patients with the blood group observations
+// and of the units as blood products
+type facade struct {
+	// publication date of the capability statement
+	started time.Time
+}
+
+// AddRoutes registers the facade under the base path, e.g. DefaultBasePath,
+// the handlers authorize the callers as for the rest of the api
+func AddRoutes(engine *gin.Engine, basePath string, handlers ...gin.HandlerFunc) {
+	api := &facade{started: time.Now()}
+	handlers = append([]gin.HandlerFunc{func(ctx *gin.Context) {
+		ctx.Set(basePathKey, basePath)
+		ctx.Next()
+	}}, handlers...)
+	group := engine.Group(basePath, handlers...)
+	group.GET("/metadata", api.GetCapabilities)
+	group.GET("/Patient", api.SearchPatients)
+	group.GET("/Patient/:id", api.ReadPatient)
+	group.GET("/Observation", api.SearchObservations)
+	group.GET("/Observation/:id", api.ReadObservation)
+	group.GET("/BiologicallyDerivedProduct", api.SearchProducts)
+	group.GET("/BiologicallyDerivedProduct/:id", api.ReadProduct)
+}
+
+// GetCapabilities - Describes the supported resources and their search parameters
+func (this *facade) GetCapabilities(ctx *gin.Context) {
+	bloodGroup := CapabilitySearchParam{
+		Name:          "blood-group",
+		Type:          "token",
+		Documentation: "ABO group and optionally the Rh factor, e.g. A, A+ or 0-. The comma separated values have to combine all their groups with all their factors.",
+	}
+	read := []CapabilityInteraction{{Code: "read"}, {Code: "search-type"}}
+	paging := []CapabilitySearchParam{
+		{Name: "_id", Type: "token", Definition: "http://hl7.org/fhir/SearchParameter/Resource-id"},
+		{Name: "_count", Type: "number", Documentation: fmt.Sprintf("page size, %v by default and %v at most", defaultCount, maxCount)},
+		{Name: "_offset", Type: "number", Documentation: "number of the skipped results, see the next link of the bundle"},
+	}
+
+	statement := &CapabilityStatement{
+		ResourceType: "CapabilityStatement",
+		Status:       "active",
+		Date:         dateTime(this.started),
+		Kind:         "instance",
+		Software:     &CapabilitySoftware{Name: "sprava-krvi-webapi"},
+		Implementation: &CapabilityImplementation{
+			Description: "Read-only view of the blood donors and the blood units",
+			Url:         baseUrl(ctx),
+		},
+		FhirVersion: fhirVersion,
+		Format:      []string{"json"},
+		Rest: []CapabilityRest{{
+			Mode: "server",
+			Resource: []CapabilityResource{
+				{
+					Type:        "Patient",
+					Interaction: read,
+					SearchParam: append([]CapabilitySearchParam{
+						{Name: "identifier", Type: "token", Definition: "http://hl7.org/fhir/SearchParameter/Patient-identifier",
+							Documentation: fmt.Sprintf("birth number of the system %v, the default, or the id of the system %v", systemBirthNumber, systemDonorId)},
+						bloodGroup,
+					}, paging...),
+				},
+				{
+					Type:        "Observation",
+					Interaction: read,
+					SearchParam: append([]CapabilitySearchParam{
+						{Name: "patient", Type: "reference", Definition: "http://hl7.org/fhir/SearchParameter/clinical-patient"},
+						{Name: "subject", Type: "reference", Definition: "http://hl7.org/fhir/SearchParameter/Observation-subject"},
+						{Name: "code", Type: "token", Definition: "http://hl7.org/fhir/SearchParameter/clinical-code",
+							Documentation: fmt.Sprintf("LOINC %v for the ABO group or %v for the Rh factor", codeAboGroup, codeRhType)},
+					}, paging...),
+				},
+				{
+					Type:        "BiologicallyDerivedProduct",
+					Interaction: read,
+					SearchParam: append([]CapabilitySearchParam{
+						{Name: "status", Type: "token", Documentation: "available or unavailable"},
+						{Name: "patient", Type: "reference", Documentation: "the donor of the product"},
+						bloodGroup,
+					}, paging...),
+				},
+			},
+		}},
+	}
+	respond(ctx, statement)
+}
+
+// SearchPatients - Searches the donors
+func (this *facade) SearchPatients(ctx *gin.Context) {
+	query := db_service.NewQuery()
+	hidden := hiddenFields(ctx, "Donor")
+	if id := ctx.Query("_id"); id != "" {
+		query = query.Eq("id", id)
+	}
+	if identifier := ctx.Query("identifier"); identifier != "" {
+		system, value := splitToken(identifier)
+		switch system {
+		case "", systemBirthNumber:
+			// filtering by a hidden field would reveal its value
+			if hidden["birth_number"] {
+				abort(ctx, problem.Forbidden("Searching by the birth number is not permitted"))
+				return
+			}
+			query = query.Eq("birth_number", strings.ReplaceAll(value, "/", ""))
+		case systemDonorId:
+			query = query.Eq("id", value)
+		default:
+			abort(ctx, problem.BadRequest("Unknown identifier system", problem.FieldError{Field: "identifier", In: "query", Message: "has to be " + systemBirthNumber + " or " + systemDonorId}))
+			return
+		}
+	}
+	query, failure := bloodGroupFilter(ctx, query)
+	if failure != nil {
+		abort(ctx, failure)
+		return
+	}
+
+	search(ctx, "db_service_donors", query, func(donor *sprava_krvi.Donor) []interface{} {
+		return []interface{}{toPatient(donor, hidden)}
+	})
+}
+
+// ReadPatient - Provides the donor
+func (this *facade) ReadPatient(ctx *gin.Context) {
+	donor, failure := find[sprava_krvi.Donor](ctx, "db_service_donors", "Patient", ctx.Param("id"))
+	if failure != nil {
+		abort(ctx, failure)
+		return
+	}
+	respond(ctx, toPatient(donor, hiddenFields(ctx, "Donor")))
+}
+
+// SearchObservations - Searches the blood groups of the donors, the page size applies to the donors
+func (this *facade) SearchObservations(ctx *gin.Context) {
+	// donors without the blood group have no observations
+	query := db_service.NewQuery().Ne("blood_type", "")
+	for _, param := range []string{"patient", "subject"} {
+		if reference := ctx.Query(param); reference != "" {
+			query = query.Eq("id", strings.TrimPrefix(reference, "Patient/"))
+		}
+	}
+	if id := ctx.Query("_id"); id != "" {
+		query = query.Eq("id", strings.TrimSuffix(strings.TrimSuffix(id, suffixAbo), suffixRh))
+	}
+	codes := make(map[string]bool)
+	if code := ctx.Query("code"); code != "" {
+		for _, token := range strings.Split(code, ",") {
+			system, value := splitToken(token)
+			if (system != "" && system != systemLoinc) || (value != codeAboGroup && value != codeRhType) {
+				abort(ctx, problem.BadRequest("Unsupported code", problem.FieldError{Field: "code", In: "query", Message: fmt.Sprintf("has to be %v or %v of %v", codeAboGroup, codeRhType, systemLoinc)}))
+				return
+			}
+			codes[value] = true
+		}
+	}
+
+	id := ctx.Query("_id")
+	search(ctx, "db_service_donors", query, func(donor *sprava_krvi.Donor) []interface{} {
+		var resources []interface{}
+		for _, observation := range toObservations(donor) {
+			if (len(codes) == 0 || codes[observation.Code.Coding[0].Code]) && (id == "" || id == observation.Id) {
+				resources = append(resources, observation)
+			}
+		}
+		return resources
+	})
+}
+
+// ReadObservation - Provides the ABO group or the Rh factor of a donor
+func (this *facade) ReadObservation(ctx *gin.Context) {
+	id := ctx.Param("id")
+	donorId := strings.TrimSuffix(strings.TrimSuffix(id, suffixAbo), suffixRh)
+	if donorId == id {
+		abort(ctx, problem.NotFound("Observation not found"))
+		return
+	}
+
+	donor, failure := find[sprava_krvi.Donor](ctx, "db_service_donors", "Observation", donorId)
+	if failure != nil {
+		abort(ctx, failure)
+		return
+	}
+	for _, observation := range toObservations(donor) {
+		if observation.Id == id {
+			respond(ctx, observation)
+			return
+		}
+	}
+	abort(ctx, problem.NotFound("Observation not found"))
+}
+
+// SearchProducts - Searches the blood units
+func (this *facade) SearchProducts(ctx *gin.Context) {
+	query := db_service.NewQuery()
+	if id := ctx.Query("_id"); id != "" {
+		query = query.Eq("id", id)
+	}
+	switch status := ctx.Query("status"); status {
+	case "":
+	case "available":
+		query = query.Eq("status", "available")
+	case "unavailable":
+		query = query.Ne("status", "available")
+	default:
+		abort(ctx, problem.BadRequest("Unsupported status", problem.FieldError{Field: "status", In: "query", Message: "has to be available or unavailable"}))
+		return
+	}
+	if reference := ctx.Query("patient"); reference != "" {
+		query = query.Eq("donor_id", strings.TrimPrefix(reference, "Patient/"))
+	}
+	query, failure := bloodGroupFilter(ctx, query)
+	if failure != nil {
+		abort(ctx, failure)
+		return
+	}
+
+	search(ctx, "db_service_units", query, func(unit *sprava_krvi.Unit) []interface{} {
+		return []interface{}{toProduct(unit)}
+	})
+}
+
+// ReadProduct - Provides the blood unit
+func (this *facade) ReadProduct(ctx *gin.Context) {
+	unit, failure := find[sprava_krvi.Unit](ctx, "db_service_units", "BiologicallyDerivedProduct", ctx.Param("id"))
+	if failure != nil {
+		abort(ctx, failure)
+		return
+	}
+	respond(ctx, toProduct(unit))
+}
+
+func find[DocType interface{}](ctx *gin.Context, dbServiceKey string, resourceType string, id string) (*DocType, *problem.Problem) {
+	db, err := db_service.GetDbService[DocType](ctx, dbServiceKey)
+	if err != nil {
+		return nil, problem.Internal("Failed to access db_service", err)
+	}
+	document, err := db.FindDocument(ctx, id)
+	switch {
+	case err == nil:
+		return document, nil
+	case errors.Is(err, db_service.ErrNotFound):
+		return nil, problem.NotFound(resourceType + " not found")
+	default:
+		return nil, problem.FromError(err, "Failed to load the "+resourceType+" from database")
+	}
+}
+
+// search responds with a page of the documents found by the query, each mapped to its resources
+func search[DocType interface{}](ctx *gin.Context, dbServiceKey string, query db_service.Query, resources func(document *DocType) []interface{}) {
+	count, offset, failure := paging(ctx)
+	if failure != nil {
+		abort(ctx, failure)
+		return
+	}
+
+	db, err := db_service.GetDbService[DocType](ctx, dbServiceKey)
+	if err != nil {
+		abort(ctx, problem.Internal("Failed to access db_service", err))
+		return
+	}
+
+	// the documents are ordered by the id so that the pages do not overlap
+	var entries []BundleEntry
+	var found int64
+	err = db.StreamDocuments(ctx, query.OrderBy("id", false).Take(count).Offset(offset), func(document *DocType) error {
+		found++
+		for _, resource := range resources(document) {
+			entries = append(entries, BundleEntry{
+				FullUrl:  resourceUrl(ctx, resource),
+				Resource: resource,
+				Search:   &BundleSearch{Mode: "match"},
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		abort(ctx, problem.FromError(err, "Failed to search the resources"))
+		return
+	}
+
+	bundle := &Bundle{
+		ResourceType: "Bundle",
+		Type:         "searchset",
+		Link:         []BundleLink{{Relation: "self", Url: baseUrl(ctx) + strings.TrimPrefix(ctx.Request.URL.RequestURI(), ctx.GetString(basePathKey))}},
+		Entry:        entries,
+	}
+	// the total is known only when all results fit in the first page
+	if offset == 0 && found < count {
+		total := len(entries)
+		bundle.Total = &total
+	}
+	if found == count {
+		next := ctx.Request.URL.Query()
+		next.Set("_offset", strconv.FormatInt(offset+count, 10))
+		bundle.Link = append(bundle.Link, BundleLink{Relation: "next", Url: baseUrl(ctx) + strings.TrimPrefix(ctx.Request.URL.Path, ctx.GetString(basePathKey)) + "?" + next.Encode()})
+	}
+	respond(ctx, bundle)
+}
+
+// bloodGroupFilter adds the blood-group parameter to the query. The groups are matched by the types and the factors
+// separately, so the values have to list every combination of them, e.g. A+,A-,0+,0- for a compatible red cells.
+func bloodGroupFilter(ctx *gin.Context, query db_service.Query) (db_service.Query, *problem.Problem) {
+	param := ctx.Query("blood-group")
+	if param == "" {
+		return query, nil
+	}
+	invalid := func(message string) *problem.Problem {
+		return problem.BadRequest("Invalid blood group", problem.FieldError{Field: "blood-group", In: "query", Message: message})
+	}
+
+	groups := make(map[string]bool)
+	types := make(map[string]bool)
+	factors := make(map[string]bool)
+	// the unescaped + of the query is decoded as a space
+	for _, value := range strings.Split(strings.ReplaceAll(param, " ", "+"), ",") {
+		_, value = splitToken(strings.ToUpper(strings.TrimSpace(value)))
+		bloodType := strings.TrimRight(value, "+-")
+		if bloodType == "O" {
+			bloodType = "0"
+		}
+		if _, found := aboCodings[bloodType]; !found {
+			return query, invalid(fmt.Sprintf("unknown blood group %v, e.g. A, A+ or 0-", value))
+		}
+		factor := strings.TrimPrefix(value, strings.TrimRight(value, "+-"))
+		switch factor {
+		case "+", "-":
+			groups[bloodType+factor] = true
+			factors[factor] = true
+		case "":
+			groups[bloodType+"+"], groups[bloodType+"-"] = true, true
+			factors["+"], factors["-"] = true, true
+		default:
+			return query, invalid(fmt.Sprintf("unknown blood group %v, e.g. A, A+ or 0-", value))
+		}
+		types[bloodType] = true
+	}
+	if len(groups) != len(types)*len(factors) {
+		return query, invalid("has to list every combination of the blood types and the Rh factors")
+	}
+
+	var typeValues, factorValues []interface{}
+	for bloodType := range types {
+		typeValues = append(typeValues, bloodType)
+	}
+	for factor := range factors {
+		factorValues = append(factorValues, factor)
+	}
+	return query.In("blood_type", typeValues...).In("blood_rh", factorValues...), nil
+}
+
+func paging(ctx *gin.Context) (int64, int64, *problem.Problem) {
+	count, offset := int64(defaultCount), int64(0)
+	var pagingErrs []problem.FieldError
+	if value := ctx.Query("_count"); value != "" {
+		parsed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || parsed < 1 {
+			pagingErrs = append(pagingErrs, problem.FieldError{Field: "_count", In: "query", Message: "has to be a positive integer"})
+		}
+		count = min(parsed, maxCount)
+	}
+	if value := ctx.Query("_offset"); value != "" {
+		parsed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || parsed < 0 {
+			pagingErrs = append(pagingErrs, problem.FieldError{Field: "_offset", In: "query", Message: "has to be a non negative integer"})
+		}
+		offset = parsed
+	}
+	if len(pagingErrs) > 0 {
+		return 0, 0, problem.BadRequest("Invalid paging", pagingErrs...)
+	}
+	return count, offset, nil
+}
+
+// splitToken splits the system|code token, the system is empty if not given
+func splitToken(token string) (string, string) {
+	if system, code, found := strings.Cut(token, "|"); found {
+		return system, code
+	}
+	return "", token
+}
+
+func hiddenFields(ctx *gin.Context, schema string) map[string]bool {
+	hidden := make(map[string]bool)
+	for _, field := range rbac.HiddenFields(ctx, schema) {
+		hidden[field] = true
+	}
+	return hidden
+}
+
+// baseUrl is the absolute url of the facade as seen by the client, behind a proxy as well
+func baseUrl(ctx *gin.Context) string {
+	scheme := "http"
+	if ctx.Request.TLS != nil {
+		scheme = "https"
+	}
+	if forwarded := ctx.GetHeader("X-Forwarded-Proto"); forwarded != "" {
+		scheme = forwarded
+	}
+	host := ctx.Request.Host
+	if forwarded := ctx.GetHeader("X-Forwarded-Host"); forwarded != "" {
+		host = forwarded
+	}
+	return (&url.URL{Scheme: scheme, Host: host, Path: ctx.GetString(basePathKey)}).String()
+}
+
+func resourceUrl(ctx *gin.Context, resource interface{}) string {
+	switch typed := resource.(type) {
+	case *Patient:
+		return baseUrl(ctx) + "/Patient/" + typed.Id
+	case *Observation:
+		return baseUrl(ctx) + "/Observation/" + typed.Id
+	case *BiologicallyDerivedProduct:
+		return baseUrl(ctx) + "/BiologicallyDerivedProduct/" + typed.Id
+	default:
+		return ""
+	}
+}
+
+func respond(ctx *gin.Context, resource interface{}) {
+	ctx.Header("Content-Type", ContentType)
+	ctx.JSON(http.StatusOK, resource)
+}
+
+var issueCodes = map[int]string{
+	http.StatusBadRequest:     "invalid",
+	http.StatusUnauthorized:   "login",
+	http.StatusForbidden:      "forbidden",
+	http.StatusNotFound:       "not-found",
+	http.StatusGatewayTimeout: "timeout",
+}
+
+// abort responds with the problem as the FHIR operation outcome
+func abort(ctx *gin.Context, failure *problem.Problem) {
+	if failure.Status >= http.StatusInternalServerError {
+		slog.ErrorContext(ctx, "Request failed",
+			"method", ctx.Request.Method,
+			"route", ctx.FullPath(),
+			"status", failure.Status,
+			"type", failure.Type,
+			"error", error(failure),
+		)
+	}
+
+	code, found := issueCodes[failure.Status]
+	if !found {
+		code = "exception"
+	}
+	outcome := &OperationOutcome{
+		ResourceType: "OperationOutcome",
+		Issue:        []OperationOutcomeIssue{{Severity: "error", Code: code, Diagnostics: failure.Detail}},
+	}
+	for _, field := range failure.Errors {
+		outcome.Issue = append(outcome.Issue, OperationOutcomeIssue{
+			Severity:    "error",
+			Code:        code,
+			Diagnostics: field.Message,
+			Expression:  []string{field.Field},
+		})
+	}
+
+	ctx.Header("Content-Type", ContentType)
+	ctx.AbortWithStatusJSON(failure.Status, outcome)
+}
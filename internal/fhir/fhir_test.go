@@ -0,0 +1,115 @@
+package fhir
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Marek-FIIT/sprava-krvi-webapi/internal/db_service"
+	"github.com/Marek-FIIT/sprava-krvi-webapi/internal/sprava_krvi"
+	"github.com/gin-gonic/gin"
+)
+
+// memoryDonors - donors in memory in the order of their ids, the query is not evaluated
+type memoryDonors struct {
+	db_service.DbService[sprava_krvi.Donor]
+	donors []sprava_krvi.Donor
+}
+
+func (this *memoryDonors) StreamDocuments(ctx context.Context, query db_service.Query, fn func(document *sprava_krvi.Donor) error) error {
+	for index := range this.donors {
+		if int64(index) >= query.Limit {
+			break
+		}
+		if err := fn(&this.donors[index]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestBasePath(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	donors := &memoryDonors{donors: []sprava_krvi.Donor{{Id: "1", BloodType: "A", BloodRh: "+"}, {Id: "2", BloodType: "0", BloodRh: "-"}}}
+	tests := []struct {
+		name     string
+		basePath string
+		path     string
+		header   map[string]string
+		want     map[string]string
+	}{
+		{
+			name:     "default",
+			basePath: DefaultBasePath,
+			path:     "/fhir/metadata",
+			want:     map[string]string{"implementation": "http://example.test/fhir"},
+		},
+		{
+			name:     "configured",
+			basePath: "/r4",
+			path:     "/r4/metadata",
+			want:     map[string]string{"implementation": "http://example.test/r4"},
+		},
+		{
+			name:     "behind a proxy",
+			basePath: "/r4",
+			path:     "/r4/metadata",
+			header:   map[string]string{"X-Forwarded-Proto": "https", "X-Forwarded-Host": "fhir.example"},
+			want:     map[string]string{"implementation": "https://fhir.example/r4"},
+		},
+		{
+			name:     "search links",
+			basePath: "/r4",
+			path:     "/r4/Patient?_count=1",
+			want: map[string]string{
+				"self":  "http://example.test/r4/Patient?_count=1",
+				"next":  "http://example.test/r4/Patient?_count=1&_offset=1",
+				"entry": "http://example.test/r4/Patient/1",
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			engine := gin.New()
+			AddRoutes(engine, test.basePath, func(ctx *gin.Context) {
+				ctx.Set("db_service_donors", db_service.DbService[sprava_krvi.Donor](donors))
+			})
+
+			request := httptest.NewRequest(http.MethodGet, "http://example.test"+test.path, nil)
+			for key, value := range test.header {
+				request.Header.Set(key, value)
+			}
+			recorder := httptest.NewRecorder()
+			engine.ServeHTTP(recorder, request)
+			if recorder.Code != http.StatusOK {
+				t.Fatalf("responded %v %v", recorder.Code, recorder.Body.String())
+			}
+
+			var body struct {
+				Implementation struct{ Url string }
+				Link           []struct{ Relation, Url string }
+				Entry          []struct{ FullUrl string }
+			}
+			if err := json.Unmarshal(recorder.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			got := map[string]string{}
+			if body.Implementation.Url != "" {
+				got["implementation"] = body.Implementation.Url
+			}
+			for _, link := range body.Link {
+				got[link.Relation] = link.Url
+			}
+			if len(body.Entry) > 0 {
+				got["entry"] = body.Entry[0].FullUrl
+			}
+			for key, value := range test.want {
+				if got[key] != value {
+					t.Errorf("%v is %q, want %q", key, got[key], value)
+				}
+			}
+		})
+	}
+}
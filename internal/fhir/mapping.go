@@ -0,0 +1,225 @@
+package fhir
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Marek-FIIT/sprava-krvi-webapi/internal/sprava_krvi"
+)
+
+// identifier systems of the values issued by this service
+const (
+	systemBirthNumber = "urn:sprava-krvi:birth-number"
+	systemDonorId     = "urn:sprava-krvi:donor-id"
+	systemUnitId      = "urn:sprava-krvi:unit-id"
+	systemDonationId  = "urn:sprava-krvi:donation-id"
+)
+
+const (
+	systemLoinc  = "http://loinc.org"
+	systemSnomed = "http://snomed.info/sct"
+)
+
+const (
+	codeAboGroup = "883-9"
+	codeRhType   = "10331-7"
+)
+
+// suffixes of the ids of the blood group observations, the observations are derived from the donor
+const (
+	suffixAbo = "-abo"
+	suffixRh  = "-rh"
+)
+
+var aboCodings = map[string]Coding{
+	"A":  {System: systemSnomed, Code: "112144000", Display: "Blood group A"},
+	"B":  {System: systemSnomed, Code: "112149005", Display: "Blood group B"},
+	"AB": {System: systemSnomed, Code: "165743006", Display: "Blood group AB"},
+	"0":  {System: systemSnomed, Code: "58460004", Display: "Blood group O"},
+}
+
+var rhCodings = map[string]Coding{
+	"+": {System: systemSnomed, Code: "165747007", Display: "RhD positive"},
+	"-": {System: systemSnomed, Code: "165746003", Display: "RhD negative"},
+}
+
+var unitContents = []struct {
+	name    string
+	present func(contents *sprava_krvi.UnitContents) bool
+}{
+	{"erythrocytes", func(contents *sprava_krvi.UnitContents) bool { return contents.Erythrocytes }},
+	{"leukocytes", func(contents *sprava_krvi.UnitContents) bool { return contents.Leukocytes }},
+	{"platelets", func(contents *sprava_krvi.UnitContents) bool { return contents.Platelets }},
+	{"plasma", func(contents *sprava_krvi.UnitContents) bool { return contents.Plasma }},
+}
+
+// toPatient maps the donor to the patient, leaving out the fields hidden from the caller
+func toPatient(donor *sprava_krvi.Donor, hidden map[string]bool) *Patient {
+	patient := &Patient{
+		ResourceType: "Patient",
+		Id:           donor.Id,
+		Meta:         meta(donor.UpdatedAt),
+		Identifier:   []Identifier{{Use: "usual", System: systemDonorId, Value: donor.Id}},
+		Active:       true,
+		Name:         []HumanName{{Use: "official", Family: donor.LastName}},
+	}
+	if donor.FirstName != "" {
+		patient.Name[0].Given = []string{donor.FirstName}
+	}
+	if donor.BirthNumber != "" && !hidden["birth_number"] {
+		patient.Identifier = append(patient.Identifier, Identifier{Use: "official", System: systemBirthNumber, Value: donor.BirthNumber})
+		patient.Gender, patient.BirthDate = fromBirthNumber(donor.BirthNumber)
+	}
+	if donor.Email != "" && !hidden["email"] {
+		patient.Telecom = append(patient.Telecom, ContactPoint{System: "email", Value: donor.Email})
+	}
+	if donor.PhoneNumber != "" && !hidden["phone_number"] {
+		patient.Telecom = append(patient.Telecom, ContactPoint{System: "phone", Value: donor.PhoneNumber})
+	}
+	if donor.PostalCode != "" {
+		patient.Address = []Address{{PostalCode: donor.PostalCode}}
+	}
+	return patient
+}
+
+// toObservations maps the blood group of the donor to the ABO and the Rh observations, none if it was not determined
+func toObservations(donor *sprava_krvi.Donor) []*Observation {
+	var observations []*Observation
+	if coding, found := aboCodings[donor.BloodType]; found {
+		observations = append(observations, bloodGroupObservation(donor, suffixAbo, codeAboGroup, "ABO group [Type] in Blood", coding))
+	}
+	if coding, found := rhCodings[donor.BloodRh]; found {
+		observations = append(observations, bloodGroupObservation(donor, suffixRh, codeRhType, "Rh [Type] in Blood", coding))
+	}
+	return observations
+}
+
+func bloodGroupObservation(donor *sprava_krvi.Donor, suffix string, code string, display string, value Coding) *Observation {
+	return &Observation{
+		ResourceType: "Observation",
+		Id:           donor.Id + suffix,
+		Meta:         meta(donor.UpdatedAt),
+		Status:       "final",
+		Category: []CodeableConcept{{Coding: []Coding{{
+			System:  "http://terminology.hl7.org/CodeSystem/observation-category",
+			Code:    "laboratory",
+			Display: "Laboratory",
+		}}}},
+		Code:                 CodeableConcept{Coding: []Coding{{System: systemLoinc, Code: code, Display: display}}},
+		Subject:              &Reference{Reference: "Patient/" + donor.Id},
+		ValueCodeableConcept: &CodeableConcept{Coding: []Coding{value}, Text: value.Display},
+	}
+}
+
+// toProduct maps the unit to the blood product, the donor is the source of its collection
+func toProduct(unit *sprava_krvi.Unit) *BiologicallyDerivedProduct {
+	product := &BiologicallyDerivedProduct{
+		ResourceType:    "BiologicallyDerivedProduct",
+		Id:              unit.Id,
+		Meta:            meta(unit.UpdatedAt),
+		Identifier:      []Identifier{{Use: "official", System: systemUnitId, Value: unit.Id}},
+		ProductCategory: "fluid",
+		Status:          "unavailable",
+		Quantity:        1,
+	}
+	if unit.DonationId != "" {
+		product.Identifier = append(product.Identifier, Identifier{Use: "secondary", System: systemDonationId, Value: unit.DonationId})
+	}
+
+	// plasma alone is a fluid, anything with the blood cells are cells
+	var description []string
+	if unit.BloodType != "" {
+		description = append(description, strings.TrimSpace("Blood group "+unit.BloodType+" Rh"+unit.BloodRh))
+	}
+	for _, content := range unitContents {
+		if content.present(&unit.Contents) {
+			description = append(description, content.name)
+			if content.name != "plasma" {
+				product.ProductCategory = "cells"
+			}
+		}
+	}
+	description = append(description, unit.Contents.Additional...)
+	if len(description) > 0 {
+		product.ProductCode = &CodeableConcept{Text: strings.Join(description, ", ")}
+	}
+
+	// the other states are not ready for the transfusion
+	if unit.Status == "available" {
+		product.Status = "available"
+	}
+	if unit.DonorId != "" || !unit.CreatedAt.IsZero() {
+		product.Collection = &ProductCollection{CollectedDateTime: dateTime(unit.CreatedAt)}
+		if unit.DonorId != "" {
+			product.Collection.Source = &Reference{Reference: "Patient/" + unit.DonorId}
+		}
+	}
+
+	var storage ProductStorage
+	var conditions []string
+	if unit.Location != "" {
+		conditions = append(conditions, unit.Location)
+	}
+	if unit.Frozen {
+		conditions = append(conditions, "frozen")
+	}
+	storage.Description = strings.Join(conditions, ", ")
+	if !unit.Expiration.IsZero() {
+		storage.Duration = &Period{End: dateTime(unit.Expiration)}
+	}
+	if storage.Description != "" || storage.Duration != nil {
+		product.Storage = []ProductStorage{storage}
+	}
+	return product
+}
+
+// fromBirthNumber derives the gender and the birth date, the month of the women is increased by 50
+// and by 20 more when the numbers of the day ran out since 2004. Empty when the number is not valid.
+func fromBirthNumber(birthNumber string) (string, string) {
+	if len(birthNumber) != 9 && len(birthNumber) != 10 {
+		return "", ""
+	}
+	year, errYear := strconv.Atoi(birthNumber[0:2])
+	month, errMonth := strconv.Atoi(birthNumber[2:4])
+	day, errDay := strconv.Atoi(birthNumber[4:6])
+	if errYear != nil || errMonth != nil || errDay != nil {
+		return "", ""
+	}
+
+	gender := "male"
+	if month > 50 {
+		gender = "female"
+		month -= 50
+	}
+	if month > 20 {
+		month -= 20
+	}
+	// the 9 digit numbers were issued until 1954
+	switch {
+	case len(birthNumber) == 9 || year >= 54:
+		year += 1900
+	default:
+		year += 2000
+	}
+
+	birthDate := time.Date(year, time.Month(month), day, 0, 0, 0, 0, time.UTC)
+	if birthDate.Month() != time.Month(month) || birthDate.Day() != day {
+		return "", ""
+	}
+	return gender, birthDate.Format(time.DateOnly)
+}
+
+func meta(updatedAt time.Time) *Meta {
+	if updatedAt.IsZero() {
+		return nil
+	}
+	return &Meta{LastUpdated: dateTime(updatedAt)}
+}
+
+func dateTime(value time.Time) string {
+	if value.IsZero() {
+		return ""
+	}
+	return value.UTC().Format(time.RFC3339)
+}
@@ -0,0 +1,175 @@
+package fhir
+
+// The subset of the FHIR R4 data types used by the facade, the json names follow the specification
+// (https://hl7.org/fhir/R4/), so that the responses validate against fhir.schema.json.
+
+type Meta struct {
+	LastUpdated string `json:"lastUpdated,omitempty"`
+}
+
+type Coding struct {
+	System  string `json:"system,omitempty"`
+	Code    string `json:"code,omitempty"`
+	Display string `json:"display,omitempty"`
+}
+
+type CodeableConcept struct {
+	Coding []Coding `json:"coding,omitempty"`
+	Text   string   `json:"text,omitempty"`
+}
+
+type Identifier struct {
+	Use    string `json:"use,omitempty"`
+	System string `json:"system,omitempty"`
+	Value  string `json:"value,omitempty"`
+}
+
+type Reference struct {
+	Reference string `json:"reference,omitempty"`
+	Display   string `json:"display,omitempty"`
+}
+
+type Period struct {
+	Start string `json:"start,omitempty"`
+	End   string `json:"end,omitempty"`
+}
+
+type HumanName struct {
+	Use    string   `json:"use,omitempty"`
+	Family string   `json:"family,omitempty"`
+	Given  []string `json:"given,omitempty"`
+}
+
+type ContactPoint struct {
+	System string `json:"system,omitempty"`
+	Value  string `json:"value,omitempty"`
+}
+
+type Address struct {
+	PostalCode string `json:"postalCode,omitempty"`
+}
+
+type Patient struct {
+	ResourceType string         `json:"resourceType"`
+	Id           string         `json:"id,omitempty"`
+	Meta         *Meta          `json:"meta,omitempty"`
+	Identifier   []Identifier   `json:"identifier,omitempty"`
+	Active       bool           `json:"active"`
+	Name         []HumanName    `json:"name,omitempty"`
+	Telecom      []ContactPoint `json:"telecom,omitempty"`
+	Gender       string         `json:"gender,omitempty"`
+	BirthDate    string         `json:"birthDate,omitempty"`
+	Address      []Address      `json:"address,omitempty"`
+}
+
+type Observation struct {
+	ResourceType         string            `json:"resourceType"`
+	Id                   string            `json:"id,omitempty"`
+	Meta                 *Meta             `json:"meta,omitempty"`
+	Status               string            `json:"status"`
+	Category             []CodeableConcept `json:"category,omitempty"`
+	Code                 CodeableConcept   `json:"code"`
+	Subject              *Reference        `json:"subject,omitempty"`
+	ValueCodeableConcept *CodeableConcept  `json:"valueCodeableConcept,omitempty"`
+}
+
+type ProductCollection struct {
+	Source            *Reference `json:"source,omitempty"`
+	CollectedDateTime string     `json:"collectedDateTime,omitempty"`
+}
+
+type ProductStorage struct {
+	Description string  `json:"description,omitempty"`
+	Duration    *Period `json:"duration,omitempty"`
+}
+
+type BiologicallyDerivedProduct struct {
+	ResourceType    string             `json:"resourceType"`
+	Id              string             `json:"id,omitempty"`
+	Meta            *Meta              `json:"meta,omitempty"`
+	Identifier      []Identifier       `json:"identifier,omitempty"`
+	ProductCategory string             `json:"productCategory,omitempty"`
+	ProductCode     *CodeableConcept   `json:"productCode,omitempty"`
+	Status          string             `json:"status,omitempty"`
+	Quantity        int                `json:"quantity,omitempty"`
+	Collection      *ProductCollection `json:"collection,omitempty"`
+	Storage         []ProductStorage   `json:"storage,omitempty"`
+}
+
+type BundleLink struct {
+	Relation string `json:"relation"`
+	Url      string `json:"url"`
+}
+
+type BundleSearch struct {
+	Mode string `json:"mode,omitempty"`
+}
+
+type BundleEntry struct {
+	FullUrl  string        `json:"fullUrl,omitempty"`
+	Resource interface{}   `json:"resource,omitempty"`
+	Search   *BundleSearch `json:"search,omitempty"`
+}
+
+type Bundle struct {
+	ResourceType string        `json:"resourceType"`
+	Type         string        `json:"type"`
+	Total        *int          `json:"total,omitempty"`
+	Link         []BundleLink  `json:"link,omitempty"`
+	Entry        []BundleEntry `json:"entry,omitempty"`
+}
+
+type OperationOutcomeIssue struct {
+	Severity    string   `json:"severity"`
+	Code        string   `json:"code"`
+	Diagnostics string   `json:"diagnostics,omitempty"`
+	Expression  []string `json:"expression,omitempty"`
+}
+
+type OperationOutcome struct {
+	ResourceType string                  `json:"resourceType"`
+	Issue        []OperationOutcomeIssue `json:"issue"`
+}
+
+type CapabilitySearchParam struct {
+	Name          string `json:"name"`
+	Definition    string `json:"definition,omitempty"`
+	Type          string `json:"type"`
+	Documentation string `json:"documentation,omitempty"`
+}
+
+type CapabilityInteraction struct {
+	Code string `json:"code"`
+}
+
+type CapabilityResource struct {
+	Type        string                  `json:"type"`
+	Interaction []CapabilityInteraction `json:"interaction,omitempty"`
+	SearchParam []CapabilitySearchParam `json:"searchParam,omitempty"`
+}
+
+type CapabilityRest struct {
+	Mode     string               `json:"mode"`
+	Resource []CapabilityResource `json:"resource,omitempty"`
+}
+
+type CapabilitySoftware struct {
+	Name string `json:"name"`
+}
+
+type CapabilityImplementation struct {
+	Description string `json:"description"`
+	Url         string `json:"url,omitempty"`
+}
+
+type CapabilityStatement struct {
+	ResourceType   string                    `json:"resourceType"`
+	Status         string                    `json:"status"`
+	Date           string                    `json:"date"`
+	Kind           string                    `json:"kind"`
+	Software       *CapabilitySoftware       `json:"software,omitempty"`
+	Implementation *CapabilityImplementation `json:"implementation,omitempty"`
+	FhirVersion    string                    `json:"fhirVersion"`
+	Format         []string                  `json:"format"`
+	Rest           []CapabilityRest          `json:"rest,omitempty"`
+}
@@ -0,0 +1,110 @@
+package health
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CheckFunc reports an error if the dependency is not usable
+type CheckFunc func(ctx context.Context) error
+
+type check struct {
+	name  string
+	check CheckFunc
+}
+
+type Config struct {
+	// timeout of a single dependency check
+	Timeout time.Duration
+}
+
+// CheckStatus - status of a single dependency
+type CheckStatus struct {
+	Status    string `json:"status"`
+	LatencyMs int64  `json:"latency_ms"`
+	Error     string `json:"error,omitempty"`
+}
+
+// Status - readiness of the service and its dependencies
+type Status struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckStatus `json:"checks,omitempty"`
+}
+
+type Checker struct {
+	Config
+	checks   []check
+	draining atomic.Bool
+}
+
+func NewChecker(config Config) *Checker {
+	checker := &Checker{Config: config}
+
+	if checker.Timeout == 0 {
+		checker.Timeout = 2 * time.Second
+	}
+
+	return checker
+}
+
+// AddCheck registers a dependency required for the readiness
+func (this *Checker) AddCheck(name string, checkFunc CheckFunc) {
+	this.checks = append(this.checks, check{name: name, check: checkFunc})
+}
+
+// StartDraining makes the service not ready, so that no new traffic is routed to it during the shutdown
+func (this *Checker) StartDraining() {
+	this.draining.Store(true)
+}
+
+// HandleLiveness reports that the process is alive, dependencies are not checked
+func (this *Checker) HandleLiveness(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, Status{Status: "alive"})
+}
+
+// HandleReadiness checks all registered dependencies in parallel
+func (this *Checker) HandleReadiness(ctx *gin.Context) {
+	status := Status{Status: "ready", Checks: make(map[string]CheckStatus)}
+
+	var lock sync.Mutex
+	var wait sync.WaitGroup
+	for _, item := range this.checks {
+		wait.Add(1)
+		go func(item check) {
+			defer wait.Done()
+			checkCtx, contextCancel := context.WithTimeout(ctx.Request.Context(), this.Timeout)
+			defer contextCancel()
+
+			start := time.Now()
+			err := item.check(checkCtx)
+			checkStatus := CheckStatus{Status: "up", LatencyMs: time.Since(start).Milliseconds()}
+			if err != nil {
+				checkStatus.Status = "down"
+				checkStatus.Error = err.Error()
+			}
+
+			lock.Lock()
+			defer lock.Unlock()
+			status.Checks[item.name] = checkStatus
+			if err != nil {
+				status.Status = "not ready"
+			}
+		}(item)
+	}
+	wait.Wait()
+
+	if this.draining.Load() {
+		status.Status = "draining"
+	}
+
+	code := http.StatusOK
+	if status.Status != "ready" {
+		code = http.StatusServiceUnavailable
+	}
+	ctx.JSON(code, status)
+}
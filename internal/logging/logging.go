@@ -0,0 +1,186 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/Marek-FIIT/sprava-krvi-webapi/internal/db_service"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const RequestIdHeader = "X-Request-ID"
+
+const (
+	FormatJson = "json"
+	FormatText = "text"
+)
+
+type Config struct {
+	// debug, info, warn or error
+	Level string
+	// json or text
+	Format string
+	// defaults to the standard output
+	Output io.Writer
+}
+
+// attributes never written to the log, compared case insensitively
+var sensitiveKeys = map[string]bool{
+	"password":      true,
+	"authorization": true,
+	"token":         true,
+	"first_name":    true,
+	"last_name":     true,
+	"birth_number":  true,
+	"email":         true,
+	"phone_number":  true,
+	"address":       true,
+	"diseases":      true,
+	"medications":   true,
+	"substances":    true,
+}
+
+var scrubbers = []struct {
+	pattern     *regexp.Regexp
+	replacement string
+}{
+	// credentials in connection strings
+	{regexp.MustCompile(`(mongodb(?:\+srv)?://)[^@/\s]+@`), "${1}[REDACTED]@"},
+	// values of the duplicate keys reported by mongo
+	{regexp.MustCompile(`dup key: \{[^}]*\}`), "dup key: { [REDACTED] }"},
+}
+
+// Setup installs the structured logger as the default for both slog and log packages
+func Setup(config Config) error {
+	if config.Level == "" {
+		config.Level = "info"
+	}
+	if config.Format == "" {
+		config.Format = FormatJson
+	}
+	if config.Output == nil {
+		config.Output = os.Stdout
+	}
+
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(config.Level)); err != nil {
+		return fmt.Errorf("invalid log level %q: %w", config.Level, err)
+	}
+
+	options := &slog.HandlerOptions{
+		Level:       level,
+		ReplaceAttr: redact,
+	}
+	var handler slog.Handler
+	switch strings.ToLower(config.Format) {
+	case FormatJson:
+		handler = slog.NewJSONHandler(config.Output, options)
+	case FormatText:
+		handler = slog.NewTextHandler(config.Output, options)
+	default:
+		return fmt.Errorf("invalid log format: %v", config.Format)
+	}
+
+	slog.SetDefault(slog.New(&contextHandler{handler}))
+	return nil
+}
+
+// Scrub removes the credentials and personal data known to appear in error messages
+func Scrub(message string) string {
+	for _, scrubber := range scrubbers {
+		message = scrubber.pattern.ReplaceAllString(message, scrubber.replacement)
+	}
+	return message
+}
+
+func redact(groups []string, attr slog.Attr) slog.Attr {
+	if sensitiveKeys[strings.ToLower(attr.Key)] {
+		return slog.String(attr.Key, "[REDACTED]")
+	}
+	switch value := attr.Value.Any().(type) {
+	case error:
+		return slog.String(attr.Key, Scrub(value.Error()))
+	case string:
+		return slog.String(attr.Key, Scrub(value))
+	}
+	return attr
+}
+
+// contextHandler adds the request id and the trace of the request to every record
+type contextHandler struct {
+	slog.Handler
+}
+
+func (this *contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if ctx != nil {
+		if requestId, ok := ctx.Value(db_service.AuditRequestIdKey).(string); ok && requestId != "" {
+			record.AddAttrs(slog.String("request_id", requestId))
+		}
+		spanCtx := trace.SpanContextFromContext(ctx)
+		if ginCtx, ok := ctx.(*gin.Context); ok && !spanCtx.IsValid() && ginCtx.Request != nil {
+			spanCtx = trace.SpanContextFromContext(ginCtx.Request.Context())
+		}
+		if spanCtx.IsValid() {
+			record.AddAttrs(
+				slog.String("trace_id", spanCtx.TraceID().String()),
+				slog.String("span_id", spanCtx.SpanID().String()),
+			)
+		}
+	}
+	return this.Handler.Handle(ctx, record)
+}
+
+func (this *contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &contextHandler{this.Handler.WithAttrs(attrs)}
+}
+
+func (this *contextHandler) WithGroup(name string) slog.Handler {
+	return &contextHandler{this.Handler.WithGroup(name)}
+}
+
+var validRequestId = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// Middleware assigns the request id, or propagates the one of the caller,
+// and logs the completed request
+func Middleware() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		start := time.Now()
+
+		requestId := ctx.GetHeader(RequestIdHeader)
+		if !validRequestId.MatchString(requestId) {
+			requestId = uuid.New().String()
+		}
+		ctx.Set(db_service.AuditRequestIdKey, requestId)
+		ctx.Header(RequestIdHeader, requestId)
+
+		ctx.Next()
+
+		route := ctx.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		level := slog.LevelInfo
+		switch status := ctx.Writer.Status(); {
+		case status >= 500:
+			level = slog.LevelError
+		case status >= 400:
+			level = slog.LevelWarn
+		}
+		// the path is not logged, it may carry identifiers of the donors
+		slog.Log(ctx, level, "Request completed",
+			"method", ctx.Request.Method,
+			"route", route,
+			"status", ctx.Writer.Status(),
+			"duration_ms", time.Since(start).Milliseconds(),
+			"size", ctx.Writer.Size(),
+		)
+	}
+}
@@ -0,0 +1,161 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/Marek-FIIT/sprava-krvi-webapi/internal/db_service"
+)
+
+type meteredSvc[DocType interface{}] struct {
+	svc        db_service.DbService[DocType]
+	collection string
+}
+
+// NewMeteredService counts and times every operation of the db service.
+// Wrap the plain service, the audited service has to stay outermost to be found by GetAuditTrail.
+func NewMeteredService[DocType interface{}](svc db_service.DbService[DocType], collection string) db_service.DbService[DocType] {
+	return &meteredSvc[DocType]{
+		svc:        svc,
+		collection: collection,
+	}
+}
+
+func (this *meteredSvc[DocType]) CreateDocument(ctx context.Context, id string, document *DocType) error {
+	start := time.Now()
+	err := this.svc.CreateDocument(ctx, id, document)
+	observeDb("create", this.collection, start, err)
+	return err
+}
+
+func (this *meteredSvc[DocType]) CreateDocuments(ctx context.Context, ids []string, documents []*DocType) error {
+	start := time.Now()
+	err := this.svc.CreateDocuments(ctx, ids, documents)
+	observeDb("create_many", this.collection, start, err)
+	return err
+}
+
+func (this *meteredSvc[DocType]) FindDocument(ctx context.Context, id string) (*DocType, error) {
+	start := time.Now()
+	document, err := this.svc.FindDocument(ctx, id)
+	observeDb("find", this.collection, start, err)
+	return document, err
+}
+
+func (this *meteredSvc[DocType]) FindDocuments(ctx context.Context, query db_service.Query) ([]*DocType, error) {
+	start := time.Now()
+	documents, err := this.svc.FindDocuments(ctx, query)
+	observeDb("find_many", this.collection, start, err)
+	return documents, err
+}
+
+func (this *meteredSvc[DocType]) StreamDocuments(ctx context.Context, query db_service.Query, fn func(document *DocType) error) error {
+	start := time.Now()
+	err := this.svc.StreamDocuments(ctx, query, fn)
+	observeDb("stream", this.collection, start, err)
+	return err
+}
+
+func (this *meteredSvc[DocType]) UpdateDocument(ctx context.Context, id string, document *DocType) error {
+	start := time.Now()
+	err := this.svc.UpdateDocument(ctx, id, document)
+	observeDb("update", this.collection, start, err)
+	return err
+}
+
+func (this *meteredSvc[DocType]) DeleteDocument(ctx context.Context, id string) error {
+	start := time.Now()
+	err := this.svc.DeleteDocument(ctx, id)
+	observeDb("delete", this.collection, start, err)
+	return err
+}
+
+func (this *meteredSvc[DocType]) BeginTransaction(ctx context.Context) (db_service.Transaction[DocType], error) {
+	start := time.Now()
+	transaction, err := this.svc.BeginTransaction(ctx)
+	observeDb("begin_transaction", this.collection, start, err)
+	if err != nil {
+		return nil, err
+	}
+	return &meteredTransaction[DocType]{transaction: transaction, collection: this.collection}, nil
+}
+
+func (this *meteredSvc[DocType]) WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	start := time.Now()
+	err := this.svc.WithTransaction(ctx, fn)
+	observeDb("transaction", this.collection, start, err)
+	return err
+}
+
+func (this *meteredSvc[DocType]) HealthCheck(ctx context.Context) error {
+	start := time.Now()
+	err := this.svc.HealthCheck(ctx)
+	observeDb("ping", this.collection, start, err)
+	return err
+}
+
+func (this *meteredSvc[DocType]) EnsureSchema(ctx context.Context) error {
+	start := time.Now()
+	err := this.svc.EnsureSchema(ctx)
+	observeDb("ensure_schema", this.collection, start, err)
+	return err
+}
+
+func (this *meteredSvc[DocType]) Disconnect(ctx context.Context) error {
+	start := time.Now()
+	err := this.svc.Disconnect(ctx)
+	observeDb("disconnect", this.collection, start, err)
+	return err
+}
+
+type meteredTransaction[DocType interface{}] struct {
+	transaction db_service.Transaction[DocType]
+	collection  string
+}
+
+func (this *meteredTransaction[DocType]) CreateDocument(ctx context.Context, id string, document *DocType) error {
+	start := time.Now()
+	err := this.transaction.CreateDocument(ctx, id, document)
+	observeDb("transaction_create", this.collection, start, err)
+	return err
+}
+
+func (this *meteredTransaction[DocType]) Commit() error {
+	start := time.Now()
+	err := this.transaction.Commit()
+	observeDb("commit", this.collection, start, err)
+	return err
+}
+
+func (this *meteredTransaction[DocType]) Rollback() error {
+	start := time.Now()
+	err := this.transaction.Rollback()
+	observeDb("rollback", this.collection, start, err)
+	return err
+}
+
+func (this *meteredTransaction[DocType]) Context(ctx context.Context) context.Context {
+	return this.transaction.Context(ctx)
+}
+
+func observeDb(operation string, collection string, start time.Time, err error) {
+	outcome := outcomeOf(err)
+	dbOperations.WithLabelValues(operation, collection, outcome).Inc()
+	dbDuration.WithLabelValues(operation, collection, outcome).Observe(time.Since(start).Seconds())
+}
+
+func outcomeOf(err error) string {
+	switch {
+	case err == nil:
+		return "success"
+	case errors.Is(err, db_service.ErrNotFound):
+		return "not_found"
+	case errors.Is(err, db_service.ErrConflict):
+		return "conflict"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	default:
+		return "error"
+	}
+}
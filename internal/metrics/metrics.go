@@ -0,0 +1,89 @@
+package metrics
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "sprava_krvi"
+
+var (
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "Number of handled HTTP requests.",
+	}, []string{"method", "route", "status"})
+
+	httpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Duration of the HTTP request handling.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	dbOperations = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "db_operations_total",
+		Help:      "Number of database operations.",
+	}, []string{"operation", "collection", "outcome"})
+
+	dbDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "db_operation_duration_seconds",
+		Help:      "Duration of the database operations.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"operation", "collection", "outcome"})
+)
+
+// domain gauges, refreshed periodically by the api implementation
+var (
+	AvailableUnits = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "units_available",
+		Help:      "Number of available blood units containing the component.",
+	}, []string{"blood_group", "component"})
+
+	ExpiringUnits = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "units_expiring",
+		Help:      "Number of available blood units expiring within 72 hours.",
+	}, []string{"blood_group"})
+
+	EligibleDonors = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "donors_eligible",
+		Help:      "Number of donors eligible for a donation.",
+	})
+
+	DomainRefreshed = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "domain_metrics_refreshed_timestamp_seconds",
+		Help:      "Time of the last successful refresh of the domain metrics.",
+	})
+)
+
+// Middleware counts the requests and measures their latency, labelled by the route template
+func Middleware() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		start := time.Now()
+		ctx.Next()
+
+		route := ctx.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		status := strconv.Itoa(ctx.Writer.Status())
+		httpRequests.WithLabelValues(ctx.Request.Method, route, status).Inc()
+		httpDuration.WithLabelValues(ctx.Request.Method, route, status).Observe(time.Since(start).Seconds())
+	}
+}
+
+// HandleMetrics exposes the metrics in the Prometheus text format
+func HandleMetrics() gin.HandlerFunc {
+	return gin.WrapH(promhttp.Handler())
+}
@@ -0,0 +1,234 @@
+package migrations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/Marek-FIIT/sprava-krvi-webapi/internal/db_service"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Collections - names of the migrated collections, as configured
+type Collections struct {
+	Donor string
+	Unit  string
+	Audit string
+}
+
+// Env - what the migration operates on
+type Env struct {
+	Database    *mongo.Database
+	Collections Collections
+	// the migration only counts the documents it would change
+	DryRun bool
+}
+
+// Migration - single versioned change of the stored documents.
+// Migrations are applied in the order of their versions and each one exactly once.
+type Migration struct {
+	Version     int
+	Description string
+	// Up returns the number of the changed documents, in the dry run those that would change
+	Up func(ctx context.Context, env Env) (int64, error)
+}
+
+// all migrations, append the new ones with the next version
+var all = []Migration{
+	snakeCaseFields,
+	birthNumbersWithoutSlash,
+}
+
+type Config struct {
+	Client      *db_service.MongoClient
+	DbName      string
+	Collections Collections
+	// collection holding the applied versions and the lock
+	VersionCollection string
+	// report the pending migrations without applying them.
+	// Every migration sees the documents unchanged by the previous ones.
+	DryRun bool
+	// how long a crashed instance holds the lock
+	LockLease time.Duration
+}
+
+// Result - outcome of a single migration
+type Result struct {
+	Version     int
+	Description string
+	Documents   int64
+}
+
+// applied version, the lock is stored in the same collection with the string id
+type versionRecord struct {
+	Version     int       `bson:"_id"`
+	Description string    `bson:"description"`
+	AppliedAt   time.Time `bson:"applied_at"`
+	Documents   int64     `bson:"documents"`
+	DurationMs  int64     `bson:"duration_ms"`
+}
+
+const lockId = "lock"
+
+var ErrLocked = errors.New("migrations are being applied by another instance")
+
+// Run applies the pending migrations, holding a lock so that the replicas do not migrate concurrently
+func Run(ctx context.Context, config Config) ([]Result, error) {
+	if config.VersionCollection == "" {
+		config.VersionCollection = "schema_version"
+	}
+	if config.LockLease == 0 {
+		config.LockLease = 10 * time.Minute
+	}
+	if err := validate(all); err != nil {
+		return nil, err
+	}
+
+	client, err := config.Client.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	db := client.Database(config.DbName)
+	versions := db.Collection(config.VersionCollection)
+
+	if !config.DryRun {
+		release, err := acquireLock(ctx, versions, config.LockLease)
+		if err != nil {
+			return nil, err
+		}
+		defer release()
+	}
+
+	applied, err := appliedVersions(ctx, versions)
+	if err != nil {
+		return nil, err
+	}
+
+	env := Env{Database: db, Collections: config.Collections, DryRun: config.DryRun}
+	var results []Result
+	for _, migration := range all {
+		if applied[migration.Version] {
+			continue
+		}
+
+		start := time.Now()
+		documents, err := migration.Up(ctx, env)
+		if err != nil {
+			return results, fmt.Errorf("migration %v (%v) failed: %w", migration.Version, migration.Description, err)
+		}
+		results = append(results, Result{Version: migration.Version, Description: migration.Description, Documents: documents})
+		if config.DryRun {
+			slog.InfoContext(ctx, "Migration pending", "version", migration.Version, "description", migration.Description, "documents", documents)
+			continue
+		}
+
+		record := versionRecord{
+			Version:     migration.Version,
+			Description: migration.Description,
+			AppliedAt:   time.Now(),
+			Documents:   documents,
+			DurationMs:  time.Since(start).Milliseconds(),
+		}
+		if _, err := versions.InsertOne(ctx, record); err != nil {
+			return results, fmt.Errorf("migration %v applied but not recorded: %w", migration.Version, err)
+		}
+		slog.InfoContext(ctx, "Migration applied", "version", migration.Version, "description", migration.Description, "documents", documents)
+	}
+	return results, nil
+}
+
+func validate(migrations []Migration) error {
+	sorted := sort.SliceIsSorted(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	if !sorted {
+		return errors.New("migrations are not ordered by version")
+	}
+	for i, migration := range migrations {
+		if migration.Version <= 0 || migration.Up == nil {
+			return fmt.Errorf("migration %v is incomplete", migration.Version)
+		}
+		if i > 0 && migrations[i-1].Version == migration.Version {
+			return fmt.Errorf("migration version %v is duplicated", migration.Version)
+		}
+	}
+	return nil
+}
+
+func appliedVersions(ctx context.Context, versions *mongo.Collection) (map[int]bool, error) {
+	cursor, err := versions.Find(ctx, bson.D{{Key: "_id", Value: bson.D{{Key: "$type", Value: "number"}}}})
+	if err != nil {
+		return nil, err
+	}
+	var records []versionRecord
+	if err := cursor.All(ctx, &records); err != nil {
+		return nil, err
+	}
+	applied := make(map[int]bool)
+	for _, record := range records {
+		applied[record.Version] = true
+	}
+	return applied, nil
+}
+
+// acquireLock takes the lock unless it is held by another instance and its lease did not expire yet
+func acquireLock(ctx context.Context, versions *mongo.Collection, lease time.Duration) (func(), error) {
+	owner, _ := os.Hostname()
+	owner = fmt.Sprintf("%v/%v", owner, os.Getpid())
+	now := time.Now()
+
+	filter := bson.D{
+		{Key: "_id", Value: lockId},
+		{Key: "locked_until", Value: bson.D{{Key: "$lt", Value: now}}},
+	}
+	update := bson.D{{Key: "$set", Value: bson.D{
+		{Key: "owner", Value: owner},
+		{Key: "locked_until", Value: now.Add(lease)},
+	}}}
+	// the upsert conflicts on the id when the lock is held
+	err := versions.FindOneAndUpdate(ctx, filter, update, options.FindOneAndUpdate().SetUpsert(true)).Err()
+	switch {
+	case err == nil, errors.Is(err, mongo.ErrNoDocuments):
+	case mongo.IsDuplicateKeyError(err):
+		return nil, ErrLocked
+	default:
+		return nil, err
+	}
+
+	return func() {
+		// released even if the migration context is cancelled
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		filter := bson.D{{Key: "_id", Value: lockId}, {Key: "owner", Value: owner}}
+		update := bson.D{{Key: "$set", Value: bson.D{{Key: "locked_until", Value: time.Time{}}}}}
+		if _, err := versions.UpdateOne(ctx, filter, update); err != nil {
+			slog.Warn("Failed to release the migration lock", "error", err)
+		}
+	}, nil
+}
+
+// RenameFields renames the fields of the documents still having any of the old names
+func RenameFields(ctx context.Context, env Env, collection string, renames map[string]string) (int64, error) {
+	var exists bson.A
+	var rename bson.D
+	for from, to := range renames {
+		exists = append(exists, bson.D{{Key: from, Value: bson.D{{Key: "$exists", Value: true}}}})
+		rename = append(rename, bson.E{Key: from, Value: to})
+	}
+	filter := bson.D{{Key: "$or", Value: exists}}
+
+	if env.DryRun {
+		return env.Database.Collection(collection).CountDocuments(ctx, filter)
+	}
+	result, err := env.Database.Collection(collection).UpdateMany(ctx, filter, bson.D{{Key: "$rename", Value: rename}})
+	if err != nil {
+		return 0, err
+	}
+	return result.ModifiedCount, nil
+}
@@ -0,0 +1,133 @@
+package migrations
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Marek-FIIT/sprava-krvi-webapi/internal/db_service"
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestValidate(t *testing.T) {
+	up := func(ctx context.Context, env Env) (int64, error) { return 0, nil }
+	tests := []struct {
+		name       string
+		migrations []Migration
+		valid      bool
+	}{
+		{"all", all, true},
+		{"ordered", []Migration{{Version: 1, Up: up}, {Version: 3, Up: up}}, true},
+		{"not ordered", []Migration{{Version: 2, Up: up}, {Version: 1, Up: up}}, false},
+		{"duplicated", []Migration{{Version: 1, Up: up}, {Version: 1, Up: up}}, false},
+		{"no version", []Migration{{Up: up}}, false},
+		{"no change", []Migration{{Version: 1}}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := validate(test.migrations); (err == nil) != test.valid {
+				t.Errorf("got %v, want valid %v", err, test.valid)
+			}
+		})
+	}
+}
+
+// testDatabase connects to the server given by API_TEST_MONGODB_URI, the test is skipped without it
+func testDatabase(t *testing.T) (*db_service.MongoClient, *mongo.Database) {
+	uri := os.Getenv("API_TEST_MONGODB_URI")
+	if uri == "" {
+		t.Skip("API_TEST_MONGODB_URI is not set")
+	}
+	client, err := db_service.NewMongoClient(db_service.MongoClientConfig{Uri: uri})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	connected, err := client.Connect(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	database := connected.Database("test_" + uuid.New().String()[:8])
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		database.Drop(ctx)
+		client.Disconnect(ctx)
+	})
+	return client, database
+}
+
+func TestRun(t *testing.T) {
+	client, database := testDatabase(t)
+	ctx := context.Background()
+	_, err := database.Collection("donor").InsertMany(ctx, []interface{}{
+		bson.D{{Key: "id", Value: "1"}, {Key: "firstname", Value: "Peter"}, {Key: "birth_number", Value: "990812/1377"}},
+		bson.D{{Key: "id", Value: "2"}, {Key: "first_name", Value: "Alica"}, {Key: "birth_number", Value: "9955120010"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := Config{
+		Client:      client,
+		DbName:      database.Name(),
+		Collections: Collections{Donor: "donor", Unit: "unit", Audit: "audit"},
+	}
+
+	tests := []struct {
+		name    string
+		dryRun  bool
+		results []Result
+	}{
+		{"dry run", true, []Result{{Version: 1, Documents: 1}, {Version: 2, Documents: 1}}},
+		{"applied", false, []Result{{Version: 1, Documents: 1}, {Version: 2, Documents: 1}}},
+		{"nothing pending", false, nil},
+	}
+	for _, test := range tests {
+		config.DryRun = test.dryRun
+		results, err := Run(ctx, config)
+		if err != nil {
+			t.Fatalf("%v: %v", test.name, err)
+		}
+		if len(results) != len(test.results) {
+			t.Fatalf("%v: got %+v, want %+v", test.name, results, test.results)
+		}
+		for index, result := range results {
+			if result.Version != test.results[index].Version || result.Documents != test.results[index].Documents {
+				t.Errorf("%v: got %+v, want %+v", test.name, result, test.results[index])
+			}
+		}
+	}
+
+	var donor struct {
+		FirstName   string `bson:"first_name"`
+		BirthNumber string `bson:"birth_number"`
+	}
+	if err := database.Collection("donor").FindOne(ctx, bson.D{{Key: "id", Value: "1"}}).Decode(&donor); err != nil {
+		t.Fatal(err)
+	}
+	if donor.FirstName != "Peter" || donor.BirthNumber != "9908121377" {
+		t.Errorf("migrated to %+v", donor)
+	}
+}
+
+func TestRunHonorsLock(t *testing.T) {
+	client, database := testDatabase(t)
+	ctx := context.Background()
+	_, err := database.Collection("schema_version").InsertOne(ctx, bson.D{
+		{Key: "_id", Value: lockId},
+		{Key: "owner", Value: "other"},
+		{Key: "locked_until", Value: time.Now().Add(time.Minute)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = Run(ctx, Config{Client: client, DbName: database.Name(), Collections: Collections{Donor: "donor", Unit: "unit"}})
+	if !errors.Is(err, ErrLocked) {
+		t.Errorf("got %v, want %v", err, ErrLocked)
+	}
+}
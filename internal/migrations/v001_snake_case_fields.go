@@ -0,0 +1,36 @@
+package migrations
+
+import "context"
+
+// the documents written before the bson tags were added use the lower-cased go field names
+var snakeCaseFields = Migration{
+	Version:     1,
+	Description: "rename the lower-cased donor and unit fields to the snake_case api names",
+	Up: func(ctx context.Context, env Env) (int64, error) {
+		donors, err := RenameFields(ctx, env, env.Collections.Donor, map[string]string{
+			"birthnumber":  "birth_number",
+			"firstname":    "first_name",
+			"lastname":     "last_name",
+			"postalcode":   "postal_code",
+			"bloodtype":    "blood_type",
+			"bloodrh":      "blood_rh",
+			"lastdonation": "last_donation",
+			"phonenumber":  "phone_number",
+			"createdat":    "created_at",
+			"updatedat":    "updated_at",
+		})
+		if err != nil {
+			return donors, err
+		}
+
+		units, err := RenameFields(ctx, env, env.Collections.Unit, map[string]string{
+			"donorid":    "donor_id",
+			"donationid": "donation_id",
+			"bloodtype":  "blood_type",
+			"bloodrh":    "blood_rh",
+			"createdat":  "created_at",
+			"updatedat":  "updated_at",
+		})
+		return donors + units, err
+	},
+}
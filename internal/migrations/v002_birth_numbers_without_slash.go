@@ -0,0 +1,55 @@
+package migrations
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// the donors created by the api before the birth numbers were normalized keep the slash, so that
+// the imports and the searches without it missed them
+var birthNumbersWithoutSlash = Migration{
+	Version:     2,
+	Description: "remove the slash and the spaces from the birth numbers of the donors",
+	Up: func(ctx context.Context, env Env) (int64, error) {
+		donors := env.Database.Collection(env.Collections.Donor)
+		filter := bson.D{{Key: "birth_number", Value: bson.D{{Key: "$regex", Value: "[/ ]"}}}}
+		if env.DryRun {
+			return donors.CountDocuments(ctx, filter)
+		}
+
+		cursor, err := donors.Find(ctx, filter)
+		if err != nil {
+			return 0, err
+		}
+		defer cursor.Close(ctx)
+
+		var changed int64
+		for cursor.Next(ctx) {
+			var donor struct {
+				ObjectId    interface{} `bson:"_id"`
+				Id          string      `bson:"id"`
+				BirthNumber string      `bson:"birth_number"`
+			}
+			if err := cursor.Decode(&donor); err != nil {
+				return changed, err
+			}
+			normalized := strings.NewReplacer("/", "", " ", "").Replace(donor.BirthNumber)
+			update := bson.D{{Key: "$set", Value: bson.D{{Key: "birth_number", Value: normalized}}}}
+			_, err := donors.UpdateOne(ctx, bson.D{{Key: "_id", Value: donor.ObjectId}}, update)
+			// the same donor registered both with and without the slash is left to be merged
+			if mongo.IsDuplicateKeyError(err) {
+				slog.Warn("Birth number of the donor registered also without the slash, merge the duplicate donors",
+					"donor", donor.Id)
+				continue
+			} else if err != nil {
+				return changed, err
+			}
+			changed++
+		}
+		return changed, cursor.Err()
+	},
+}
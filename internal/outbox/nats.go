@@ -0,0 +1,143 @@
+package outbox
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Marek-FIIT/sprava-krvi-webapi/internal/db_service"
+)
+
+// header of the JetStream de-duplication, the streams drop the repeated ids within their duplicate window
+const natsMsgIdHeader = "Nats-Msg-Id"
+
+type NatsConfig struct {
+	// nats://[user:password@]host:port
+	Url string
+	// the events are published to the subject <prefix>.<event type>
+	SubjectPrefix string
+	Timeout       time.Duration
+}
+
+// natsSink publishes the events by the NATS client protocol, the server confirms the receipt of a message
+// by the PONG following it. Only the few protocol operations needed for publishing are implemented.
+type natsSink struct {
+	config NatsConfig
+	mutex  sync.Mutex
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func NewNatsSink(config NatsConfig) (Sink, error) {
+	target, err := url.Parse(config.Url)
+	if err != nil || target.Scheme != "nats" || target.Host == "" {
+		return nil, fmt.Errorf("%q is not a nats:// URL", config.Url)
+	}
+	return &natsSink{config: config}, nil
+}
+
+func (this *natsSink) Name() string {
+	return "nats"
+}
+
+func (this *natsSink) Publish(ctx context.Context, event *db_service.OutboxEvent) error {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if this.conn == nil {
+		if err := this.connect(ctx); err != nil {
+			return err
+		}
+	}
+	subject := this.config.SubjectPrefix + "." + event.Type
+	headers := fmt.Sprintf("NATS/1.0\r\n%v: %v\r\n\r\n", natsMsgIdHeader, event.Id)
+	message := fmt.Sprintf("HPUB %v %v %v\r\n%v%v\r\nPING\r\n", subject, len(headers), len(headers)+len(event.Payload), headers, event.Payload)
+	if err := this.roundTrip(ctx, message); err != nil {
+		this.close()
+		return err
+	}
+	return nil
+}
+
+func (this *natsSink) connect(ctx context.Context) error {
+	target, _ := url.Parse(this.config.Url)
+	dialer := net.Dialer{Timeout: this.config.Timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", target.Host)
+	if err != nil {
+		return err
+	}
+	this.conn = conn
+	this.reader = bufio.NewReader(conn)
+
+	options := map[string]interface{}{
+		"verbose":  false,
+		"pedantic": false,
+		"headers":  true,
+		"name":     "sprava-krvi-outbox",
+		"lang":     "go",
+		"protocol": 1,
+	}
+	if target.User != nil {
+		options["user"] = target.User.Username()
+		options["pass"], _ = target.User.Password()
+	}
+	connect, err := json.Marshal(options)
+	if err != nil {
+		this.close()
+		return err
+	}
+	// the server greets by its INFO, the PONG confirms the accepted CONNECT
+	if err := this.roundTrip(ctx, "CONNECT "+string(connect)+"\r\nPING\r\n"); err != nil {
+		this.close()
+		return err
+	}
+	return nil
+}
+
+// roundTrip writes the operations ending by a PING and waits for the PONG
+func (this *natsSink) roundTrip(ctx context.Context, operations string) error {
+	deadline := time.Now().Add(this.config.Timeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	if err := this.conn.SetDeadline(deadline); err != nil {
+		return err
+	}
+	if _, err := this.conn.Write([]byte(operations)); err != nil {
+		return err
+	}
+	for {
+		line, err := this.reader.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		switch {
+		case line == "PONG":
+			return nil
+		case line == "PING":
+			if _, err := this.conn.Write([]byte("PONG\r\n")); err != nil {
+				return err
+			}
+		case strings.HasPrefix(line, "-ERR"):
+			return errors.New("nats server: " + strings.TrimSpace(strings.TrimPrefix(line, "-ERR")))
+		default:
+			// INFO and +OK
+		}
+	}
+}
+
+func (this *natsSink) close() {
+	if this.conn != nil {
+		this.conn.Close()
+	}
+	this.conn = nil
+	this.reader = nil
+}
@@ -0,0 +1,150 @@
+package outbox
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/Marek-FIIT/sprava-krvi-webapi/internal/db_service"
+	"github.com/google/uuid"
+)
+
+// delay of the retries doubles while a sink keeps failing, up to this
+const maxRetryDelay = time.Minute
+
+// LeaseName - name of the lease held by the relay publishing the events, see Config.Leases
+const LeaseName = "outbox_relay"
+
+// Sink publishes the outbox events to the consumers
+type Sink interface {
+	// Name identifies the sink in the published_to list of the events, it must not change
+	Name() string
+	// Publish has to be idempotent by the event id, an event is published again when the relay fails to mark it
+	Publish(ctx context.Context, event *db_service.OutboxEvent) error
+}
+
+type Config struct {
+	Store db_service.DbService[db_service.OutboxEvent]
+	Sinks []Sink
+	// interval of looking up the new events
+	PollInterval time.Duration
+	// events loaded per query
+	BatchSize int
+	// the relays of all replicas take turns holding the LeaseName lease, only its holder publishes
+	Leases db_service.Counters
+	// how long a crashed relay holds the lease, longer than publishing a batch, 2 minutes by default
+	Lease time.Duration
+}
+
+// Relay publishes the events recorded in the outbox to the sinks in the order of their recording.
+// An event failed to be published by a sink holds up the later ones until it succeeds. The relays
+// of the replicas publish only while holding the lease, since concurrent relays would publish
+// the events more than once and out of order.
+type Relay struct {
+	config Config
+	// identifies the relay as the holder of the lease
+	owner   string
+	leading bool
+}
+
+func NewRelay(config Config) *Relay {
+	if config.Lease == 0 {
+		config.Lease = 2 * maxRetryDelay
+	}
+	host, _ := os.Hostname()
+	return &Relay{
+		config: config,
+		owner:  fmt.Sprintf("%v/%v/%v", host, os.Getpid(), uuid.New().String()[:8]),
+	}
+}
+
+// Run publishes the pending events until the context is done
+func (this *Relay) Run(ctx context.Context) {
+	defer func() {
+		// released even though the context is done, so that another replica takes over without waiting for the lease
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := this.config.Leases.Release(ctx, LeaseName, this.owner); err != nil {
+			slog.Warn("Failed to release the outbox relay lease", "error", err)
+		}
+	}()
+
+	delay := this.config.PollInterval
+	for {
+		if err := this.publishPending(ctx); err != nil {
+			delay = min(2*delay, maxRetryDelay)
+			slog.ErrorContext(ctx, "Failed to publish the outbox events, will retry", "delay", delay.String(), "error", err)
+		} else {
+			delay = this.config.PollInterval
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+	}
+}
+
+func (this *Relay) publishPending(ctx context.Context) error {
+	for {
+		// renewed before every batch, so that the lease does not expire while publishing
+		held, err := this.config.Leases.Acquire(ctx, LeaseName, this.owner, this.config.Lease)
+		if err != nil {
+			return err
+		}
+		if held != this.leading {
+			slog.InfoContext(ctx, "Outbox relay changed its role", "publishing", held)
+			this.leading = held
+		}
+		if !held {
+			return nil
+		}
+
+		events, err := this.config.Store.FindDocuments(ctx, db_service.NewQuery().
+			Eq("published", false).
+			OrderBy("sequence", false).
+			Take(int64(this.config.BatchSize)))
+		if err != nil {
+			return err
+		}
+		for _, event := range events {
+			if ctx.Err() != nil {
+				return nil
+			}
+			if err := this.publish(ctx, event); err != nil {
+				return err
+			}
+		}
+		if len(events) < this.config.BatchSize {
+			return nil
+		}
+	}
+}
+
+// publish hands the event over to the sinks which did not publish it yet and marks it published by all of them
+func (this *Relay) publish(ctx context.Context, event *db_service.OutboxEvent) error {
+	var failed error
+	for _, sink := range this.config.Sinks {
+		if slices.Contains(event.PublishedTo, sink.Name()) {
+			continue
+		}
+		if err := sink.Publish(ctx, event); err != nil {
+			failed = fmt.Errorf("sink %v failed to publish the event %v: %w", sink.Name(), event.Id, err)
+			break
+		}
+		event.PublishedTo = append(event.PublishedTo, sink.Name())
+	}
+	if failed == nil {
+		event.Published = true
+		event.PublishedAt = time.Now()
+	}
+
+	// the progress is kept also on a failure, so that the sinks already done are not repeated
+	if err := this.config.Store.UpdateDocument(ctx, event.Id, event); err != nil {
+		return err
+	}
+	return failed
+}
@@ -0,0 +1,157 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/Marek-FIIT/sprava-krvi-webapi/internal/db_service"
+)
+
+// memoryStore - outbox of the events in memory, it returns the unpublished events in the sequence order
+type memoryStore struct {
+	db_service.DbService[db_service.OutboxEvent]
+	events map[string]*db_service.OutboxEvent
+}
+
+func (this *memoryStore) FindDocuments(ctx context.Context, query db_service.Query) ([]*db_service.OutboxEvent, error) {
+	var pending []*db_service.OutboxEvent
+	for _, event := range this.events {
+		if !event.Published {
+			copied := *event
+			copied.PublishedTo = slices.Clone(event.PublishedTo)
+			pending = append(pending, &copied)
+		}
+	}
+	sort.Slice(pending, func(i, j int) bool { return pending[i].Sequence < pending[j].Sequence })
+	return pending, nil
+}
+
+func (this *memoryStore) UpdateDocument(ctx context.Context, id string, event *db_service.OutboxEvent) error {
+	this.events[id] = event
+	return nil
+}
+
+// leases - the lease is held by the relay or by another one
+type leases struct {
+	held bool
+}
+
+func (this *leases) Next(ctx context.Context, name string) (int64, error) {
+	return 0, errors.New("not supported")
+}
+
+func (this *leases) Acquire(ctx context.Context, name string, owner string, lease time.Duration) (bool, error) {
+	return this.held, nil
+}
+
+func (this *leases) Release(ctx context.Context, name string, owner string) error {
+	return nil
+}
+
+// recordingSink - records the ids of the events it published, it fails on the events of the failing set
+type recordingSink struct {
+	name      string
+	published []string
+	failing   map[string]bool
+}
+
+func (this *recordingSink) Name() string {
+	return this.name
+}
+
+func (this *recordingSink) Publish(ctx context.Context, event *db_service.OutboxEvent) error {
+	if this.failing[event.Id] {
+		return fmt.Errorf("event %v refused", event.Id)
+	}
+	this.published = append(this.published, event.Id)
+	return nil
+}
+
+func newTestRelay(held bool, sinks ...Sink) (*Relay, *memoryStore) {
+	store := &memoryStore{events: make(map[string]*db_service.OutboxEvent)}
+	// recorded out of their order
+	for _, sequence := range []int64{3, 1, 2} {
+		id := fmt.Sprint("event-", sequence)
+		store.events[id] = &db_service.OutboxEvent{Id: id, Sequence: sequence}
+	}
+	return NewRelay(Config{Store: store, Sinks: sinks, BatchSize: 10, Leases: &leases{held: held}}), store
+}
+
+func TestRelayPublishesInSequenceOrder(t *testing.T) {
+	first, second := &recordingSink{name: "first"}, &recordingSink{name: "second"}
+	relay, store := newTestRelay(true, first, second)
+	if err := relay.publishPending(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "[event-1 event-2 event-3]"
+	for _, sink := range []*recordingSink{first, second} {
+		if fmt.Sprint(sink.published) != want {
+			t.Errorf("sink %v published %v, want %v", sink.name, sink.published, want)
+		}
+	}
+	for id, event := range store.events {
+		if !event.Published || fmt.Sprint(event.PublishedTo) != "[first second]" {
+			t.Errorf("event %v published %v to %v", id, event.Published, event.PublishedTo)
+		}
+	}
+}
+
+func TestRelayKeepsProgressOfSinks(t *testing.T) {
+	first := &recordingSink{name: "first"}
+	second := &recordingSink{name: "second", failing: map[string]bool{"event-2": true}}
+	relay, store := newTestRelay(true, first, second)
+
+	if err := relay.publishPending(context.Background()); err == nil {
+		t.Fatal("expected the failure of the sink to be returned")
+	}
+	tests := []struct {
+		id          string
+		published   bool
+		publishedTo string
+	}{
+		{"event-1", true, "[first second]"},
+		// the first sink is done, the second one is retried
+		{"event-2", false, "[first]"},
+		// held up by the failed event
+		{"event-3", false, "[]"},
+	}
+	for _, test := range tests {
+		event := store.events[test.id]
+		if event.Published != test.published || fmt.Sprint(event.PublishedTo) != test.publishedTo {
+			t.Errorf("event %v published %v to %v, want %v to %v", test.id, event.Published, event.PublishedTo, test.published, test.publishedTo)
+		}
+	}
+
+	second.failing = nil
+	if err := relay.publishPending(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := fmt.Sprint(first.published), "[event-1 event-2 event-3]"; got != want {
+		t.Errorf("first sink published %v, want %v", got, want)
+	}
+	if got, want := fmt.Sprint(second.published), "[event-1 event-2 event-3]"; got != want {
+		t.Errorf("second sink published %v, want %v", got, want)
+	}
+}
+
+func TestRelayPublishesOnlyWithLease(t *testing.T) {
+	sink := &recordingSink{name: "sink"}
+	relay, store := newTestRelay(false, sink)
+	if err := relay.publishPending(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(sink.published) != 0 {
+		t.Errorf("published %v without the lease", sink.published)
+	}
+	for id, event := range store.events {
+		if event.Published {
+			t.Errorf("event %v marked published without the lease", id)
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package outbox
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/Marek-FIIT/sprava-krvi-webapi/internal/db_service"
+)
+
+type logSink struct{}
+
+// NewLogSink logs the events, meant for the development and the audit of the published events
+func NewLogSink() Sink {
+	return logSink{}
+}
+
+func (logSink) Name() string {
+	return "log"
+}
+
+func (logSink) Publish(ctx context.Context, event *db_service.OutboxEvent) error {
+	slog.InfoContext(ctx, "Domain event published",
+		"event_id", event.Id,
+		"event_type", event.Type,
+		"collection", event.Collection,
+		"document_id", event.DocumentId,
+		"request_id", event.RequestId,
+		"payload", event.Payload,
+	)
+	return nil
+}
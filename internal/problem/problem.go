@@ -0,0 +1,164 @@
+package problem
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/Marek-FIIT/sprava-krvi-webapi/internal/db_service"
+	"github.com/gin-gonic/gin"
+)
+
+const ContentType = "application/problem+json"
+
+// stable, machine readable problem types
+const (
+	TypeValidation      = "urn:sprava-krvi:problem:validation"
+	TypeUnauthorized    = "urn:sprava-krvi:problem:unauthorized"
+	TypeForbidden       = "urn:sprava-krvi:problem:forbidden"
+	TypeNotFound        = "urn:sprava-krvi:problem:not-found"
+	TypeConflict        = "urn:sprava-krvi:problem:conflict"
+	TypeInternal        = "urn:sprava-krvi:problem:internal"
+	TypeDatabase        = "urn:sprava-krvi:problem:database-unavailable"
+	TypeDatabaseTimeout = "urn:sprava-krvi:problem:database-timeout"
+)
+
+var titles = map[string]string{
+	TypeValidation:      "Invalid request",
+	TypeUnauthorized:    "Authentication required",
+	TypeForbidden:       "Operation not permitted",
+	TypeNotFound:        "Resource not found",
+	TypeConflict:        "Resource conflict",
+	TypeInternal:        "Internal server error",
+	TypeDatabase:        "Database unavailable",
+	TypeDatabaseTimeout: "Database timeout",
+}
+
+// FieldError - validation failure of a single request field
+type FieldError struct {
+	// dot separated path of the field
+	Field string `json:"field"`
+	// part of the request holding the field: body, query, path or header
+	In      string `json:"in,omitempty"`
+	Message string `json:"message"`
+}
+
+// Problem - RFC 7807 problem details of a failed request
+type Problem struct {
+	Type          string       `json:"type"`
+	Title         string       `json:"title"`
+	Status        int          `json:"status"`
+	Detail        string       `json:"detail,omitempty"`
+	Instance      string       `json:"instance,omitempty"`
+	CorrelationId string       `json:"correlation_id,omitempty"`
+	Errors        []FieldError `json:"errors,omitempty"`
+
+	// underlying error, logged but never sent to the client
+	cause error
+}
+
+func (this *Problem) Error() string {
+	if this.cause != nil {
+		return fmt.Sprintf("%v: %v: %v", this.Title, this.Detail, this.cause)
+	}
+	return fmt.Sprintf("%v: %v", this.Title, this.Detail)
+}
+
+func (this *Problem) Unwrap() error {
+	return this.cause
+}
+
+func New(status int, problemType string, detail string) *Problem {
+	return &Problem{
+		Type:   problemType,
+		Title:  titles[problemType],
+		Status: status,
+		Detail: detail,
+	}
+}
+
+func BadRequest(detail string, errors ...FieldError) *Problem {
+	problem := New(http.StatusBadRequest, TypeValidation, detail)
+	problem.Errors = errors
+	return problem
+}
+
+func Unauthorized(detail string) *Problem {
+	return New(http.StatusUnauthorized, TypeUnauthorized, detail)
+}
+
+func Forbidden(detail string) *Problem {
+	return New(http.StatusForbidden, TypeForbidden, detail)
+}
+
+func NotFound(detail string) *Problem {
+	return New(http.StatusNotFound, TypeNotFound, detail)
+}
+
+func Conflict(detail string) *Problem {
+	return New(http.StatusConflict, TypeConflict, detail)
+}
+
+func Internal(detail string, cause error) *Problem {
+	problem := New(http.StatusInternalServerError, TypeInternal, detail)
+	problem.cause = cause
+	return problem
+}
+
+// FromError maps the db_service errors to problems, the detail describes the failed operation
+func FromError(err error, detail string) *Problem {
+	var problem *Problem
+	switch {
+	case errors.As(err, &problem):
+		return problem
+	case errors.Is(err, db_service.ErrNotFound):
+		problem = NotFound(detail)
+	case errors.Is(err, db_service.ErrConflict):
+		problem = Conflict(detail)
+	case errors.Is(err, context.DeadlineExceeded):
+		problem = New(http.StatusGatewayTimeout, TypeDatabaseTimeout, detail)
+	default:
+		problem = New(http.StatusBadGateway, TypeDatabase, detail)
+	}
+	problem.cause = err
+	return problem
+}
+
+// FromBindError describes why the request body could not be bound to the model
+func FromBindError(err error) *Problem {
+	var typeError *json.UnmarshalTypeError
+	var syntaxError *json.SyntaxError
+	switch {
+	case errors.As(err, &typeError):
+		return BadRequest("Invalid request body", FieldError{
+			Field:   typeError.Field,
+			In:      "body",
+			Message: fmt.Sprintf("expected %v, got %v", typeError.Type, typeError.Value),
+		})
+	case errors.As(err, &syntaxError):
+		return BadRequest(fmt.Sprintf("Request body is not valid JSON (offset %v)", syntaxError.Offset))
+	default:
+		return BadRequest("Invalid request body")
+	}
+}
+
+// Abort responds with the problem and stops the processing of the request
+func Abort(ctx *gin.Context, problem *Problem) {
+	problem.Instance = ctx.Request.URL.Path
+	problem.CorrelationId = ctx.GetString(db_service.AuditRequestIdKey)
+	if problem.Status >= http.StatusInternalServerError {
+		slog.ErrorContext(ctx, "Request failed",
+			"method", ctx.Request.Method,
+			"route", ctx.FullPath(),
+			"status", problem.Status,
+			"type", problem.Type,
+			"error", error(problem),
+		)
+	}
+
+	ctx.Header("Content-Type", ContentType)
+	ctx.AbortWithStatusJSON(problem.Status, problem)
+}
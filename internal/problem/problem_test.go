@@ -0,0 +1,104 @@
+package problem
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Marek-FIIT/sprava-krvi-webapi/internal/db_service"
+	"github.com/gin-gonic/gin"
+)
+
+func TestFromError(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		status int
+		typ    string
+	}{
+		{"not found", db_service.ErrNotFound, http.StatusNotFound, TypeNotFound},
+		{"wrapped conflict", fmt.Errorf("create: %w", db_service.ErrConflict), http.StatusConflict, TypeConflict},
+		{"conflicting ids", &db_service.ConflictError{Ids: []string{"1", "2"}}, http.StatusConflict, TypeConflict},
+		{"timeout", fmt.Errorf("find: %w", context.DeadlineExceeded), http.StatusGatewayTimeout, TypeDatabaseTimeout},
+		{"database failure", errors.New("connection refused"), http.StatusBadGateway, TypeDatabase},
+		{"problem passed through", BadRequest("Invalid filter"), http.StatusBadRequest, TypeValidation},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			problem := FromError(test.err, "Failed to load donor")
+			if problem.Status != test.status || problem.Type != test.typ {
+				t.Errorf("mapped to %v %v, want %v %v", problem.Status, problem.Type, test.status, test.typ)
+			}
+			if problem.Title != titles[test.typ] {
+				t.Errorf("title %q, want %q", problem.Title, titles[test.typ])
+			}
+			if !errors.Is(problem, test.err) {
+				t.Errorf("the cause %v is not kept", test.err)
+			}
+		})
+	}
+}
+
+func TestFromBindError(t *testing.T) {
+	unmarshal := func(body string) error {
+		var donor struct {
+			Eligible bool `json:"eligible"`
+		}
+		return json.Unmarshal([]byte(body), &donor)
+	}
+	tests := []struct {
+		name   string
+		err    error
+		detail string
+		errors []FieldError
+	}{
+		{"type mismatch", unmarshal(`{"eligible": "yes"}`), "Invalid request body", []FieldError{{Field: "eligible", In: "body", Message: "expected bool, got string"}}},
+		{"syntax error", unmarshal(`{"eligible": }`), "Request body is not valid JSON (offset 14)", nil},
+		{"empty body", io.EOF, "Invalid request body", nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			problem := FromBindError(test.err)
+			if problem.Status != http.StatusBadRequest || problem.Detail != test.detail {
+				t.Errorf("got %v %q, want 400 %q", problem.Status, problem.Detail, test.detail)
+			}
+			if fmt.Sprint(problem.Errors) != fmt.Sprint(test.errors) {
+				t.Errorf("field errors %v, want %v", problem.Errors, test.errors)
+			}
+		})
+	}
+}
+
+func TestAbort(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	recorder := httptest.NewRecorder()
+	ctx, _ := gin.CreateTestContext(recorder)
+	ctx.Request = httptest.NewRequest(http.MethodGet, "/api/donors/1", nil)
+	ctx.Set(db_service.AuditRequestIdKey, "request-1")
+
+	Abort(ctx, Internal("Failed to access db_service", errors.New("secret detail")))
+
+	if recorder.Code != http.StatusInternalServerError || recorder.Header().Get("Content-Type") != ContentType {
+		t.Fatalf("responded %v %v", recorder.Code, recorder.Header().Get("Content-Type"))
+	}
+	var body map[string]interface{}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"type":           TypeInternal,
+		"title":          "Internal server error",
+		"status":         float64(http.StatusInternalServerError),
+		"detail":         "Failed to access db_service",
+		"instance":       "/api/donors/1",
+		"correlation_id": "request-1",
+	}
+	if fmt.Sprint(body) != fmt.Sprint(want) {
+		t.Errorf("body %v, want %v", body, want)
+	}
+}
@@ -0,0 +1,238 @@
+package rbac
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"reflect"
+	"strings"
+
+	"github.com/Marek-FIIT/sprava-krvi-webapi/api"
+	"github.com/Marek-FIIT/sprava-krvi-webapi/internal/auth"
+	"github.com/Marek-FIIT/sprava-krvi-webapi/internal/problem"
+	"github.com/gin-gonic/gin"
+	"gopkg.in/yaml.v3"
+)
+
+//go:embed default-policy.yaml
+var defaultPolicy []byte
+
+const policyKey = "rbac_policy"
+
+type Role struct {
+	// operation ids of the openapi specification
+	Operations []string `yaml:"operations"`
+	// sensitive fields visible to the role, as <Schema>.<json path>
+	Fields []string `yaml:"fields"`
+}
+
+type Policy struct {
+	Roles map[string]Role `yaml:"roles"`
+	// sensitive fields of the schemas, hidden unless permitted to the role
+	Sensitive map[string][]string `yaml:"sensitive"`
+}
+
+type Config struct {
+	// policy file, the embedded default policy is used if empty
+	PolicyFile string
+}
+
+func NewPolicy(config Config) (*Policy, error) {
+	data := defaultPolicy
+	if config.PolicyFile != "" {
+		var err error
+		if data, err = os.ReadFile(config.PolicyFile); err != nil {
+			return nil, err
+		}
+	}
+
+	policy := &Policy{}
+	if err := yaml.Unmarshal(data, policy); err != nil {
+		return nil, fmt.Errorf("invalid rbac policy: %w", err)
+	}
+	for name, role := range policy.Roles {
+		for _, operation := range role.Operations {
+			if operation != "*" && !api.IsOperationId(operation) {
+				return nil, fmt.Errorf("invalid rbac policy: role %v permits unknown operation %v", name, operation)
+			}
+		}
+	}
+
+	source := config.PolicyFile
+	if source == "" {
+		source = "embedded default policy"
+	}
+	slog.Info("RBAC policy loaded", "roles", len(policy.Roles), "source", source)
+	return policy, nil
+}
+
+// Middleware rejects the requests of identities without a role permitting the operation of the route
+func (this *Policy) Middleware() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		identity, ok := auth.GetIdentity(ctx)
+		if !ok {
+			problem.Abort(ctx, problem.Unauthorized("Caller is not authenticated"))
+			return
+		}
+
+		operation, ok := api.OperationId(ctx.Request.Method, ctx.FullPath())
+		if !ok || !this.Permits(identity.Roles, operation) {
+			problem.Abort(ctx, problem.Forbidden("Operation is not permitted"))
+			return
+		}
+
+		ctx.Set(policyKey, this)
+		ctx.Next()
+	}
+}
+
+func (this *Policy) Permits(roles []string, operation string) bool {
+	for _, name := range roles {
+		for _, permitted := range this.Roles[name].Operations {
+			if permitted == "*" || permitted == operation {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// HiddenFields returns the sensitive fields of the schema the roles may not see
+func (this *Policy) HiddenFields(roles []string, schema string) []string {
+	var hidden []string
+	for _, field := range this.Sensitive[schema] {
+		if !this.permitsField(roles, schema+"."+field) {
+			hidden = append(hidden, field)
+		}
+	}
+	return hidden
+}
+
+func (this *Policy) permitsField(roles []string, field string) bool {
+	for _, name := range roles {
+		for _, permitted := range this.Roles[name].Fields {
+			if permitted == "*" || permitted == field || strings.HasPrefix(field, permitted+".") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// HiddenFields returns the sensitive fields of the schema the caller of the request may not see,
+// nothing is hidden if the access control is disabled
+func HiddenFields(ctx context.Context, schema string) []string {
+	policy, ok := ctx.Value(policyKey).(*Policy)
+	if !ok {
+		return nil
+	}
+	identity, ok := auth.GetIdentity(ctx)
+	if !ok {
+		return nil
+	}
+	return policy.HiddenFields(identity.Roles, schema)
+}
+
+// Redact removes the sensitive fields of the schema the caller may not see from the value,
+// which is either a single object or a list of objects
+func Redact(ctx context.Context, schema string, value interface{}) interface{} {
+	hidden := HiddenFields(ctx, schema)
+	if len(hidden) == 0 {
+		return value
+	}
+
+	// never fall back to the unredacted value
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil
+	}
+	var generic interface{}
+	if err := json.Unmarshal(data, &generic); err != nil {
+		return nil
+	}
+
+	switch generic := generic.(type) {
+	case map[string]interface{}:
+		removeFields(generic, hidden)
+	case []interface{}:
+		for _, item := range generic {
+			if object, ok := item.(map[string]interface{}); ok {
+				removeFields(object, hidden)
+			}
+		}
+	}
+	return generic
+}
+
+func removeFields(object map[string]interface{}, fields []string) {
+	for _, field := range fields {
+		path := strings.Split(field, ".")
+		current := object
+		for _, key := range path[:len(path)-1] {
+			inner, ok := current[key].(map[string]interface{})
+			if !ok {
+				current = nil
+				break
+			}
+			current = inner
+		}
+		if current != nil {
+			delete(current, path[len(path)-1])
+		}
+	}
+}
+
+// RestoreHidden copies the sensitive fields the caller may not see from the existing document to the updated one,
+// so that a caller cannot overwrite the values redacted from its view. Both arguments are pointers to the same type.
+func RestoreHidden(ctx context.Context, schema string, existing interface{}, updated interface{}) error {
+	hidden := HiddenFields(ctx, schema)
+	if len(hidden) == 0 {
+		return nil
+	}
+
+	var existingFields, updatedFields map[string]interface{}
+	for _, item := range []struct {
+		value  interface{}
+		fields *map[string]interface{}
+	}{{existing, &existingFields}, {updated, &updatedFields}} {
+		data, err := json.Marshal(item.value)
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(data, item.fields); err != nil {
+			return err
+		}
+	}
+
+	removeFields(updatedFields, hidden)
+	for _, field := range hidden {
+		path := strings.Split(field, ".")
+		source, target := existingFields, updatedFields
+		for _, key := range path[:len(path)-1] {
+			inner, ok := source[key].(map[string]interface{})
+			if !ok {
+				source = nil
+				break
+			}
+			source = inner
+			if _, ok := target[key].(map[string]interface{}); !ok {
+				target[key] = map[string]interface{}{}
+			}
+			target = target[key].(map[string]interface{})
+		}
+		if value, ok := source[path[len(path)-1]]; ok {
+			target[path[len(path)-1]] = value
+		}
+	}
+
+	data, err := json.Marshal(updatedFields)
+	if err != nil {
+		return err
+	}
+	target := reflect.ValueOf(updated).Elem()
+	target.Set(reflect.Zero(target.Type()))
+	return json.Unmarshal(data, updated)
+}
@@ -0,0 +1,108 @@
+package rbac
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/Marek-FIIT/sprava-krvi-webapi/internal/auth"
+	"github.com/gin-gonic/gin"
+)
+
+// callerContext authorizes the caller with the roles as the middlewares of the api do
+func callerContext(t *testing.T, roles ...string) context.Context {
+	policy, err := NewPolicy(Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := &gin.Context{}
+	// the key of auth.GetIdentity
+	ctx.Set("identity", &auth.Identity{Subject: "tester", Roles: roles})
+	ctx.Set(policyKey, policy)
+	return ctx
+}
+
+func donor() map[string]interface{} {
+	return map[string]interface{}{
+		"id":           "1",
+		"first_name":   "Peter",
+		"birth_number": "9908121367",
+		"email":        "peter@example.com",
+		"diseases":     []interface{}{"HIV"},
+	}
+}
+
+func TestRedact(t *testing.T) {
+	tests := []struct {
+		name   string
+		roles  []string
+		schema string
+		value  interface{}
+		want   interface{}
+	}{
+		{
+			name: "admin sees everything", roles: []string{"admin"}, schema: "Donor",
+			value: donor(), want: donor(),
+		},
+		{
+			name: "reception does not see the diseases", roles: []string{"reception"}, schema: "Donor",
+			value: donor(),
+			want:  map[string]interface{}{"id": "1", "first_name": "Peter", "birth_number": "9908121367", "email": "peter@example.com"},
+		},
+		{
+			name: "lab does not see the contacts", roles: []string{"lab"}, schema: "Donor",
+			value: donor(),
+			want:  map[string]interface{}{"id": "1", "first_name": "Peter", "diseases": []interface{}{"HIV"}},
+		},
+		{
+			name: "roles are joined", roles: []string{"lab", "reception"}, schema: "Donor",
+			value: donor(), want: donor(),
+		},
+		{
+			name: "every item of a list", roles: []string{"hospital"}, schema: "Donor",
+			value: []interface{}{donor(), donor()},
+			want:  []interface{}{map[string]interface{}{"id": "1", "first_name": "Peter"}, map[string]interface{}{"id": "1", "first_name": "Peter"}},
+		},
+		{
+			name: "nested field", roles: []string{"hospital"}, schema: "Unit",
+			value: map[string]interface{}{"id": "1", "contents": map[string]interface{}{"hemoglobin": 140, "volume": 450}},
+			want:  map[string]interface{}{"id": "1", "contents": map[string]interface{}{"volume": float64(450)}},
+		},
+		{
+			name: "unknown role sees nothing sensitive", roles: []string{"visitor"}, schema: "Donor",
+			value: donor(),
+			want:  map[string]interface{}{"id": "1", "first_name": "Peter"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			redacted := Redact(callerContext(t, test.roles...), test.schema, test.value)
+			if fmt.Sprint(redacted) != fmt.Sprint(test.want) {
+				t.Errorf("redacted to %v, want %v", redacted, test.want)
+			}
+		})
+	}
+}
+
+func TestRedactWithoutAccessControl(t *testing.T) {
+	if redacted := Redact(context.Background(), "Donor", donor()); fmt.Sprint(redacted) != fmt.Sprint(donor()) {
+		t.Errorf("redacted to %v without the policy", redacted)
+	}
+}
+
+func TestRestoreHidden(t *testing.T) {
+	type person struct {
+		Name        string `json:"first_name"`
+		BirthNumber string `json:"birth_number"`
+		Email       string `json:"email"`
+	}
+	existing := &person{Name: "Peter", BirthNumber: "9908121367", Email: "peter@example.com"}
+	updated := &person{Name: "Pavol"}
+	if err := RestoreHidden(callerContext(t, "hospital"), "Donor", existing, updated); err != nil {
+		t.Fatal(err)
+	}
+	want := person{Name: "Pavol", BirthNumber: "9908121367", Email: "peter@example.com"}
+	if *updated != want {
+		t.Errorf("restored to %+v, want %+v", *updated, want)
+	}
+}
@@ -30,9 +30,21 @@ type DonorsAPI interface {
     // GetDonor - Provides the detail of a donor
    GetDonor(ctx *gin.Context)
 
+    // GetDonorDuplicates - Provides the likely duplicate registrations of donors
+   GetDonorDuplicates(ctx *gin.Context)
+
+    // GetDonorHistory - Provides the audit history of a donor
+   GetDonorHistory(ctx *gin.Context)
+
     // GetDonors - Provides the list of blood donors
    GetDonors(ctx *gin.Context)
 
+    // ImportDonors - Imports donors from a spreadsheet
+   ImportDonors(ctx *gin.Context)
+
+    // MergeDonor - Merges a duplicate registration into the donor
+   MergeDonor(ctx *gin.Context)
+
     // UpdateDonor - updates the data of the specified donor
    UpdateDonor(ctx *gin.Context)
 
@@ -51,7 +63,11 @@ func (this *implDonorsAPI) addRoutes(routerGroup *gin.RouterGroup) {
   routerGroup.Handle( http.MethodPost, "/donors", this.CreateDonor)
   routerGroup.Handle( http.MethodDelete, "/donors/:donorId", this.DeleteDonor)
   routerGroup.Handle( http.MethodGet, "/donors/:donorId", this.GetDonor)
+  routerGroup.Handle( http.MethodGet, "/donors/duplicates", this.GetDonorDuplicates)
+  routerGroup.Handle( http.MethodGet, "/donors/:donorId/history", this.GetDonorHistory)
   routerGroup.Handle( http.MethodGet, "/donors", this.GetDonors)
+  routerGroup.Handle( http.MethodPost, "/donors/import", this.ImportDonors)
+  routerGroup.Handle( http.MethodPost, "/donors/:donorId/merge", this.MergeDonor)
   routerGroup.Handle( http.MethodPut, "/donors/:donorId", this.UpdateDonor)
 }
 
@@ -71,11 +87,31 @@ func (this *implDonorsAPI) addRoutes(routerGroup *gin.RouterGroup) {
 //  	ctx.AbortWithStatus(http.StatusNotImplemented)
 // }
 //
+// // GetDonorDuplicates - Provides the likely duplicate registrations of donors
+// func (this *implDonorsAPI) GetDonorDuplicates(ctx *gin.Context) {
+//  	ctx.AbortWithStatus(http.StatusNotImplemented)
+// }
+//
+// // GetDonorHistory - Provides the audit history of a donor
+// func (this *implDonorsAPI) GetDonorHistory(ctx *gin.Context) {
+//  	ctx.AbortWithStatus(http.StatusNotImplemented)
+// }
+//
 // // GetDonors - Provides the list of blood donors
 // func (this *implDonorsAPI) GetDonors(ctx *gin.Context) {
 //  	ctx.AbortWithStatus(http.StatusNotImplemented)
 // }
 //
+// // ImportDonors - Imports donors from a spreadsheet
+// func (this *implDonorsAPI) ImportDonors(ctx *gin.Context) {
+//  	ctx.AbortWithStatus(http.StatusNotImplemented)
+// }
+//
+// // MergeDonor - Merges a duplicate registration into the donor
+// func (this *implDonorsAPI) MergeDonor(ctx *gin.Context) {
+//  	ctx.AbortWithStatus(http.StatusNotImplemented)
+// }
+//
 // // UpdateDonor - updates the data of the specified donor
 // func (this *implDonorsAPI) UpdateDonor(ctx *gin.Context) {
 //  	ctx.AbortWithStatus(http.StatusNotImplemented)
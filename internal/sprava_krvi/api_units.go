@@ -30,6 +30,12 @@ type UnitsAPI interface {
     // GetUnit - Provides the detail of the unit
    GetUnit(ctx *gin.Context)
 
+    // GetUnitEvents - Streams the changes of the units
+   GetUnitEvents(ctx *gin.Context)
+
+    // GetUnitHistory - Provides the audit history of a unit
+   GetUnitHistory(ctx *gin.Context)
+
     // GetUnits - Provides the list of blood units
    GetUnits(ctx *gin.Context)
 
@@ -51,6 +57,8 @@ func (this *implUnitsAPI) addRoutes(routerGroup *gin.RouterGroup) {
   routerGroup.Handle( http.MethodPost, "/units", this.CreateUnits)
   routerGroup.Handle( http.MethodDelete, "/units/:unitId", this.DeleteUnit)
   routerGroup.Handle( http.MethodGet, "/units/:unitId", this.GetUnit)
+  routerGroup.Handle( http.MethodGet, "/units/events", this.GetUnitEvents)
+  routerGroup.Handle( http.MethodGet, "/units/:unitId/history", this.GetUnitHistory)
   routerGroup.Handle( http.MethodGet, "/units", this.GetUnits)
   routerGroup.Handle( http.MethodPut, "/units/:unitId", this.UpdateUnit)
 }
@@ -71,6 +79,16 @@ func (this *implUnitsAPI) addRoutes(routerGroup *gin.RouterGroup) {
 //  	ctx.AbortWithStatus(http.StatusNotImplemented)
 // }
 //
+// // GetUnitEvents - Streams the changes of the units
+// func (this *implUnitsAPI) GetUnitEvents(ctx *gin.Context) {
+//  	ctx.AbortWithStatus(http.StatusNotImplemented)
+// }
+//
+// // GetUnitHistory - Provides the audit history of a unit
+// func (this *implUnitsAPI) GetUnitHistory(ctx *gin.Context) {
+//  	ctx.AbortWithStatus(http.StatusNotImplemented)
+// }
+//
 // // GetUnits - Provides the list of blood units
 // func (this *implUnitsAPI) GetUnits(ctx *gin.Context) {
 //  	ctx.AbortWithStatus(http.StatusNotImplemented)
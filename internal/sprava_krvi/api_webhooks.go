@@ -0,0 +1,102 @@
+/*
+ * Blood management API
+ *
+ * Management of blood donors and blood units
+ *
+ * API version: 1.0.0
+ * Contact: xsykoram3@stuba.sk
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+ package sprava_krvi
+
+import (
+   "net/http"
+
+   "github.com/gin-gonic/gin"
+)
+
+type WebhooksAPI interface {
+
+   // internal registration of api routes
+   addRoutes(routerGroup *gin.RouterGroup)
+
+    // CreateWebhook - Registers a webhook subscription
+   CreateWebhook(ctx *gin.Context)
+
+    // DeleteWebhook - Deletes the webhook subscription
+   DeleteWebhook(ctx *gin.Context)
+
+    // GetWebhook - Provides the webhook subscription
+   GetWebhook(ctx *gin.Context)
+
+    // GetWebhookDeliveries - Provides the webhook deliveries
+   GetWebhookDeliveries(ctx *gin.Context)
+
+    // GetWebhooks - Provides the list of webhook subscriptions
+   GetWebhooks(ctx *gin.Context)
+
+    // RetryWebhookDelivery - Retries the webhook delivery
+   RetryWebhookDelivery(ctx *gin.Context)
+
+    // UpdateWebhook - Updates the webhook subscription
+   UpdateWebhook(ctx *gin.Context)
+
+ }
+
+// partial implementation of WebhooksAPI - all functions must be implemented in add on files
+type implWebhooksAPI struct {
+
+}
+
+func newWebhooksAPI() WebhooksAPI {
+  return &implWebhooksAPI{}
+}
+
+func (this *implWebhooksAPI) addRoutes(routerGroup *gin.RouterGroup) {
+  routerGroup.Handle( http.MethodPost, "/webhooks", this.CreateWebhook)
+  routerGroup.Handle( http.MethodDelete, "/webhooks/:webhookId", this.DeleteWebhook)
+  routerGroup.Handle( http.MethodGet, "/webhooks/:webhookId", this.GetWebhook)
+  routerGroup.Handle( http.MethodGet, "/webhooks/deliveries", this.GetWebhookDeliveries)
+  routerGroup.Handle( http.MethodGet, "/webhooks", this.GetWebhooks)
+  routerGroup.Handle( http.MethodPost, "/webhooks/deliveries/:deliveryId/retry", this.RetryWebhookDelivery)
+  routerGroup.Handle( http.MethodPut, "/webhooks/:webhookId", this.UpdateWebhook)
+}
+
+// Copy following section to separate file, uncomment, and implement accordingly
+// // CreateWebhook - Registers a webhook subscription
+// func (this *implWebhooksAPI) CreateWebhook(ctx *gin.Context) {
+//  	ctx.AbortWithStatus(http.StatusNotImplemented)
+// }
+//
+// // DeleteWebhook - Deletes the webhook subscription
+// func (this *implWebhooksAPI) DeleteWebhook(ctx *gin.Context) {
+//  	ctx.AbortWithStatus(http.StatusNotImplemented)
+// }
+//
+// // GetWebhook - Provides the webhook subscription
+// func (this *implWebhooksAPI) GetWebhook(ctx *gin.Context) {
+//  	ctx.AbortWithStatus(http.StatusNotImplemented)
+// }
+//
+// // GetWebhookDeliveries - Provides the webhook deliveries
+// func (this *implWebhooksAPI) GetWebhookDeliveries(ctx *gin.Context) {
+//  	ctx.AbortWithStatus(http.StatusNotImplemented)
+// }
+//
+// // GetWebhooks - Provides the list of webhook subscriptions
+// func (this *implWebhooksAPI) GetWebhooks(ctx *gin.Context) {
+//  	ctx.AbortWithStatus(http.StatusNotImplemented)
+// }
+//
+// // RetryWebhookDelivery - Retries the webhook delivery
+// func (this *implWebhooksAPI) RetryWebhookDelivery(ctx *gin.Context) {
+//  	ctx.AbortWithStatus(http.StatusNotImplemented)
+// }
+//
+// // UpdateWebhook - Updates the webhook subscription
+// func (this *implWebhooksAPI) UpdateWebhook(ctx *gin.Context) {
+//  	ctx.AbortWithStatus(http.StatusNotImplemented)
+// }
+//
+
@@ -0,0 +1,42 @@
+package sprava_krvi
+
+import (
+	"strings"
+
+	"github.com/Marek-FIIT/sprava-krvi-webapi/internal/db_service"
+)
+
+// toAuditEntries converts the audit trail to the api model,
+// values of the hidden fields are left out so that only the fact of their change is visible
+func toAuditEntries(entries []*db_service.AuditEntry, hidden []string) []*AuditEntry {
+	auditEntries := []*AuditEntry{}
+	for _, entry := range entries {
+		auditEntry := &AuditEntry{
+			Id:         entry.Id,
+			Collection: entry.Collection,
+			DocumentId: entry.DocumentId,
+			Operation:  entry.Operation,
+			Actor:      entry.Actor,
+			RequestId:  entry.RequestId,
+			Reason:     entry.Reason,
+			Timestamp:  entry.Timestamp,
+			Changes:    []AuditChange{},
+		}
+		for _, change := range entry.Changes {
+			auditChange := AuditChange{
+				Field:  change.Field,
+				Before: change.Before,
+				After:  change.After,
+			}
+			for _, field := range hidden {
+				if change.Field == field || strings.HasPrefix(change.Field, field+".") {
+					auditChange.Before = nil
+					auditChange.After = nil
+				}
+			}
+			auditEntry.Changes = append(auditEntry.Changes, auditChange)
+		}
+		auditEntries = append(auditEntries, auditEntry)
+	}
+	return auditEntries
+}
@@ -1,75 +1,127 @@
 package sprava_krvi
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/Marek-FIIT/sprava-krvi-webapi/internal/db_service"
+	"github.com/Marek-FIIT/sprava-krvi-webapi/internal/problem"
+	"github.com/Marek-FIIT/sprava-krvi-webapi/internal/rbac"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 )
 
 func (this *implDonorsAPI) GetDonors(ctx *gin.Context) {
 	// ctx.AbortWithStatus(http.StatusNotImplemented)
-	filters := make(map[string]interface{})
+	query := db_service.NewQuery()
+	var filterErrs []problem.FieldError
 	if bloodType := ctx.Query("bloodType"); bloodType != "" {
-		filters["bloodtype"] = bloodType
+		query = query.Eq("blood_type", bloodType)
 	}
 	if bloodRh := ctx.Query("bloodRh"); bloodRh != "" {
-		filters["bloodrh"] = bloodRh
+		query = query.Eq("blood_rh", bloodRh)
 	}
 	if eligible := ctx.Query("eligible"); eligible != "" {
 		eligibleBool, err := strconv.ParseBool(eligible)
 		if err != nil {
-			ctx.JSON(
-				http.StatusBadRequest,
-				gin.H{
-					"status":  http.StatusBadRequest,
-					"message": "Could not parse filters",
-				},
-			)
-			return
+			filterErrs = append(filterErrs, problem.FieldError{Field: "eligible", In: "query", Message: "has to be a boolean"})
 		}
-		filters["eligible"] = eligibleBool
+		query = query.Eq("eligible", eligibleBool)
 	}
 
-	// log.Printf("filters: %v", filters)
+	// the reception looks the donors up by whatever they remember, regardless of the case and the diacritics
+	insensitive := false
+	if firstName := strings.TrimSpace(ctx.Query("firstName")); firstName != "" {
+		query = query.Prefix("first_name", firstName)
+		insensitive = true
+	}
+	if lastName := strings.TrimSpace(ctx.Query("lastName")); lastName != "" {
+		query = query.Prefix("last_name", lastName)
+		insensitive = true
+	}
+	if postalCode := strings.ReplaceAll(ctx.Query("postalCode"), " ", ""); postalCode != "" {
+		query = query.Prefix("postal_code", postalCode)
+		insensitive = true
+	}
+	if email := strings.TrimSpace(ctx.Query("email")); email != "" {
+		query = query.Eq("email", email)
+		insensitive = true
+	}
+	if insensitive {
+		query = query.Insensitive(searchLocale)
+	}
+	if name := strings.TrimSpace(ctx.Query("name")); name != "" {
+		// the text search cannot use the insensitive collation
+		if insensitive {
+			filterErrs = append(filterErrs, problem.FieldError{Field: "name", In: "query", Message: "cannot be combined with firstName, lastName, postalCode or email"})
+		}
+		query = query.Search(name)
+	}
+	if phone := strings.ReplaceAll(ctx.Query("phone"), " ", ""); phone != "" {
+		query = query.Eq("phone_number", phone)
+	}
+	if birthNumber := normalizeBirthNumber(ctx.Query("birthNumber")); birthNumber != "" {
+		query = query.Eq("birth_number", birthNumber)
+	}
+	if before := ctx.Query("lastDonationBefore"); before != "" {
+		beforeTime, err := parseTimeFilter(before)
+		if err != nil {
+			filterErrs = append(filterErrs, problem.FieldError{Field: "lastDonationBefore", In: "query", Message: err.Error()})
+		}
+		query = query.Lt("last_donation", beforeTime)
+	}
+	if after := ctx.Query("lastDonationAfter"); after != "" {
+		afterTime, err := parseTimeFilter(after)
+		if err != nil {
+			filterErrs = append(filterErrs, problem.FieldError{Field: "lastDonationAfter", In: "query", Message: err.Error()})
+		}
+		query = query.Gte("last_donation", afterTime)
+	}
+	if len(filterErrs) > 0 {
+		problem.Abort(ctx, problem.BadRequest("Could not parse filters", filterErrs...))
+		return
+	}
+
+	// filtering by a hidden field would reveal its value
+	for _, hidden := range rbac.HiddenFields(ctx, "Donor") {
+		for _, condition := range query.Conditions {
+			if condition.Field == hidden {
+				problem.Abort(ctx, problem.Forbidden(fmt.Sprintf("Filtering by %v is not permitted", hidden)))
+				return
+			}
+		}
+	}
+
+	export, exportProblem := newExport(ctx, "donors", "Donor", donorExportColumns)
+	if exportProblem != nil {
+		problem.Abort(ctx, exportProblem)
+		return
+	}
 
 	db, err := db_service.GetDbService[Donor](ctx, "db_service_donors")
 	if err != nil {
-		ctx.JSON(
-			http.StatusInternalServerError,
-			gin.H{
-				"status":  "Internal Server Error",
-				"message": "failed to access db_service",
-				"error":   err.Error(),
-			})
+		problem.Abort(ctx, problem.Internal("Failed to access db_service", err))
 		return
 	}
 
-	donors, err := db.FindDocuments(ctx, filters)
+	if export != nil {
+		export.write(ctx, db, query)
+		return
+	}
+
+	donors, err := db.FindDocuments(ctx, query)
 	switch err {
 	case nil:
 		// pass
 	case db_service.ErrNotFound:
-		ctx.JSON(
-			http.StatusNotFound,
-			gin.H{
-				"status":  "Not Found",
-				"message": "Donor not found",
-				"error":   err.Error(),
-			},
-		)
+		problem.Abort(ctx, problem.NotFound("Donor not found"))
 		return
 	default:
-		ctx.JSON(
-			http.StatusBadGateway,
-			gin.H{
-				"status":  "Bad Gateway",
-				"message": "Failed to load donor from database",
-				"error":   err.Error(),
-			})
+		problem.Abort(ctx, problem.FromError(err, "Failed to load donor from database"))
 		return
 	}
 
@@ -97,25 +149,13 @@ func (this *implDonorsAPI) GetDonor(ctx *gin.Context) {
 	// ctx.AbortWithStatus(http.StatusNotImplemented)
 	donorId := ctx.Param("donorId")
 	if donorId == "" {
-		ctx.JSON(
-			http.StatusBadRequest,
-			gin.H{
-				"status":  http.StatusBadRequest,
-				"message": "Donor ID is required",
-			},
-		)
+		problem.Abort(ctx, problem.BadRequest("Donor ID is required"))
 		return
 	}
 
 	db, err := db_service.GetDbService[Donor](ctx, "db_service_donors")
 	if err != nil {
-		ctx.JSON(
-			http.StatusInternalServerError,
-			gin.H{
-				"status":  "Internal Server Error",
-				"message": "failed to access db_service",
-				"error":   err.Error(),
-			})
+		problem.Abort(ctx, problem.Internal("Failed to access db_service", err))
 		return
 	}
 
@@ -125,55 +165,77 @@ func (this *implDonorsAPI) GetDonor(ctx *gin.Context) {
 	case nil:
 		ctx.JSON(
 			http.StatusOK,
-			donor,
+			rbac.Redact(ctx, "Donor", donor),
 		)
 	case db_service.ErrNotFound:
-		ctx.JSON(
-			http.StatusNotFound,
-			gin.H{
-				"status":  "Not Found",
-				"message": "Donor not found",
-				"error":   err.Error(),
-			},
-		)
+		problem.Abort(ctx, problem.NotFound("Donor not found"))
 		return
 	default:
-		ctx.JSON(
-			http.StatusBadGateway,
-			gin.H{
-				"status":  "Bad Gateway",
-				"message": "Failed to load donor from database",
-				"error":   err.Error(),
-			})
+		problem.Abort(ctx, problem.FromError(err, "Failed to load donor from database"))
 		return
 	}
 }
 
+func (this *implDonorsAPI) GetDonorHistory(ctx *gin.Context) {
+	donorId := ctx.Param("donorId")
+	if donorId == "" {
+		problem.Abort(ctx, problem.BadRequest("Donor ID is required"))
+		return
+	}
+
+	trail, err := db_service.GetAuditTrail[Donor](ctx, "db_service_donors")
+	if err != nil {
+		problem.Abort(ctx, problem.Internal("Failed to access db_service", err))
+		return
+	}
+
+	if sAt := ctx.Query("at"); sAt != "" {
+		at, err := time.Parse(time.RFC3339, sAt)
+		if err != nil {
+			problem.Abort(ctx, problem.BadRequest("Invalid time parameter", problem.FieldError{Field: "at", In: "query", Message: "has to be a RFC 3339 date-time"}))
+			return
+		}
+
+		donor, err := trail.DocumentAt(ctx, donorId, at)
+		switch err {
+		case nil:
+			ctx.JSON(
+				http.StatusOK,
+				rbac.Redact(ctx, "Donor", donor),
+			)
+		case db_service.ErrNotFound:
+			problem.Abort(ctx, problem.NotFound("Donor did not exist at the requested time"))
+		default:
+			problem.Abort(ctx, problem.FromError(err, "Failed to reconstruct the donor from the audit trail"))
+		}
+		return
+	}
+
+	entries, err := trail.History(ctx, donorId)
+	if err != nil {
+		problem.Abort(ctx, problem.FromError(err, "Failed to load the donor history from database"))
+		return
+	}
+
+	ctx.JSON(
+		http.StatusOK,
+		toAuditEntries(entries, rbac.HiddenFields(ctx, "Donor")),
+	)
+}
+
 func (this *implDonorsAPI) CreateDonor(ctx *gin.Context) {
 	// ctx.AbortWithStatus(http.StatusNotImplemented)
 	var donor Donor
 
 	if err := ctx.ShouldBindJSON(&donor); err != nil {
-		ctx.JSON(
-			http.StatusBadRequest,
-			gin.H{
-				"status":  http.StatusBadRequest,
-				"message": "Invalid request body",
-				"error":   err.Error(),
-			},
-		)
+		problem.Abort(ctx, problem.FromBindError(err))
 		return
 	}
+	donor.BirthNumber = normalizeBirthNumber(donor.BirthNumber)
 
 	db, err := db_service.GetDbService[Donor](ctx, "db_service_donors")
 	if err != nil {
-		ctx.JSON(
-			http.StatusInternalServerError,
-			gin.H{
-				"status":  "Internal Server Error",
-				"message": "failed to access db_service",
-				"error":   err.Error(),
-			})
+		problem.Abort(ctx, problem.Internal("Failed to access db_service", err))
 		return
 	}
 
@@ -185,79 +247,41 @@ func (this *implDonorsAPI) CreateDonor(ctx *gin.Context) {
 	}
 
 	err = db.CreateDocument(ctx, donor.Id, &donor)
-	switch err {
-	case nil:
+	switch {
+	case err == nil:
 		ctx.JSON(
 			http.StatusCreated,
-			donor,
-		)
-	case db_service.ErrConflict:
-		ctx.JSON(
-			http.StatusConflict,
-			gin.H{
-				"status":  "Conflict",
-				"message": "donor already exists",
-				"error":   err.Error(),
-			},
+			rbac.Redact(ctx, "Donor", donor),
 		)
+	case errors.Is(err, db_service.ErrConflict):
+		problem.Abort(ctx, problem.Conflict("donor already exists"))
 	default:
-		ctx.JSON(
-			http.StatusBadGateway,
-			gin.H{
-				"status":  "Bad Gateway",
-				"message": "Failed to create donor in database",
-				"error":   err.Error(),
-			},
-		)
+		problem.Abort(ctx, problem.FromError(err, "Failed to create donor in database"))
 	}
 }
 
 func (this *implDonorsAPI) UpdateDonor(ctx *gin.Context) {
 	donorId := ctx.Param("donorId")
 	if donorId == "" {
-		ctx.JSON(
-			http.StatusBadRequest,
-			gin.H{
-				"status":  http.StatusBadRequest,
-				"message": "Donor ID is required",
-			},
-		)
+		problem.Abort(ctx, problem.BadRequest("Donor ID is required"))
 		return
 	}
 
 	var donor Donor
 	if err := ctx.ShouldBindJSON(&donor); err != nil {
-		ctx.JSON(
-			http.StatusBadRequest,
-			gin.H{
-				"status":  http.StatusBadRequest,
-				"message": "Invalid request body",
-				"error":   err.Error(),
-			},
-		)
+		problem.Abort(ctx, problem.FromBindError(err))
 		return
 	}
+	donor.BirthNumber = normalizeBirthNumber(donor.BirthNumber)
 
 	if donor.Id != "" && donorId != donor.Id {
-		ctx.JSON(
-			http.StatusBadRequest,
-			gin.H{
-				"status":  http.StatusBadRequest,
-				"message": "Id missmatch (body vs query)",
-			},
-		)
+		problem.Abort(ctx, problem.BadRequest("Id mismatch (body vs path)", problem.FieldError{Field: "id", In: "body", Message: "does not match the donorId path parameter"}))
 		return
 	}
 
 	db, err := db_service.GetDbService[Donor](ctx, "db_service_donors")
 	if err != nil {
-		ctx.JSON(
-			http.StatusInternalServerError,
-			gin.H{
-				"status":  "Internal Server Error",
-				"message": "failed to access db_service",
-				"error":   err.Error(),
-			})
+		problem.Abort(ctx, problem.Internal("Failed to access db_service", err))
 		return
 	}
 
@@ -266,78 +290,42 @@ func (this *implDonorsAPI) UpdateDonor(ctx *gin.Context) {
 	case nil:
 		//pass
 	case db_service.ErrNotFound:
-		ctx.JSON(
-			http.StatusNotFound,
-			gin.H{
-				"status":  "Not Found",
-				"message": "Donor not found",
-				"error":   err.Error(),
-			},
-		)
+		problem.Abort(ctx, problem.NotFound("Donor not found"))
 		return
 	default:
-		ctx.JSON(
-			http.StatusBadGateway,
-			gin.H{
-				"status":  "Bad Gateway",
-				"message": "Failed to retrieve the existing donor from the database",
-				"error":   err.Error(),
-			},
-		)
+		problem.Abort(ctx, problem.FromError(err, "Failed to retrieve the existing donor from the database"))
 		return
 	}
 	donor.Id = existing_donor.Id
 	donor.CreatedAt = existing_donor.CreatedAt
+	if err := rbac.RestoreHidden(ctx, "Donor", existing_donor, &donor); err != nil {
+		problem.Abort(ctx, problem.Internal("Failed to preserve the fields hidden from the caller", err))
+		return
+	}
 	donor.UpdatedAt = time.Now()
 	err = db.UpdateDocument(ctx, donorId, &donor)
 	switch err {
 	case nil:
-		ctx.JSON(http.StatusOK, donor)
+		ctx.JSON(http.StatusOK, rbac.Redact(ctx, "Donor", donor))
 		return
 	case db_service.ErrNotFound:
-		ctx.JSON(
-			http.StatusNotFound,
-			gin.H{
-				"status":  "Not Found",
-				"message": "Donor was deleted while processing the request",
-				"error":   err.Error(),
-			},
-		)
+		problem.Abort(ctx, problem.NotFound("Donor was deleted while processing the request"))
 		return
 	default:
-		ctx.JSON(
-			http.StatusBadGateway,
-			gin.H{
-				"status":  "Bad Gateway",
-				"message": "Failed to update the donor in the database",
-				"error":   err.Error(),
-			},
-		)
+		problem.Abort(ctx, problem.FromError(err, "Failed to update the donor in the database"))
 		return
 	}
 }
 func (this *implDonorsAPI) DeleteDonor(ctx *gin.Context) {
 	donorId := ctx.Param("donorId")
 	if donorId == "" {
-		ctx.JSON(
-			http.StatusBadRequest,
-			gin.H{
-				"status":  http.StatusBadRequest,
-				"message": "Donor ID is required",
-			},
-		)
+		problem.Abort(ctx, problem.BadRequest("Donor ID is required"))
 		return
 	}
 
 	db, err := db_service.GetDbService[Donor](ctx, "db_service_donors")
 	if err != nil {
-		ctx.JSON(
-			http.StatusInternalServerError,
-			gin.H{
-				"status":  "Internal Server Error",
-				"message": "failed to access db_service",
-				"error":   err.Error(),
-			})
+		problem.Abort(ctx, problem.Internal("Failed to access db_service", err))
 		return
 	}
 
@@ -347,24 +335,27 @@ func (this *implDonorsAPI) DeleteDonor(ctx *gin.Context) {
 		ctx.JSON(http.StatusNoContent, struct{}{})
 		return
 	case db_service.ErrNotFound:
-		ctx.JSON(
-			http.StatusNotFound,
-			gin.H{
-				"status":  "Not Found",
-				"message": "Donor was deleted while processing the request",
-				"error":   err.Error(),
-			},
-		)
+		problem.Abort(ctx, problem.NotFound("Donor was deleted while processing the request"))
 		return
 	default:
-		ctx.JSON(
-			http.StatusBadGateway,
-			gin.H{
-				"status":  "Bad Gateway",
-				"message": "Failed to delete the donor from the database",
-				"error":   err.Error(),
-			},
-		)
+		problem.Abort(ctx, problem.FromError(err, "Failed to delete the donor from the database"))
 		return
 	}
 }
+
+// parseTimeFilter accepts either the date-time or the date, which is taken as its midnight in UTC
+func parseTimeFilter(value string) (time.Time, error) {
+	if parsed, err := time.Parse(time.RFC3339, value); err == nil {
+		return parsed, nil
+	}
+	parsed, err := time.Parse(time.DateOnly, value)
+	if err != nil {
+		return time.Time{}, errors.New("has to be a date-time or a date")
+	}
+	return parsed, nil
+}
+
+// normalizeBirthNumber - the birth numbers are stored and looked up without the slash, so that they match however written
+func normalizeBirthNumber(birthNumber string) string {
+	return strings.NewReplacer("/", "", " ", "").Replace(birthNumber)
+}
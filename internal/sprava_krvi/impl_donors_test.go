@@ -0,0 +1,59 @@
+package sprava_krvi
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Marek-FIIT/sprava-krvi-webapi/internal/auth"
+	"github.com/Marek-FIIT/sprava-krvi-webapi/internal/db_service"
+	"github.com/Marek-FIIT/sprava-krvi-webapi/internal/rbac"
+	"github.com/gin-gonic/gin"
+)
+
+func (this *memoryDonors) FindDocuments(ctx context.Context, query db_service.Query) ([]*Donor, error) {
+	var donors []*Donor
+	for _, donor := range this.donors {
+		donors = append(donors, &donor)
+	}
+	return donors, nil
+}
+
+func TestGetDonorsHiddenFilters(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	policy, err := rbac.NewPolicy(rbac.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		role   string
+		query  string
+		status int
+	}{
+		{"reception by email", "reception", "email=peter@example.com", http.StatusOK},
+		{"lab by blood type", "lab", "bloodType=A", http.StatusOK},
+		{"lab by name", "lab", "name=peter", http.StatusOK},
+		{"lab by email", "lab", "email=peter@example.com", http.StatusForbidden},
+		{"lab by phone", "lab", "phone=0901234567", http.StatusForbidden},
+		{"lab by birth number", "lab", "birthNumber=990812/1367", http.StatusForbidden},
+		{"admin by birth number", "admin", "birthNumber=990812/1367", http.StatusOK},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			engine := gin.New()
+			engine.GET("/api/donors", func(ctx *gin.Context) {
+				// the key of auth.GetIdentity
+				ctx.Set("identity", &auth.Identity{Subject: "tester", Roles: []string{test.role}})
+				ctx.Set("db_service_donors", db_service.DbService[Donor](&memoryDonors{donors: map[string]Donor{"1": {Id: "1"}}}))
+			}, policy.Middleware(), (&implDonorsAPI{}).GetDonors)
+
+			recorder := httptest.NewRecorder()
+			engine.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/donors?"+test.query, nil))
+			if recorder.Code != test.status {
+				t.Errorf("got %v %v, want %v", recorder.Code, recorder.Body.String(), test.status)
+			}
+		})
+	}
+}
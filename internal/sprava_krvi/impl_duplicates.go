@@ -0,0 +1,424 @@
+package sprava_krvi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/Marek-FIIT/sprava-krvi-webapi/internal/db_service"
+	"github.com/Marek-FIIT/sprava-krvi-webapi/internal/problem"
+	"github.com/Marek-FIIT/sprava-krvi-webapi/internal/rbac"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+// weights of the matching donor data, the score of a pair is their sum capped at 1
+const (
+	birthNumberWeight = 0.5
+	nameWeight        = 0.3
+	emailWeight       = 0.2
+	phoneWeight       = 0.2
+	postalCodeWeight  = 0.1
+	// names less similar are not considered matching at all
+	minNameSimilarity = 0.85
+)
+
+// GetDonorDuplicates - Provides the likely duplicate registrations of donors
+func (this *implDonorsAPI) GetDonorDuplicates(ctx *gin.Context) {
+	minScore := 0.5
+	limit := 100
+	var filterErrs []problem.FieldError
+	if value := ctx.Query("minScore"); value != "" {
+		parsed, err := strconv.ParseFloat(value, 64)
+		if err != nil || parsed < 0 || parsed > 1 {
+			filterErrs = append(filterErrs, problem.FieldError{Field: "minScore", In: "query", Message: "has to be a number from 0 to 1"})
+		}
+		minScore = parsed
+	}
+	if value := ctx.Query("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 {
+			filterErrs = append(filterErrs, problem.FieldError{Field: "limit", In: "query", Message: "has to be a positive integer"})
+		}
+		limit = parsed
+	}
+	if len(filterErrs) > 0 {
+		problem.Abort(ctx, problem.BadRequest("Could not parse parameters", filterErrs...))
+		return
+	}
+
+	db, err := db_service.GetDbService[Donor](ctx, "db_service_donors")
+	if err != nil {
+		problem.Abort(ctx, problem.Internal("Failed to access db_service", err))
+		return
+	}
+
+	// the donors are streamed into the blocks, only the compared fields are loaded
+	blocks := newDuplicateBlocks()
+	err = db.StreamDocuments(ctx, db_service.NewQuery().Select(
+		"id", "birth_number", "first_name", "last_name", "postal_code", "email", "phone_number",
+		"blood_type", "blood_rh", "eligible", "last_donation",
+	), func(donor *Donor) error {
+		blocks.add(donor)
+		return nil
+	})
+	if err != nil {
+		problem.Abort(ctx, problem.FromError(err, "Failed to load donors from database"))
+		return
+	}
+
+	candidates := blocks.findDuplicates(minScore)
+	if len(candidates) > limit {
+		candidates = candidates[:limit]
+	}
+	ctx.JSON(http.StatusOK, candidates)
+}
+
+// MergeDonor - Merges a duplicate registration into the donor
+func (this *implDonorsAPI) MergeDonor(ctx *gin.Context) {
+	donorId := ctx.Param("donorId")
+	if donorId == "" {
+		problem.Abort(ctx, problem.BadRequest("Donor ID is required"))
+		return
+	}
+
+	var merge DonorMerge
+	if err := ctx.ShouldBindJSON(&merge); err != nil {
+		problem.Abort(ctx, problem.FromBindError(err))
+		return
+	}
+	if merge.DuplicateId == "" || merge.DuplicateId == donorId {
+		problem.Abort(ctx, problem.BadRequest("Invalid duplicate", problem.FieldError{Field: "duplicate_id", In: "body", Message: "has to be the id of another donor"}))
+		return
+	}
+
+	dbDonor, err := db_service.GetDbService[Donor](ctx, "db_service_donors")
+	if err != nil {
+		problem.Abort(ctx, problem.Internal("Failed to access db_service", err))
+		return
+	}
+	dbUnit, err := db_service.GetDbService[Unit](ctx, "db_service_units")
+	if err != nil {
+		problem.Abort(ctx, problem.Internal("Failed to access db_service", err))
+		return
+	}
+
+	// every audit entry of the merge explains why the documents changed
+	ctx.Set(db_service.AuditReasonKey, fmt.Sprintf("merge of donor %v into %v", merge.DuplicateId, donorId))
+
+	var merged *Donor
+	err = dbDonor.WithTransaction(ctx, func(txCtx context.Context) error {
+		survivor, err := dbDonor.FindDocument(txCtx, donorId)
+		if errors.Is(err, db_service.ErrNotFound) {
+			return problem.NotFound("Donor not found")
+		} else if err != nil {
+			return err
+		}
+		duplicate, err := dbDonor.FindDocument(txCtx, merge.DuplicateId)
+		if errors.Is(err, db_service.ErrNotFound) {
+			return problem.NotFound("Duplicate donor not found")
+		} else if err != nil {
+			return err
+		}
+
+		// the units keep their donation ids, the donations are identified by them
+		units, err := dbUnit.FindDocuments(txCtx, db_service.NewQuery().Eq("donor_id", duplicate.Id))
+		if err != nil {
+			return err
+		}
+		for _, unit := range units {
+			unit.DonorId = survivor.Id
+			unit.UpdatedAt = time.Now()
+			if err := dbUnit.UpdateDocument(txCtx, unit.Id, unit); err != nil {
+				return err
+			}
+		}
+
+		// the survivor is saved before the duplicate is deleted, so that without the transactions a failure
+		// leaves both donors rather than losing the merged data. The unique birth number taken over
+		// from the duplicate can be saved only after its deletion, the audit trail keeps it meanwhile.
+		ownBirthNumber := survivor.BirthNumber
+		mergeDonors(survivor, duplicate)
+		takenBirthNumber := survivor.BirthNumber
+		survivor.BirthNumber = ownBirthNumber
+		if err := dbDonor.UpdateDocument(txCtx, survivor.Id, survivor); err != nil {
+			return err
+		}
+		if err := dbDonor.DeleteDocument(txCtx, duplicate.Id); err != nil {
+			return err
+		}
+		if takenBirthNumber != ownBirthNumber {
+			survivor.BirthNumber = takenBirthNumber
+			if err := dbDonor.UpdateDocument(txCtx, survivor.Id, survivor); err != nil {
+				return err
+			}
+		}
+		merged = survivor
+		return nil
+	})
+	if err != nil {
+		problem.Abort(ctx, problem.FromError(err, "Failed to merge the donors"))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, rbac.Redact(ctx, "Donor", merged))
+}
+
+// mergeDonors fills in the data missing in the survivor from the duplicate and joins their lists
+func mergeDonors(survivor *Donor, duplicate *Donor) {
+	for _, field := range []struct{ survivor, duplicate *string }{
+		{&survivor.BirthNumber, &duplicate.BirthNumber},
+		{&survivor.PostalCode, &duplicate.PostalCode},
+		{&survivor.BloodType, &duplicate.BloodType},
+		{&survivor.BloodRh, &duplicate.BloodRh},
+		{&survivor.Email, &duplicate.Email},
+		{&survivor.PhoneNumber, &duplicate.PhoneNumber},
+	} {
+		if *field.survivor == "" {
+			*field.survivor = *field.duplicate
+		}
+	}
+
+	survivor.Diseases = union(survivor.Diseases, duplicate.Diseases)
+	survivor.Medications = union(survivor.Medications, duplicate.Medications)
+	survivor.Substances = union(survivor.Substances, duplicate.Substances)
+	// a reason of the ineligibility recorded with either registration still applies
+	survivor.Eligible = survivor.Eligible && duplicate.Eligible
+	if duplicate.LastDonation.After(survivor.LastDonation) {
+		survivor.LastDonation = duplicate.LastDonation
+	}
+	if !duplicate.CreatedAt.IsZero() && (survivor.CreatedAt.IsZero() || duplicate.CreatedAt.Before(survivor.CreatedAt)) {
+		survivor.CreatedAt = duplicate.CreatedAt
+	}
+	survivor.UpdatedAt = time.Now()
+}
+
+func union(values []string, others []string) []string {
+	seen := make(map[string]bool)
+	var result []string
+	for _, value := range append(append([]string{}, values...), others...) {
+		key := normalizeText(value)
+		if !seen[key] {
+			seen[key] = true
+			result = append(result, value)
+		}
+	}
+	return result
+}
+
+// donors of a block above this are not paired, a block so large is not selective enough to hold duplicates
+const maxBlockSize = 200
+
+// duplicateBlocks - donors grouped by the keys the duplicates likely share, only the donors of the same
+// block are compared, so that the comparisons grow with the sizes of the blocks rather than with all pairs
+type duplicateBlocks struct {
+	donors []*Donor
+	blocks map[string][]int
+}
+
+func newDuplicateBlocks() *duplicateBlocks {
+	return &duplicateBlocks{blocks: make(map[string][]int)}
+}
+
+func (this *duplicateBlocks) add(donor *Donor) {
+	index := len(this.donors)
+	this.donors = append(this.donors, donor)
+	for _, key := range blockKeys(donor) {
+		this.blocks[key] = append(this.blocks[key], index)
+	}
+}
+
+// findDuplicates scores the pairs sharing a block, the blocks are loose enough to catch also the misspelled names
+func (this *duplicateBlocks) findDuplicates(minScore float64) []*DuplicateCandidate {
+	type pair struct{ first, second int }
+	scored := make(map[pair]bool)
+	candidates := []*DuplicateCandidate{}
+	for key, members := range this.blocks {
+		if len(members) > maxBlockSize {
+			slog.Warn("Skipping an oversized block of the duplicate donors", "block", strings.SplitN(key, ":", 2)[0], "donors", len(members))
+			continue
+		}
+		for i := 0; i < len(members); i++ {
+			for j := i + 1; j < len(members); j++ {
+				key := pair{members[i], members[j]}
+				if scored[key] {
+					continue
+				}
+				scored[key] = true
+
+				score, reasons := scoreDuplicate(this.donors[key.first], this.donors[key.second])
+				if score < minScore {
+					continue
+				}
+				candidates = append(candidates, &DuplicateCandidate{
+					Donor:     toDonorListEntry(this.donors[key.first]),
+					Duplicate: toDonorListEntry(this.donors[key.second]),
+					Score:     float32(score),
+					Reasons:   reasons,
+				})
+			}
+		}
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].Score != candidates[j].Score {
+			return candidates[i].Score > candidates[j].Score
+		}
+		return candidates[i].Donor.Id < candidates[j].Donor.Id
+	})
+	return candidates
+}
+
+// blockKeys - the birth number, the contacts, and the name prefixes narrowed by the other name or the postal code
+func blockKeys(donor *Donor) []string {
+	var keys []string
+	if birthNumber := normalizeBirthNumber(donor.BirthNumber); birthNumber != "" {
+		keys = append(keys, "birth_number:"+birthNumber)
+	}
+	if email := strings.ToLower(strings.TrimSpace(donor.Email)); email != "" {
+		keys = append(keys, "email:"+email)
+	}
+	if phone := normalizePhone(donor.PhoneNumber); phone != "" {
+		keys = append(keys, "phone:"+phone)
+	}
+	firstName, lastName := []rune(normalizeText(donor.FirstName)), []rune(normalizeText(donor.LastName))
+	if len(lastName) >= 3 && len(firstName) >= 1 {
+		keys = append(keys, "name:"+string(lastName[:3])+":"+string(firstName[:1]))
+	}
+	// first and last name swapped
+	if len(firstName) >= 3 && len(lastName) >= 1 {
+		keys = append(keys, "name:"+string(firstName[:3])+":"+string(lastName[:1]))
+	}
+	if postalCode := strings.ReplaceAll(donor.PostalCode, " ", ""); postalCode != "" && len(lastName) >= 1 {
+		keys = append(keys, "postal_code:"+postalCode+":"+string(lastName[:1]))
+	}
+	return keys
+}
+
+func scoreDuplicate(donor *Donor, other *Donor) (float64, []string) {
+	score := 0.0
+	reasons := []string{}
+	if birthNumber := normalizeBirthNumber(donor.BirthNumber); birthNumber != "" && birthNumber == normalizeBirthNumber(other.BirthNumber) {
+		score += birthNumberWeight
+		reasons = append(reasons, "birth_number")
+	}
+
+	name := normalizeText(donor.FirstName + " " + donor.LastName)
+	similarity := max(
+		jaroWinkler(name, normalizeText(other.FirstName+" "+other.LastName)),
+		// first and last name swapped
+		jaroWinkler(name, normalizeText(other.LastName+" "+other.FirstName)),
+	)
+	if similarity >= minNameSimilarity {
+		score += nameWeight * similarity
+		reasons = append(reasons, "name")
+	}
+
+	if email := strings.ToLower(strings.TrimSpace(donor.Email)); email != "" && email == strings.ToLower(strings.TrimSpace(other.Email)) {
+		score += emailWeight
+		reasons = append(reasons, "email")
+	}
+	if phone := normalizePhone(donor.PhoneNumber); phone != "" && phone == normalizePhone(other.PhoneNumber) {
+		score += phoneWeight
+		reasons = append(reasons, "phone_number")
+	}
+	if donor.PostalCode != "" && strings.ReplaceAll(donor.PostalCode, " ", "") == strings.ReplaceAll(other.PostalCode, " ", "") {
+		score += postalCodeWeight
+		reasons = append(reasons, "postal_code")
+	}
+	return min(score, 1), reasons
+}
+
+func toDonorListEntry(donor *Donor) DonorListEntry {
+	return DonorListEntry{
+		Id:           donor.Id,
+		FirstName:    donor.FirstName,
+		LastName:     donor.LastName,
+		BloodType:    donor.BloodType,
+		BloodRh:      donor.BloodRh,
+		Eligible:     donor.Eligible,
+		LastDonation: donor.LastDonation,
+	}
+}
+
+// normalizeText lower-cases the text and strips the diacritics, e.g. Šťastný becomes stastny
+func normalizeText(text string) string {
+	stripped, _, err := transform.String(transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC), text)
+	if err != nil {
+		stripped = text
+	}
+	return strings.Join(strings.Fields(strings.ToLower(stripped)), " ")
+}
+
+// normalizePhone keeps the last 9 digits, so that the numbers with and without the country code match
+func normalizePhone(phone string) string {
+	digits := strings.Map(func(r rune) rune {
+		if unicode.IsDigit(r) {
+			return r
+		}
+		return -1
+	}, phone)
+	if len(digits) > 9 {
+		digits = digits[len(digits)-9:]
+	}
+	return digits
+}
+
+// jaroWinkler returns the similarity of the strings from 0 to 1, favouring the common prefix
+func jaroWinkler(a string, b string) float64 {
+	first, second := []rune(a), []rune(b)
+	if len(first) == 0 || len(second) == 0 {
+		return 0
+	}
+
+	window := max(len(first), len(second))/2 - 1
+	window = max(window, 0)
+	firstMatched := make([]bool, len(first))
+	secondMatched := make([]bool, len(second))
+	matches := 0
+	for i := range first {
+		for j := max(0, i-window); j < min(len(second), i+window+1); j++ {
+			if !secondMatched[j] && first[i] == second[j] {
+				firstMatched[i], secondMatched[j] = true, true
+				matches++
+				break
+			}
+		}
+	}
+	if matches == 0 {
+		return 0
+	}
+
+	transpositions := 0
+	j := 0
+	for i := range first {
+		if !firstMatched[i] {
+			continue
+		}
+		for !secondMatched[j] {
+			j++
+		}
+		if first[i] != second[j] {
+			transpositions++
+		}
+		j++
+	}
+
+	m := float64(matches)
+	jaro := (m/float64(len(first)) + m/float64(len(second)) + (m-float64(transpositions)/2)/m) / 3
+
+	prefix := 0
+	for prefix < min(4, len(first), len(second)) && first[prefix] == second[prefix] {
+		prefix++
+	}
+	return jaro + float64(prefix)*0.1*(1-jaro)
+}
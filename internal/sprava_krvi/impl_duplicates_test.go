@@ -0,0 +1,103 @@
+package sprava_krvi
+
+import (
+	"fmt"
+	"math"
+	"testing"
+)
+
+func TestJaroWinkler(t *testing.T) {
+	tests := []struct {
+		a    string
+		b    string
+		want float64
+	}{
+		{"martha", "marhta", 0.961},
+		{"dwayne", "duane", 0.840},
+		{"dixon", "dicksonx", 0.813},
+		{"peter marcin", "peter marcin", 1},
+		{"abc", "xyz", 0},
+		{"", "peter", 0},
+	}
+	for _, test := range tests {
+		if got := jaroWinkler(test.a, test.b); math.Abs(got-test.want) > 0.001 {
+			t.Errorf("jaroWinkler(%q, %q) = %.3f, want %.3f", test.a, test.b, got, test.want)
+		}
+	}
+}
+
+func TestScoreDuplicate(t *testing.T) {
+	peter := &Donor{Id: "1", BirthNumber: "9908121377", FirstName: "Peter", LastName: "Marcin", PostalCode: "83407", PhoneNumber: "+421905734825"}
+	tests := []struct {
+		name    string
+		other   *Donor
+		score   float64
+		reasons string
+	}{
+		{
+			name:    "birth number with the slash and the swapped names",
+			other:   &Donor{BirthNumber: "990812/1377", FirstName: "Marcin", LastName: "Peter"},
+			score:   0.8,
+			reasons: "[birth_number name]",
+		},
+		{
+			name:    "misspelled name and the phone without the country code",
+			other:   &Donor{FirstName: "Petr", LastName: "Marcín", PostalCode: "834 07", PhoneNumber: "0905 734 825"},
+			score:   0.594,
+			reasons: "[name phone_number postal_code]",
+		},
+		{
+			name:    "capped at 1",
+			other:   &Donor{BirthNumber: "9908121377", FirstName: "Peter", LastName: "Marcin", PostalCode: "83407", PhoneNumber: "905734825"},
+			score:   1,
+			reasons: "[birth_number name phone_number postal_code]",
+		},
+		{
+			name:    "different person of the same postal code",
+			other:   &Donor{BirthNumber: "9955120010", FirstName: "Alica", LastName: "Nová", PostalCode: "83407"},
+			score:   0.1,
+			reasons: "[postal_code]",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			score, reasons := scoreDuplicate(peter, test.other)
+			if math.Abs(score-test.score) > 0.001 || fmt.Sprint(reasons) != test.reasons {
+				t.Errorf("scored %.3f %v, want %.3f %v", score, reasons, test.score, test.reasons)
+			}
+		})
+	}
+}
+
+func TestFindDuplicates(t *testing.T) {
+	blocks := newDuplicateBlocks()
+	for _, donor := range []*Donor{
+		{Id: "1", BirthNumber: "9908121377", FirstName: "Peter", LastName: "Marcin", PostalCode: "83407"},
+		{Id: "2", BirthNumber: "990812/1377", FirstName: "Peter", LastName: "Marcin", PostalCode: "83407"},
+		{Id: "3", FirstName: "Petr", LastName: "Marcín", PostalCode: "83407"},
+		{Id: "4", BirthNumber: "9955120010", FirstName: "Alica", LastName: "Nová", PostalCode: "83101"},
+	} {
+		blocks.add(donor)
+	}
+
+	var pairs []string
+	for _, candidate := range blocks.findDuplicates(0.3) {
+		pairs = append(pairs, fmt.Sprintf("%v-%v %.2f", candidate.Donor.Id, candidate.Duplicate.Id, candidate.Score))
+	}
+	// the pairs sharing several blocks are scored once, the best first
+	want := "[1-2 0.90 1-3 0.39 2-3 0.39]"
+	if fmt.Sprint(pairs) != want {
+		t.Errorf("found %v, want %v", pairs, want)
+	}
+}
+
+func TestFindDuplicatesSkipsOversizedBlocks(t *testing.T) {
+	blocks := newDuplicateBlocks()
+	for index := 0; index <= maxBlockSize; index++ {
+		// the same name prefix, different otherwise
+		blocks.add(&Donor{Id: fmt.Sprint(index), FirstName: "Peter", LastName: fmt.Sprintf("Marcin%v", index)})
+	}
+	if candidates := blocks.findDuplicates(0); len(candidates) != 0 {
+		t.Errorf("found %v candidates in the oversized block", len(candidates))
+	}
+}
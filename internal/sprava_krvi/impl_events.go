@@ -0,0 +1,73 @@
+package sprava_krvi
+
+import (
+	"time"
+
+	"github.com/Marek-FIIT/sprava-krvi-webapi/internal/db_service"
+	"github.com/Marek-FIIT/sprava-krvi-webapi/internal/webhooks"
+)
+
+// unitEvent - data of the unit events, without the health data of the donor and the unit
+type unitEvent struct {
+	Id             string    `json:"id"`
+	DonorId        string    `json:"donor_id,omitempty"`
+	DonationId     string    `json:"donation_id,omitempty"`
+	BloodType      string    `json:"blood_type,omitempty"`
+	BloodRh        string    `json:"blood_rh,omitempty"`
+	Status         string    `json:"status"`
+	PreviousStatus string    `json:"previous_status,omitempty"`
+	Location       string    `json:"location,omitempty"`
+	Expiration     time.Time `json:"expiration"`
+}
+
+// donorEvent - data of the donor events, the donor is identified only by the id
+type donorEvent struct {
+	Id               string `json:"id"`
+	Eligible         bool   `json:"eligible"`
+	PreviousEligible bool   `json:"previous_eligible"`
+}
+
+// UnitEvents derives the domain events of the unit change recorded in the outbox,
+// the expiration is also a change of the status
+func UnitEvents(before *Unit, after *Unit) []db_service.DomainEvent {
+	switch {
+	case after == nil:
+		return nil
+	case before == nil:
+		return []db_service.DomainEvent{{Type: webhooks.EventUnitCreated, Data: newUnitEvent(after, "")}}
+	case before.Status == after.Status:
+		return nil
+	}
+
+	events := []db_service.DomainEvent{{Type: webhooks.EventUnitStatusChanged, Data: newUnitEvent(after, before.Status)}}
+	if after.Status == "expired" {
+		events = append(events, db_service.DomainEvent{Type: webhooks.EventUnitExpired, Data: newUnitEvent(after, before.Status)})
+	}
+	return events
+}
+
+// DonorEvents derives the domain events of the donor change recorded in the outbox
+func DonorEvents(before *Donor, after *Donor) []db_service.DomainEvent {
+	if before == nil || after == nil || before.Eligible == after.Eligible {
+		return nil
+	}
+	return []db_service.DomainEvent{{Type: webhooks.EventDonorEligibilityChanged, Data: donorEvent{
+		Id:               after.Id,
+		Eligible:         after.Eligible,
+		PreviousEligible: before.Eligible,
+	}}}
+}
+
+func newUnitEvent(unit *Unit, previousStatus string) unitEvent {
+	return unitEvent{
+		Id:             unit.Id,
+		DonorId:        unit.DonorId,
+		DonationId:     unit.DonationId,
+		BloodType:      unit.BloodType,
+		BloodRh:        unit.BloodRh,
+		Status:         unit.Status,
+		PreviousStatus: previousStatus,
+		Location:       unit.Location,
+		Expiration:     unit.Expiration,
+	}
+}
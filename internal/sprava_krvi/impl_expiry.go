@@ -0,0 +1,82 @@
+package sprava_krvi
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/Marek-FIIT/sprava-krvi-webapi/internal/db_service"
+	"github.com/google/uuid"
+)
+
+// ExpiryLeaseName - name of the lease held by the replica expiring the units, so that the replicas
+// do not record the same expiration more than once
+const ExpiryLeaseName = "unit_expiry"
+
+// the statuses of the units which expire, the contaminated ones are discarded regardless
+var expiringStatuses = []interface{}{"available", "reserved", "unprocessed", "suspended"}
+
+// ExpireUnits sets the status of the units past their expiration to expired every interval until the context is done.
+// The change is recorded as any other, so that the outbox emits the unit.status_changed and the unit.expired events.
+func ExpireUnits(ctx context.Context, units db_service.DbService[Unit], leases db_service.Counters, interval time.Duration) {
+	host, _ := os.Hostname()
+	owner := fmt.Sprintf("%v/%v/%v", host, os.Getpid(), uuid.New().String()[:8])
+	defer func() {
+		// released even though the context is done, so that another replica takes over without waiting for the lease
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := leases.Release(ctx, ExpiryLeaseName, owner); err != nil {
+			slog.Warn("Failed to release the unit expiry lease", "error", err)
+		}
+	}()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		// held longer than the interval, so that the holder keeps it while running
+		held, err := leases.Acquire(ctx, ExpiryLeaseName, owner, 2*interval)
+		if err == nil && held {
+			err = expireUnits(ctx, units, time.Now())
+		}
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to expire the units", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func expireUnits(ctx context.Context, units db_service.DbService[Unit], now time.Time) error {
+	expired, err := units.FindDocuments(ctx, db_service.NewQuery().
+		In("status", expiringStatuses...).
+		Lt("expiration", now))
+	if err != nil {
+		return err
+	}
+
+	// recorded in the audit trail as made by the api itself
+	ctx = context.WithValue(ctx, db_service.AuditActorKey, "system")
+	ctx = context.WithValue(ctx, db_service.AuditReasonKey, "expiration")
+	count := 0
+	for _, unit := range expired {
+		// the units stored without the expiration never expire
+		if unit.Expiration.IsZero() {
+			continue
+		}
+		unit.Status = "expired"
+		unit.UpdatedAt = now
+		if err := units.UpdateDocument(ctx, unit.Id, unit); err != nil {
+			return fmt.Errorf("unit %v: %w", unit.Id, err)
+		}
+		count++
+	}
+	if count > 0 {
+		slog.InfoContext(ctx, "Expired the units past their expiration", "count", count)
+	}
+	return nil
+}
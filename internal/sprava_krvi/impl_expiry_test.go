@@ -0,0 +1,77 @@
+package sprava_krvi
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/Marek-FIIT/sprava-krvi-webapi/internal/db_service"
+)
+
+// expiringUnits - units in memory, filtered by the status and the expiration conditions of the query
+type expiringUnits struct {
+	db_service.DbService[Unit]
+	units   map[string]Unit
+	reasons map[string]string
+}
+
+func (this *expiringUnits) FindDocuments(ctx context.Context, query db_service.Query) ([]*Unit, error) {
+	var found []*Unit
+	for _, unit := range this.units {
+		matches := true
+		for _, condition := range query.Conditions {
+			switch {
+			case condition.Field == "status" && condition.Operator == db_service.OpIn:
+				matches = matches && slices.Contains(condition.Value.([]interface{}), interface{}(unit.Status))
+			case condition.Field == "expiration" && condition.Operator == db_service.OpLt:
+				matches = matches && unit.Expiration.Before(condition.Value.(time.Time))
+			}
+		}
+		if matches {
+			found = append(found, &unit)
+		}
+	}
+	return found, nil
+}
+
+func (this *expiringUnits) UpdateDocument(ctx context.Context, id string, unit *Unit) error {
+	this.units[id] = *unit
+	this.reasons[id], _ = ctx.Value(db_service.AuditReasonKey).(string)
+	return nil
+}
+
+func TestExpireUnits(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		status     string
+		expiration time.Time
+		want       string
+	}{
+		{"available", now.Add(-time.Hour), "expired"},
+		{"reserved", now.Add(-time.Hour), "expired"},
+		{"unprocessed", now.Add(-time.Hour), "expired"},
+		{"suspended", now.Add(-time.Hour), "expired"},
+		{"contaminated", now.Add(-time.Hour), "contaminated"},
+		{"available", now.Add(time.Hour), "available"},
+		{"available", time.Time{}, "available"},
+	}
+	units := &expiringUnits{units: make(map[string]Unit), reasons: make(map[string]string)}
+	for index, test := range tests {
+		id := string(rune('a' + index))
+		units.units[id] = Unit{Id: id, Status: test.status, Expiration: test.expiration}
+	}
+
+	if err := expireUnits(context.Background(), units, now); err != nil {
+		t.Fatal(err)
+	}
+	for index, test := range tests {
+		unit := units.units[string(rune('a'+index))]
+		if unit.Status != test.want {
+			t.Errorf("%v unit expiring at %v is %v, want %v", test.status, test.expiration, unit.Status, test.want)
+		}
+		if unit.Status == "expired" && units.reasons[unit.Id] != "expiration" {
+			t.Errorf("the expiration of the unit is recorded with the reason %q", units.reasons[unit.Id])
+		}
+	}
+}
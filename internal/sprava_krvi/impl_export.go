@@ -0,0 +1,390 @@
+package sprava_krvi
+
+import (
+	"encoding/csv"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Marek-FIIT/sprava-krvi-webapi/internal/db_service"
+	"github.com/Marek-FIIT/sprava-krvi-webapi/internal/problem"
+	"github.com/Marek-FIIT/sprava-krvi-webapi/internal/rbac"
+	"github.com/gin-gonic/gin"
+	"github.com/xuri/excelize/v2"
+)
+
+const (
+	exportJSON = "json"
+	exportCSV  = "csv"
+	exportXLSX = "xlsx"
+)
+
+const (
+	contentTypeCSV  = "text/csv"
+	contentTypeXLSX = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+)
+
+// rows buffered before they are sent to the client
+const exportFlushRows = 500
+
+// the spreadsheets evaluate the text starting with these characters as a formula
+const formulaPrefixes = "=+-@\t\r"
+
+// exportColumn - a column of the exported spreadsheet
+type exportColumn[DocType interface{}] struct {
+	// header of the column and its name in the columns parameter, the stored field of the document
+	name  string
+	value func(document *DocType) interface{}
+	// masks the personal data unless the column is requested explicitly, nil for the other columns
+	mask func(value string) string
+}
+
+var donorExportColumns = []exportColumn[Donor]{
+	{name: "id", value: func(donor *Donor) interface{} { return donor.Id }},
+	{name: "first_name", value: func(donor *Donor) interface{} { return donor.FirstName }, mask: maskInitial},
+	{name: "last_name", value: func(donor *Donor) interface{} { return donor.LastName }, mask: maskInitial},
+	{name: "birth_number", value: func(donor *Donor) interface{} { return donor.BirthNumber }, mask: maskBirthNumber},
+	{name: "postal_code", value: func(donor *Donor) interface{} { return donor.PostalCode }},
+	{name: "blood_type", value: func(donor *Donor) interface{} { return donor.BloodType }},
+	{name: "blood_rh", value: func(donor *Donor) interface{} { return donor.BloodRh }},
+	{name: "eligible", value: func(donor *Donor) interface{} { return donor.Eligible }},
+	{name: "last_donation", value: func(donor *Donor) interface{} { return donor.LastDonation }},
+	{name: "email", value: func(donor *Donor) interface{} { return donor.Email }, mask: maskEmail},
+	{name: "phone_number", value: func(donor *Donor) interface{} { return donor.PhoneNumber }, mask: maskPhone},
+	{name: "diseases", value: func(donor *Donor) interface{} { return donor.Diseases }, mask: maskAll},
+	{name: "medications", value: func(donor *Donor) interface{} { return donor.Medications }, mask: maskAll},
+	{name: "substances", value: func(donor *Donor) interface{} { return donor.Substances }, mask: maskAll},
+	{name: "created_at", value: func(donor *Donor) interface{} { return donor.CreatedAt }},
+	{name: "updated_at", value: func(donor *Donor) interface{} { return donor.UpdatedAt }},
+}
+
+var unitExportColumns = []exportColumn[Unit]{
+	{name: "id", value: func(unit *Unit) interface{} { return unit.Id }},
+	{name: "donor_id", value: func(unit *Unit) interface{} { return unit.DonorId }, mask: maskAll},
+	{name: "donation_id", value: func(unit *Unit) interface{} { return unit.DonationId }},
+	{name: "blood_type", value: func(unit *Unit) interface{} { return unit.BloodType }},
+	{name: "blood_rh", value: func(unit *Unit) interface{} { return unit.BloodRh }},
+	{name: "status", value: func(unit *Unit) interface{} { return unit.Status }},
+	{name: "location", value: func(unit *Unit) interface{} { return unit.Location }},
+	{name: "contents.hemoglobin", value: func(unit *Unit) interface{} { return unit.Contents.Hemoglobin }},
+	{name: "contents.erythrocytes", value: func(unit *Unit) interface{} { return unit.Contents.Erythrocytes }},
+	{name: "contents.leukocytes", value: func(unit *Unit) interface{} { return unit.Contents.Leukocytes }},
+	{name: "contents.platelets", value: func(unit *Unit) interface{} { return unit.Contents.Platelets }},
+	{name: "contents.plasma", value: func(unit *Unit) interface{} { return unit.Contents.Plasma }},
+	{name: "contents.additional", value: func(unit *Unit) interface{} { return unit.Contents.Additional }},
+	{name: "frozen", value: func(unit *Unit) interface{} { return unit.Frozen }},
+	{name: "diseases", value: func(unit *Unit) interface{} { return unit.Diseases }, mask: maskAll},
+	{name: "expiration", value: func(unit *Unit) interface{} { return unit.Expiration }},
+	{name: "created_at", value: func(unit *Unit) interface{} { return unit.CreatedAt }},
+	{name: "updated_at", value: func(unit *Unit) interface{} { return unit.UpdatedAt }},
+}
+
+// export - the list requested as a spreadsheet
+type export[DocType interface{}] struct {
+	// plural of the exported documents, names the file and the sheet
+	name    string
+	format  string
+	columns []exportColumn[DocType]
+	masked  []bool
+}
+
+// newExport reads the requested format and columns, the export is nil when the json list is requested.
+// Without the columns parameter all permitted columns are exported with the personal data masked,
+// the columns listed explicitly are exported as they are. Requesting a column hidden from the caller is forbidden.
+func newExport[DocType interface{}](ctx *gin.Context, name string, schema string, columns []exportColumn[DocType]) (*export[DocType], *problem.Problem) {
+	format := ctx.Query("format")
+	if format == "" {
+		switch ctx.NegotiateFormat(gin.MIMEJSON, contentTypeCSV, contentTypeXLSX) {
+		case contentTypeCSV:
+			format = exportCSV
+		case contentTypeXLSX:
+			format = exportXLSX
+		default:
+			format = exportJSON
+		}
+	}
+	switch format {
+	case exportJSON:
+		return nil, nil
+	case exportCSV, exportXLSX:
+	default:
+		return nil, problem.BadRequest("Unsupported format", problem.FieldError{Field: "format", In: "query", Message: "has to be json, csv or xlsx"})
+	}
+
+	hidden := make(map[string]bool)
+	for _, field := range rbac.HiddenFields(ctx, schema) {
+		hidden[field] = true
+	}
+	result := &export[DocType]{name: name, format: format}
+
+	requested := queryList(ctx, "columns")
+	if len(requested) == 0 {
+		for _, column := range columns {
+			if !hidden[column.name] {
+				result.columns = append(result.columns, column)
+				result.masked = append(result.masked, column.mask != nil)
+			}
+		}
+		return result, nil
+	}
+
+	byName := make(map[string]exportColumn[DocType])
+	for _, column := range columns {
+		byName[column.name] = column
+	}
+	var columnErrs []problem.FieldError
+	for _, value := range requested {
+		column, found := byName[value.(string)]
+		switch {
+		case !found:
+			columnErrs = append(columnErrs, problem.FieldError{Field: "columns", In: "query", Message: fmt.Sprintf("unknown column %v", value)})
+		case hidden[column.name]:
+			return nil, problem.Forbidden(fmt.Sprintf("Exporting %v is not permitted", column.name))
+		default:
+			result.columns = append(result.columns, column)
+			result.masked = append(result.masked, false)
+		}
+	}
+	if len(columnErrs) > 0 {
+		return nil, problem.BadRequest("Could not parse columns", columnErrs...)
+	}
+	return result, nil
+}
+
+// write streams the documents found by the query as the requested spreadsheet
+func (this *export[DocType]) write(ctx *gin.Context, db db_service.DbService[DocType], query db_service.Query) {
+	var fields []string
+	for _, column := range this.columns {
+		fields = append(fields, column.name)
+	}
+	query = query.Select(fields...)
+
+	filename := fmt.Sprintf("%v-%v.%v", this.name, time.Now().Format(time.DateOnly), this.format)
+	ctx.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+
+	var err error
+	switch this.format {
+	case exportCSV:
+		err = this.writeCSV(ctx, db, query)
+	case exportXLSX:
+		err = this.writeXLSX(ctx, db, query)
+	}
+	if err == nil {
+		return
+	}
+	if !ctx.Writer.Written() {
+		ctx.Writer.Header().Del("Content-Disposition")
+		problem.Abort(ctx, problem.FromError(err, fmt.Sprintf("Failed to export the %v", this.name)))
+		return
+	}
+	// the beginning of the file was already sent, closing the connection tells the client it is incomplete
+	slog.ErrorContext(ctx, "Export failed", "error", err, "format", this.format, "name", this.name)
+	panic(http.ErrAbortHandler)
+}
+
+func (this *export[DocType]) writeCSV(ctx *gin.Context, db db_service.DbService[DocType], query db_service.Query) error {
+	ctx.Header("Content-Type", contentTypeCSV+"; charset=utf-8")
+	ctx.Status(http.StatusOK)
+
+	// the header and the first rows stay in the buffer, so that a failed query can still be reported
+	writer := csv.NewWriter(ctx.Writer)
+	header := make([]string, len(this.columns))
+	for i, column := range this.columns {
+		header[i] = column.name
+	}
+	if err := writer.Write(header); err != nil {
+		return err
+	}
+
+	rows := 0
+	err := db.StreamDocuments(ctx, query, func(document *DocType) error {
+		if err := writer.Write(this.row(document)); err != nil {
+			return err
+		}
+		if rows++; rows%exportFlushRows == 0 {
+			writer.Flush()
+			ctx.Writer.Flush()
+		}
+		return writer.Error()
+	})
+	if err != nil {
+		return err
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+func (this *export[DocType]) writeXLSX(ctx *gin.Context, db db_service.DbService[DocType], query db_service.Query) error {
+	file := excelize.NewFile()
+	defer file.Close()
+	if err := file.SetSheetName("Sheet1", this.name); err != nil {
+		return err
+	}
+	// the stream writer moves the rows to a temporary file once they do not fit in its buffer
+	sheet, err := file.NewStreamWriter(this.name)
+	if err != nil {
+		return err
+	}
+	dateFormat := "yyyy-mm-dd hh:mm"
+	dateStyle, err := file.NewStyle(&excelize.Style{CustomNumFmt: &dateFormat})
+	if err != nil {
+		return err
+	}
+
+	header := make([]interface{}, len(this.columns))
+	for i, column := range this.columns {
+		header[i] = column.name
+	}
+	if err := sheet.SetRow("A1", header); err != nil {
+		return err
+	}
+
+	rowIndex := 1
+	err = db.StreamDocuments(ctx, query, func(document *DocType) error {
+		rowIndex++
+		cells := make([]interface{}, len(this.columns))
+		for i, column := range this.columns {
+			value := column.value(document)
+			if this.masked[i] {
+				cells[i] = textCell(column.mask(exportText(value)))
+				continue
+			}
+			// the typed values let the spreadsheet sort and filter them, except the lists
+			switch typed := value.(type) {
+			case time.Time:
+				if !typed.IsZero() {
+					cells[i] = excelize.Cell{StyleID: dateStyle, Value: typed}
+				}
+			case []string:
+				cells[i] = textCell(strings.Join(typed, "; "))
+			case string:
+				cells[i] = textCell(typed)
+			default:
+				cells[i] = value
+			}
+		}
+		cell, err := excelize.CoordinatesToCellName(1, rowIndex)
+		if err != nil {
+			return err
+		}
+		return sheet.SetRow(cell, cells)
+	})
+	if err != nil {
+		return err
+	}
+	if err := sheet.Flush(); err != nil {
+		return err
+	}
+
+	ctx.Header("Content-Type", contentTypeXLSX)
+	ctx.Status(http.StatusOK)
+	return file.Write(ctx.Writer)
+}
+
+func (this *export[DocType]) row(document *DocType) []string {
+	row := make([]string, len(this.columns))
+	for i, column := range this.columns {
+		row[i] = exportText(column.value(document))
+		if this.masked[i] {
+			row[i] = column.mask(row[i])
+		}
+		row[i] = neutralizeFormula(row[i])
+	}
+	return row
+}
+
+// formulaLike tells whether the spreadsheet would evaluate the text, a lone sign as the Rh factor is not a formula
+func formulaLike(text string) bool {
+	return len(text) > 1 && strings.ContainsRune(formulaPrefixes, rune(text[0]))
+}
+
+// neutralizeFormula prefixes the text the spreadsheet would evaluate with the apostrophe, the import removes it
+func neutralizeFormula(text string) string {
+	if formulaLike(text) {
+		return "'" + text
+	}
+	return text
+}
+
+// restoreFormula removes the apostrophe added by neutralizeFormula
+func restoreFormula(text string) string {
+	if strings.HasPrefix(text, "'") && formulaLike(text[1:]) {
+		return text[1:]
+	}
+	return text
+}
+
+// textCell keeps the text the spreadsheet would evaluate in an inline string cell, which is never a formula
+func textCell(text string) interface{} {
+	if formulaLike(text) {
+		return []excelize.RichTextRun{{Text: text}}
+	}
+	return text
+}
+
+// exportText formats the value so that it can be imported back
+func exportText(value interface{}) string {
+	switch typed := value.(type) {
+	case string:
+		return typed
+	case bool:
+		return strconv.FormatBool(typed)
+	case float32:
+		if typed == 0 {
+			return ""
+		}
+		return strconv.FormatFloat(float64(typed), 'f', -1, 32)
+	case time.Time:
+		if typed.IsZero() {
+			return ""
+		}
+		return typed.Format(time.RFC3339)
+	case []string:
+		return strings.Join(typed, "; ")
+	default:
+		return fmt.Sprint(typed)
+	}
+}
+
+func maskAll(value string) string {
+	if value == "" {
+		return ""
+	}
+	return "***"
+}
+
+// maskInitial keeps the first letter, e.g. N***
+func maskInitial(value string) string {
+	for _, initial := range value {
+		return string(initial) + "***"
+	}
+	return ""
+}
+
+// maskBirthNumber keeps the date part, which is enough for the statistics by the age
+func maskBirthNumber(value string) string {
+	if len(value) < 6 {
+		return maskAll(value)
+	}
+	return value[:6] + "/****"
+}
+
+// maskEmail keeps the domain, e.g. j***@example.com
+func maskEmail(value string) string {
+	at := strings.LastIndex(value, "@")
+	if at < 0 {
+		return maskAll(value)
+	}
+	return maskInitial(value[:at]) + value[at:]
+}
+
+// maskPhone keeps the last three digits
+func maskPhone(value string) string {
+	if len(value) <= 3 {
+		return maskAll(value)
+	}
+	return "***" + value[len(value)-3:]
+}
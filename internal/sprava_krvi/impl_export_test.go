@@ -0,0 +1,88 @@
+package sprava_krvi
+
+import (
+	"bytes"
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Marek-FIIT/sprava-krvi-webapi/internal/db_service"
+	"github.com/gin-gonic/gin"
+	"github.com/xuri/excelize/v2"
+)
+
+func (this *memoryDonors) StreamDocuments(ctx context.Context, query db_service.Query, fn func(document *Donor) error) error {
+	for _, donor := range this.donors {
+		if err := fn(&donor); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestExportNeutralizesFormulas(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	donor := Donor{
+		Id:          "1",
+		FirstName:   "=HYPERLINK(\"http://evil.example\")",
+		LastName:    "@SUM(A1)",
+		BirthNumber: "9908121377",
+		PostalCode:  "83407",
+		BloodType:   "A",
+		BloodRh:     "-",
+		PhoneNumber: "+421901234567",
+		Diseases:    []string{"-2+3"},
+	}
+	var columns []exportColumn[Donor]
+	for _, column := range donorExportColumns {
+		switch column.name {
+		case "first_name", "last_name", "birth_number", "postal_code", "blood_rh", "phone_number", "diseases":
+			columns = append(columns, column)
+		}
+	}
+
+	for _, format := range []string{exportCSV, exportXLSX} {
+		t.Run(format, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			ctx, _ := gin.CreateTestContext(recorder)
+			ctx.Request = httptest.NewRequest("GET", "/api/donors", nil)
+			export := &export[Donor]{name: "donors", format: format, columns: columns, masked: make([]bool, len(columns))}
+			export.write(ctx, &memoryDonors{donors: map[string]Donor{"1": donor}}, db_service.NewQuery())
+
+			table, err := readTable(bytes.NewReader(recorder.Body.Bytes()), "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := map[string]string{"first_name": "'" + donor.FirstName, "last_name": "'" + donor.LastName, "blood_rh": "-", "phone_number": "'" + donor.PhoneNumber}
+			if format == exportXLSX {
+				// the inline strings are never evaluated and need no apostrophe
+				want = map[string]string{"first_name": donor.FirstName, "last_name": donor.LastName, "blood_rh": "-", "phone_number": donor.PhoneNumber}
+				workbook, err := excelize.OpenReader(bytes.NewReader(recorder.Body.Bytes()))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if formula, _ := workbook.GetCellFormula("donors", "A2"); formula != "" {
+					t.Errorf("the first name is exported as the formula %v", formula)
+				}
+				workbook.Close()
+			}
+			for index, header := range table[0] {
+				if value, ok := want[header]; ok && table[1][index] != value {
+					t.Errorf("exported %v as %q, want %q", header, table[1][index], value)
+				}
+			}
+
+			// the exported file can be imported back unchanged
+			mapped, err := mapColumns(table, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rows := parseRows(table, mapped)
+			imported := rows[0].donor
+			if len(rows[0].Errors) > 0 || imported.FirstName != donor.FirstName || imported.LastName != donor.LastName ||
+				imported.BloodRh != donor.BloodRh || imported.PhoneNumber != donor.PhoneNumber || imported.Diseases[0] != donor.Diseases[0] {
+				t.Errorf("imported %+v with %v, want %+v", imported, rows[0].Errors, donor)
+			}
+		})
+	}
+}
@@ -0,0 +1,531 @@
+package sprava_krvi
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Marek-FIIT/sprava-krvi-webapi/api"
+	"github.com/Marek-FIIT/sprava-krvi-webapi/internal/db_service"
+	"github.com/Marek-FIIT/sprava-krvi-webapi/internal/problem"
+	"github.com/Marek-FIIT/sprava-krvi-webapi/internal/rbac"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/xuri/excelize/v2"
+)
+
+const (
+	maxImportSize = 10 << 20
+	maxImportRows = 10000
+)
+
+// TransactionsKey - context key of whether the database services run in the transactions, required by the atomic import
+const TransactionsKey = "mongodb_transactions"
+
+const (
+	importDryRun = "dry_run"
+	importCommit = "commit"
+)
+
+const (
+	importCreated   = "created"
+	importUpdated   = "updated"
+	importUnchanged = "unchanged"
+	importFailed    = "failed"
+)
+
+// importSetters parse the cell values into the donor, by the json names of the donor properties
+var importSetters = map[string]func(donor *Donor, value string) error{
+	"birth_number": func(donor *Donor, value string) error {
+		birthNumber, ok := parseBirthNumber(value)
+		if !ok {
+			return errors.New("is not a valid birth number")
+		}
+		donor.BirthNumber = birthNumber
+		return nil
+	},
+	"first_name": func(donor *Donor, value string) error { donor.FirstName = value; return nil },
+	"last_name":  func(donor *Donor, value string) error { donor.LastName = value; return nil },
+	"postal_code": func(donor *Donor, value string) error {
+		donor.PostalCode = strings.ReplaceAll(value, " ", "")
+		return nil
+	},
+	"blood_type": func(donor *Donor, value string) error {
+		value = strings.ToUpper(value)
+		if value == "O" {
+			value = "0"
+		}
+		if value != "AB" && value != "A" && value != "B" && value != "0" {
+			return errors.New("has to be one of AB, A, B or 0")
+		}
+		donor.BloodType = value
+		return nil
+	},
+	"blood_rh": func(donor *Donor, value string) error {
+		if value != "+" && value != "-" {
+			return errors.New("has to be + or -")
+		}
+		donor.BloodRh = value
+		return nil
+	},
+	"eligible": func(donor *Donor, value string) error {
+		switch strings.ToLower(value) {
+		case "true", "1", "yes", "y", "áno", "ano", "a":
+			donor.Eligible = true
+		case "false", "0", "no", "n", "nie":
+			donor.Eligible = false
+		default:
+			return errors.New("has to be a boolean")
+		}
+		return nil
+	},
+	"last_donation": func(donor *Donor, value string) error {
+		parsed, err := parseImportDate(value)
+		donor.LastDonation = parsed
+		return err
+	},
+	"email": func(donor *Donor, value string) error {
+		if !strings.Contains(value, "@") {
+			return errors.New("is not a valid email")
+		}
+		donor.Email = value
+		return nil
+	},
+	"phone_number": func(donor *Donor, value string) error {
+		donor.PhoneNumber = strings.ReplaceAll(value, " ", "")
+		return nil
+	},
+	"diseases":    func(donor *Donor, value string) error { donor.Diseases = splitImportList(value); return nil },
+	"medications": func(donor *Donor, value string) error { donor.Medications = splitImportList(value); return nil },
+	"substances":  func(donor *Donor, value string) error { donor.Substances = splitImportList(value); return nil },
+}
+
+// required for the new donors, the existing ones keep their values
+var importRequired = []string{"birth_number", "first_name", "last_name", "postal_code"}
+
+type importRow struct {
+	DonorImportRow
+	donor *Donor
+	// valid values of the row by the donor properties
+	values map[string]string
+}
+
+// LimitImportSize refuses the imported files above the limit, it has to precede the validation of the requests
+// which reads the whole body
+func LimitImportSize() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if operation, _ := api.OperationId(ctx.Request.Method, ctx.FullPath()); operation != "importDonors" {
+			ctx.Next()
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(ctx.Writer, ctx.Request.Body, maxImportSize))
+		var maxBytesError *http.MaxBytesError
+		switch {
+		case errors.As(err, &maxBytesError):
+			problem.Abort(ctx, problem.New(http.StatusRequestEntityTooLarge, problem.TypeValidation, fmt.Sprintf("The file exceeds %v MiB", maxImportSize>>20)))
+			return
+		case err != nil:
+			problem.Abort(ctx, problem.BadRequest("Could not read the request", problem.FieldError{Field: "file", In: "body", Message: err.Error()}))
+			return
+		}
+		ctx.Request.Body = io.NopCloser(bytes.NewReader(body))
+		ctx.Next()
+	}
+}
+
+// ImportDonors - Imports donors from a spreadsheet
+func (this *implDonorsAPI) ImportDonors(ctx *gin.Context) {
+	mode := ctx.DefaultPostForm("mode", importDryRun)
+	if mode != importDryRun && mode != importCommit {
+		problem.Abort(ctx, problem.BadRequest("Invalid mode", problem.FieldError{Field: "mode", In: "body", Message: "has to be dry_run or commit"}))
+		return
+	}
+	atomic, err := strconv.ParseBool(ctx.DefaultPostForm("atomic", "false"))
+	if err != nil {
+		problem.Abort(ctx, problem.BadRequest("Invalid atomic", problem.FieldError{Field: "atomic", In: "body", Message: "has to be a boolean"}))
+		return
+	}
+	// without the transactions a failure of the database leaves the rows before it stored
+	if atomic && !ctx.GetBool(TransactionsKey) {
+		problem.Abort(ctx, problem.BadRequest("Invalid atomic", problem.FieldError{Field: "atomic", In: "body", Message: "needs the mongodb transactions, which are disabled"}))
+		return
+	}
+
+	file, err := ctx.FormFile("file")
+	if err != nil {
+		problem.Abort(ctx, problem.BadRequest("File is required", problem.FieldError{Field: "file", In: "body", Message: err.Error()}))
+		return
+	}
+
+	mapping := make(map[string]string)
+	if value := ctx.PostForm("mapping"); value != "" {
+		if err := json.Unmarshal([]byte(value), &mapping); err != nil {
+			problem.Abort(ctx, problem.BadRequest("Invalid mapping", problem.FieldError{Field: "mapping", In: "body", Message: "has to be a JSON object of strings"}))
+			return
+		}
+	}
+	for field := range mapping {
+		if _, ok := importSetters[field]; !ok {
+			problem.Abort(ctx, problem.BadRequest("Invalid mapping", problem.FieldError{Field: "mapping", In: "body", Message: fmt.Sprintf("unknown donor property %v", field)}))
+			return
+		}
+	}
+
+	content, err := file.Open()
+	if err != nil {
+		problem.Abort(ctx, problem.BadRequest("Could not read the file", problem.FieldError{Field: "file", In: "body", Message: err.Error()}))
+		return
+	}
+	defer content.Close()
+	table, err := readTable(content, ctx.PostForm("sheet"))
+	if err != nil {
+		problem.Abort(ctx, problem.BadRequest("Could not read the file", problem.FieldError{Field: "file", In: "body", Message: err.Error()}))
+		return
+	}
+	if len(table) > maxImportRows+1 {
+		problem.Abort(ctx, problem.New(http.StatusRequestEntityTooLarge, problem.TypeValidation, fmt.Sprintf("The file exceeds %v rows", maxImportRows)))
+		return
+	}
+
+	columns, err := mapColumns(table, mapping)
+	if err != nil {
+		problem.Abort(ctx, problem.BadRequest("Invalid mapping", problem.FieldError{Field: "mapping", In: "body", Message: err.Error()}))
+		return
+	}
+	// the caller could overwrite the values redacted from its view
+	for _, hidden := range rbac.HiddenFields(ctx, "Donor") {
+		if _, ok := columns[hidden]; ok {
+			problem.Abort(ctx, problem.Forbidden(fmt.Sprintf("Importing %v is not permitted, remove the column %q", hidden, table[0][columns[hidden]])))
+			return
+		}
+	}
+	rows := parseRows(table, columns)
+
+	db, err := db_service.GetDbService[Donor](ctx, "db_service_donors")
+	if err != nil {
+		problem.Abort(ctx, problem.Internal("Failed to access db_service", err))
+		return
+	}
+
+	// the birth number identifies the donors, so that the repeated import updates them
+	var birthNumbers []interface{}
+	for _, row := range rows {
+		if row.Status != importFailed && row.donor.BirthNumber != "" {
+			birthNumbers = append(birthNumbers, row.donor.BirthNumber)
+		}
+	}
+	existing := make(map[string]*Donor)
+	if len(birthNumbers) > 0 {
+		donors, err := db.FindDocuments(ctx, db_service.NewQuery().In("birth_number", birthNumbers...))
+		if err != nil {
+			problem.Abort(ctx, problem.FromError(err, "Failed to load donors from database"))
+			return
+		}
+		for _, donor := range donors {
+			existing[donor.BirthNumber] = donor
+		}
+	}
+
+	ctx.Set(db_service.AuditReasonKey, fmt.Sprintf("import of %v", file.Filename))
+	report := DonorImportReport{Mode: mode, Atomic: atomic, Rows: []DonorImportRow{}}
+	commit := mode == importCommit
+	if commit && atomic {
+		// every row is checked first, so that nothing is stored unless all of them can be
+		commit = importRows(ctx, db, rows, existing, false) == nil
+		if commit {
+			err := db.WithTransaction(ctx, func(txCtx context.Context) error {
+				return importRows(txCtx, db, rows, existing, true)
+			})
+			commit = err == nil
+		}
+	} else {
+		_ = importRows(ctx, db, rows, existing, commit)
+	}
+	report.Committed = commit
+
+	for _, row := range rows {
+		report.Total++
+		switch row.Status {
+		case importCreated:
+			report.Created++
+		case importUpdated:
+			report.Updated++
+		case importUnchanged:
+			report.Unchanged++
+		case importFailed:
+			report.Failed++
+		}
+		report.Rows = append(report.Rows, row.DonorImportRow)
+	}
+
+	ctx.JSON(http.StatusOK, report)
+}
+
+var errImportRowFailed = errors.New("an imported row failed")
+
+// importRows imports the valid rows, it fails once all are done if any of them failed
+func importRows(ctx context.Context, db db_service.DbService[Donor], rows []*importRow, existing map[string]*Donor, commit bool) error {
+	var err error
+	for _, row := range rows {
+		if row.Status != importFailed {
+			importRowInto(ctx, db, row, existing[row.donor.BirthNumber], commit)
+		}
+		if row.Status == importFailed {
+			err = errImportRowFailed
+		}
+	}
+	return err
+}
+
+// importRowInto creates the donor of the row or updates the existing one, in the dry run only the status is set
+func importRowInto(ctx context.Context, db db_service.DbService[Donor], row *importRow, current *Donor, commit bool) {
+	if current == nil {
+		for _, field := range importRequired {
+			if _, ok := row.values[field]; !ok {
+				row.fail(field, "", "is required")
+			}
+		}
+		if row.Status == importFailed {
+			return
+		}
+
+		row.donor.Id = uuid.New().String()
+		row.donor.CreatedAt = time.Now()
+		row.donor.UpdatedAt = row.donor.CreatedAt
+		row.Status = importCreated
+		row.DonorId = row.donor.Id
+		if commit {
+			if err := db.CreateDocument(ctx, row.donor.Id, row.donor); err != nil {
+				row.fail("", "", fmt.Sprintf("failed to create the donor: %v", problem.FromError(err, "").Title))
+			}
+		}
+		return
+	}
+
+	updated := *current
+	for field, value := range row.values {
+		// the values were validated while parsing
+		_ = importSetters[field](&updated, value)
+	}
+	row.DonorId = current.Id
+	if reflect.DeepEqual(&updated, current) {
+		row.Status = importUnchanged
+		return
+	}
+
+	updated.UpdatedAt = time.Now()
+	row.Status = importUpdated
+	if commit {
+		if err := db.UpdateDocument(ctx, current.Id, &updated); err != nil {
+			row.fail("", "", fmt.Sprintf("failed to update the donor: %v", problem.FromError(err, "").Title))
+			return
+		}
+		*current = updated
+	}
+}
+
+// readTable reads the rows of the XLSX or CSV file, told apart by the zip signature of the XLSX
+func readTable(content io.Reader, sheet string) ([][]string, error) {
+	buffered := bufio.NewReader(content)
+	signature, _ := buffered.Peek(4)
+	if bytes.Equal(signature, []byte("PK\x03\x04")) {
+		workbook, err := excelize.OpenReader(buffered)
+		if err != nil {
+			return nil, fmt.Errorf("invalid XLSX: %w", err)
+		}
+		defer workbook.Close()
+		if sheet == "" {
+			sheet = workbook.GetSheetName(0)
+		}
+		// the raw values, the formatted dates depend on the locale of the author
+		rows, err := workbook.GetRows(sheet, excelize.Options{RawCellValue: true})
+		if err != nil {
+			return nil, fmt.Errorf("invalid XLSX sheet %q: %w", sheet, err)
+		}
+		return rows, nil
+	}
+
+	data, err := io.ReadAll(buffered)
+	if err != nil {
+		return nil, err
+	}
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	reader := csv.NewReader(bytes.NewReader(data))
+	// spreadsheets in the Slovak locale separate the values by semicolons
+	header, _, _ := bytes.Cut(data, []byte("\n"))
+	if bytes.Count(header, []byte(";")) > bytes.Count(header, []byte(",")) {
+		reader.Comma = ';'
+	}
+	reader.FieldsPerRecord = -1
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("invalid CSV: %w", err)
+	}
+	return rows, nil
+}
+
+// mapColumns returns the column index of every mapped property, the unmapped properties match the column of their name
+func mapColumns(table [][]string, mapping map[string]string) (map[string]int, error) {
+	if len(table) == 0 {
+		return nil, errors.New("the file has no header row")
+	}
+	headers := make(map[string]int)
+	for index, header := range table[0] {
+		headers[strings.ToLower(strings.TrimSpace(header))] = index
+	}
+
+	columns := make(map[string]int)
+	for field := range importSetters {
+		column, mapped := mapping[field]
+		if !mapped {
+			column = field
+		}
+		index, found := headers[strings.ToLower(strings.TrimSpace(column))]
+		if !found {
+			if mapped {
+				return nil, fmt.Errorf("column %q of %v not found", column, field)
+			}
+			continue
+		}
+		columns[field] = index
+	}
+	if _, found := columns["birth_number"]; !found {
+		return nil, errors.New("the birth number column is required")
+	}
+	return columns, nil
+}
+
+func parseRows(table [][]string, columns map[string]int) []*importRow {
+	header := table[0]
+	seen := make(map[string]int32)
+	var rows []*importRow
+	for index, cells := range table[1:] {
+		row := &importRow{
+			DonorImportRow: DonorImportRow{Row: int32(index + 2)},
+			donor:          &Donor{},
+			values:         make(map[string]string),
+		}
+		empty := true
+		for field, column := range columns {
+			if column >= len(cells) || strings.TrimSpace(cells[column]) == "" {
+				continue
+			}
+			empty = false
+			value := restoreFormula(strings.TrimSpace(cells[column]))
+			if err := importSetters[field](row.donor, value); err != nil {
+				row.fail(field, header[column], err.Error())
+				continue
+			}
+			row.values[field] = value
+		}
+		if empty {
+			continue
+		}
+
+		if row.donor.BirthNumber == "" && row.Status != importFailed {
+			row.fail("birth_number", header[columns["birth_number"]], "is required")
+		}
+		if previous, ok := seen[row.donor.BirthNumber]; ok && row.donor.BirthNumber != "" {
+			row.fail("birth_number", header[columns["birth_number"]], fmt.Sprintf("is the same as in the row %v", previous))
+		} else if row.donor.BirthNumber != "" {
+			seen[row.donor.BirthNumber] = row.Row
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+func (this *importRow) fail(field string, column string, message string) {
+	this.Status = importFailed
+	this.DonorId = ""
+	this.Errors = append(this.Errors, DonorImportError{Field: field, Column: column, Message: message})
+}
+
+// parseBirthNumber validates the Slovak or Czech birth number and returns it without the slash.
+// The numbers with the leading zeros lost in the numeric spreadsheet cells are restored.
+func parseBirthNumber(value string) (string, bool) {
+	value = strings.TrimSpace(value)
+	digits := normalizeBirthNumber(value)
+	if _, err := strconv.ParseUint(digits, 10, 64); err != nil {
+		return "", false
+	}
+	if len(digits) < 10 && !strings.Contains(value, "/") {
+		padded := strings.Repeat("0", 10-len(digits)) + digits
+		if validBirthNumber(padded) {
+			return padded, true
+		}
+	}
+	if !validBirthNumber(digits) {
+		return "", false
+	}
+	return digits, true
+}
+
+func validBirthNumber(digits string) bool {
+	if len(digits) != 9 && len(digits) != 10 {
+		return false
+	}
+	year, _ := strconv.Atoi(digits[0:2])
+	month, _ := strconv.Atoi(digits[2:4])
+	day, _ := strconv.Atoi(digits[4:6])
+	// women have 50 added to the month, 20 is added when the numbers of the day run out since 2004
+	switch {
+	case month > 70:
+		month -= 70
+	case month > 50:
+		month -= 50
+	case month > 20:
+		month -= 20
+	}
+	if month < 1 || month > 12 || day < 1 || day > 31 {
+		return false
+	}
+
+	// the nine digit numbers were issued before 1954 and have no check digit
+	if len(digits) == 9 {
+		return year < 54
+	}
+	number, _ := strconv.ParseUint(digits, 10, 64)
+	if number%11 == 0 {
+		return true
+	}
+	// the remainder 10 was written as the check digit 0
+	return (number/10)%11 == 10 && number%10 == 0
+}
+
+// parseImportDate accepts the ISO and the Slovak formats and the serial dates of the spreadsheets
+func parseImportDate(value string) (time.Time, error) {
+	if parsed, err := parseTimeFilter(value); err == nil {
+		return parsed, nil
+	}
+	for _, layout := range []string{"2.1.2006", "2. 1. 2006"} {
+		if parsed, err := time.Parse(layout, value); err == nil {
+			return parsed, nil
+		}
+	}
+	if serial, err := strconv.ParseFloat(value, 64); err == nil && serial > 0 {
+		return excelize.ExcelDateToTime(serial, false)
+	}
+	return time.Time{}, errors.New("has to be a date, e.g. 2024-01-31 or 31.1.2024")
+}
+
+func splitImportList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ";") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,178 @@
+package sprava_krvi
+
+import (
+	"bytes"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Marek-FIIT/sprava-krvi-webapi/internal/auth"
+	"github.com/Marek-FIIT/sprava-krvi-webapi/internal/db_service"
+	"github.com/Marek-FIIT/sprava-krvi-webapi/internal/rbac"
+	"github.com/Marek-FIIT/sprava-krvi-webapi/internal/validation"
+	"github.com/gin-gonic/gin"
+)
+
+func TestNormalizeBirthNumber(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"9908121377", "9908121377"},
+		{"990812/1377", "9908121377"},
+		{" 990812 / 1377 ", "9908121377"},
+		{"", ""},
+	}
+	for _, test := range tests {
+		if got := normalizeBirthNumber(test.value); got != test.want {
+			t.Errorf("normalizeBirthNumber(%q) = %q, want %q", test.value, got, test.want)
+		}
+	}
+}
+
+func TestParseBirthNumber(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  string
+		valid bool
+	}{
+		{"without the slash", "9908121377", "9908121377", true},
+		{"with the slash", "990812/1377", "9908121377", true},
+		{"woman", "995512/0010", "9955120010", true},
+		{"leading zero lost in the spreadsheet", "507150006", "0507150006", true},
+		{"nine digits before 1954", "530101/123", "530101123", true},
+		{"nine digits since 1954", "540101/123", "", false},
+		{"wrong check digit", "990812/1367", "", false},
+		{"invalid month", "991312/1377", "", false},
+		{"not a number", "99081A1377", "", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, valid := parseBirthNumber(test.value)
+			if got != test.want || valid != test.valid {
+				t.Errorf("parseBirthNumber(%q) = %q %v, want %q %v", test.value, got, valid, test.want, test.valid)
+			}
+		})
+	}
+}
+
+func TestParseImportDate(t *testing.T) {
+	want := time.Date(2024, 1, 31, 0, 0, 0, 0, time.UTC)
+	for _, value := range []string{"2024-01-31", "2024-01-31T00:00:00Z", "31.1.2024", "31. 1. 2024", "45322"} {
+		if got, err := parseImportDate(value); err != nil || !got.Equal(want) {
+			t.Errorf("parseImportDate(%q) = %v %v, want %v", value, got, err, want)
+		}
+	}
+	if _, err := parseImportDate("31/01/2024"); err == nil {
+		t.Error("expected the unknown format to be rejected")
+	}
+}
+
+func TestParseRows(t *testing.T) {
+	// exported by a spreadsheet in the Slovak locale, with the byte order mark
+	csv := "\xef\xbb\xbfRodné číslo;Meno;Priezvisko;PSČ;Krvná skupina;Rh;Choroby\n" +
+		"990812/1377;Peter;Marcin;834 07;O;+;HIV\n" +
+		"9955120010;Alica;Nová;83101;C;-;\n" +
+		";;;;;;\n" +
+		"9908121377;Peter;Marcin;83407;A;+;\n" +
+		";Anna;Bez;83407;A;+;\n"
+	table, err := readTable(strings.NewReader(csv), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	columns, err := mapColumns(table, map[string]string{
+		"birth_number": "Rodné číslo",
+		"first_name":   "Meno",
+		"last_name":    "Priezvisko",
+		"postal_code":  "PSČ",
+		"blood_type":   "Krvná skupina",
+		"blood_rh":     "Rh",
+		"diseases":     "Choroby",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rows := parseRows(table, columns)
+	tests := []struct {
+		row    int32
+		errors string
+	}{
+		{2, "[]"},
+		{3, "[{blood_type Krvná skupina has to be one of AB, A, B or 0}]"},
+		{5, "[{birth_number Rodné číslo is the same as in the row 2}]"},
+		{6, "[{birth_number Rodné číslo is required}]"},
+	}
+	if len(rows) != len(tests) {
+		t.Fatalf("parsed %v rows, want %v without the empty one", len(rows), len(tests))
+	}
+	for index, test := range tests {
+		row := rows[index]
+		if row.Row != test.row || fmt.Sprint(row.Errors) != test.errors {
+			t.Errorf("row %v failed by %v, want row %v failed by %v", row.Row, row.Errors, test.row, test.errors)
+		}
+	}
+
+	donor := rows[0].donor
+	want := "9908121377 Peter Marcin 83407 0 + [HIV]"
+	got := fmt.Sprint(donor.BirthNumber, " ", donor.FirstName, " ", donor.LastName, " ", donor.PostalCode, " ", donor.BloodType, " ", donor.BloodRh, " ", donor.Diseases)
+	if got != want {
+		t.Errorf("parsed %v, want %v", got, want)
+	}
+}
+
+func TestImportDonors(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	policy, err := rbac.NewPolicy(rbac.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	donors := "birth_number,first_name,last_name,postal_code\n9908121377,Peter,Marcin,83407\n"
+	withDiseases := "birth_number,first_name,last_name,postal_code,diseases\n9908121377,Peter,Marcin,83407,HIV\n"
+	tests := []struct {
+		name         string
+		role         string
+		transactions bool
+		atomic       string
+		file         string
+		status       int
+	}{
+		{"reception", "reception", true, "false", donors, http.StatusOK},
+		{"atomic", "reception", true, "true", donors, http.StatusOK},
+		{"atomic without transactions", "reception", false, "true", donors, http.StatusBadRequest},
+		{"reception with a hidden column", "reception", true, "false", withDiseases, http.StatusForbidden},
+		{"admin with all columns", "admin", true, "false", withDiseases, http.StatusOK},
+		{"too large", "reception", true, "false", donors + strings.Repeat("9908121377,Peter,Marcin,83407\n", maxImportSize/30), http.StatusRequestEntityTooLarge},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			engine := gin.New()
+			engine.POST("/api/donors/import", func(ctx *gin.Context) {
+				// the key of auth.GetIdentity
+				ctx.Set("identity", &auth.Identity{Subject: "tester", Roles: []string{test.role}})
+				ctx.Set("db_service_donors", db_service.DbService[Donor](&memoryDonors{donors: map[string]Donor{}}))
+				ctx.Set(TransactionsKey, test.transactions)
+			}, policy.Middleware(), LimitImportSize(), validation.Middleware(validation.Config{}), (&implDonorsAPI{}).ImportDonors)
+
+			var body bytes.Buffer
+			form := multipart.NewWriter(&body)
+			_ = form.WriteField("atomic", test.atomic)
+			file, _ := form.CreateFormFile("file", "donors.csv")
+			_, _ = file.Write([]byte(test.file))
+			_ = form.Close()
+			request := httptest.NewRequest(http.MethodPost, "/api/donors/import", &body)
+			request.Header.Set("Content-Type", form.FormDataContentType())
+
+			recorder := httptest.NewRecorder()
+			engine.ServeHTTP(recorder, request)
+			if recorder.Code != test.status {
+				t.Errorf("got %v %v, want %v", recorder.Code, recorder.Body.String(), test.status)
+			}
+		})
+	}
+}
@@ -0,0 +1,45 @@
+package sprava_krvi
+
+import (
+	"github.com/Marek-FIIT/sprava-krvi-webapi/internal/db_service"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// DonorIndexes - indexes of the donor collection, created at startup
+var DonorIndexes = []db_service.Index{
+	{Name: "id_unique", Keys: bson.D{{Key: "id", Value: 1}}, Unique: true},
+	// donors registered without the birth number are not constrained
+	{
+		Name:          "birth_number_unique",
+		Keys:          bson.D{{Key: "birth_number", Value: 1}},
+		Unique:        true,
+		PartialFilter: bson.D{{Key: "birth_number", Value: bson.D{{Key: "$gt", Value: ""}}}},
+	},
+	{Name: "blood_group_eligible", Keys: bson.D{{Key: "blood_type", Value: 1}, {Key: "blood_rh", Value: 1}, {Key: "eligible", Value: 1}}},
+	// GetDonors looks the names, the postal code and the email up case and accent insensitively
+	{Name: "first_name_insensitive", Keys: bson.D{{Key: "first_name", Value: 1}}, Locale: searchLocale},
+	{Name: "last_name_insensitive", Keys: bson.D{{Key: "last_name", Value: 1}}, Locale: searchLocale},
+	{Name: "postal_code_insensitive", Keys: bson.D{{Key: "postal_code", Value: 1}}, Locale: searchLocale},
+	{Name: "email_insensitive", Keys: bson.D{{Key: "email", Value: 1}}, Locale: searchLocale},
+	{Name: "phone_number", Keys: bson.D{{Key: "phone_number", Value: 1}}},
+	{Name: "last_donation", Keys: bson.D{{Key: "last_donation", Value: 1}}},
+	// whole words of either name, the text indexes ignore the diacritics
+	{Name: "name_text", Keys: bson.D{{Key: "first_name", Value: "text"}, {Key: "last_name", Value: "text"}}, TextLanguage: "none"},
+}
+
+// collation of the insensitive searches. The root collation and not the Slovak one,
+// which orders č, š or ž as separate letters and so would not match them by c, s or z.
+const searchLocale = "en"
+
+// UnitIndexes - indexes of the unit collection, created at startup
+var UnitIndexes = []db_service.Index{
+	{Name: "id_unique", Keys: bson.D{{Key: "id", Value: 1}}, Unique: true},
+	// GetUnits filters by the status and blood group the most, the expiration orders the results
+	{Name: "status_blood_group_expiration", Keys: bson.D{{Key: "status", Value: 1}, {Key: "blood_type", Value: 1}, {Key: "blood_rh", Value: 1}, {Key: "expiration", Value: 1}}},
+	{Name: "location_status", Keys: bson.D{{Key: "location", Value: 1}, {Key: "status", Value: 1}}},
+	// expiry sweeps look for the units of the status expiring before a time
+	{Name: "expiration_status", Keys: bson.D{{Key: "expiration", Value: 1}, {Key: "status", Value: 1}}},
+	{Name: "donor_id", Keys: bson.D{{Key: "donor_id", Value: 1}}},
+	{Name: "donation_id", Keys: bson.D{{Key: "donation_id", Value: 1}}},
+	{Name: "created_at", Keys: bson.D{{Key: "created_at", Value: 1}}},
+}
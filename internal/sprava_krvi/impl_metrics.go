@@ -0,0 +1,78 @@
+package sprava_krvi
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/Marek-FIIT/sprava-krvi-webapi/internal/db_service"
+	"github.com/Marek-FIIT/sprava-krvi-webapi/internal/metrics"
+)
+
+const expiringWithin = 72 * time.Hour
+
+// RefreshMetrics recomputes the domain gauges every interval until the context is done
+func RefreshMetrics(ctx context.Context, donors db_service.DbService[Donor], units db_service.DbService[Unit], interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := refreshMetrics(ctx, donors, units); err != nil {
+			slog.ErrorContext(ctx, "Failed to refresh the domain metrics", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func refreshMetrics(ctx context.Context, donors db_service.DbService[Donor], units db_service.DbService[Unit]) error {
+	availableUnits, err := units.FindDocuments(ctx, db_service.NewQuery().
+		Eq("status", "available").
+		Select("blood_type", "blood_rh", "contents", "expiration"))
+	if err != nil {
+		return err
+	}
+	eligibleDonors, err := donors.FindDocuments(ctx, db_service.NewQuery().
+		Eq("eligible", true).
+		Select("id"))
+	if err != nil {
+		return err
+	}
+
+	type componentKey struct{ bloodGroup, component string }
+	available := make(map[componentKey]int)
+	expiring := make(map[string]int)
+	expiration := time.Now().Add(expiringWithin)
+	for _, unit := range availableUnits {
+		bloodGroup := unit.BloodType + unit.BloodRh
+		components := map[string]bool{
+			"erythrocytes": unit.Contents.Erythrocytes,
+			"leukocytes":   unit.Contents.Leukocytes,
+			"platelets":    unit.Contents.Platelets,
+			"plasma":       unit.Contents.Plasma,
+		}
+		for component, present := range components {
+			if present {
+				available[componentKey{bloodGroup, component}]++
+			}
+		}
+		if !unit.Expiration.IsZero() && unit.Expiration.Before(expiration) {
+			expiring[bloodGroup]++
+		}
+	}
+
+	// drop the groups with no units left
+	metrics.AvailableUnits.Reset()
+	for key, count := range available {
+		metrics.AvailableUnits.WithLabelValues(key.bloodGroup, key.component).Set(float64(count))
+	}
+	metrics.ExpiringUnits.Reset()
+	for bloodGroup, count := range expiring {
+		metrics.ExpiringUnits.WithLabelValues(bloodGroup).Set(float64(count))
+	}
+	metrics.EligibleDonors.Set(float64(len(eligibleDonors)))
+	metrics.DomainRefreshed.SetToCurrentTime()
+	return nil
+}
@@ -0,0 +1,165 @@
+package sprava_krvi
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/Marek-FIIT/sprava-krvi-webapi/internal/db_service"
+	"github.com/Marek-FIIT/sprava-krvi-webapi/internal/problem"
+	"github.com/Marek-FIIT/sprava-krvi-webapi/internal/rbac"
+	"github.com/gin-contrib/sse"
+	"github.com/gin-gonic/gin"
+)
+
+// names of the server-sent events of the unit changes
+const (
+	unitEventCreated       = "unit.created"
+	unitEventUpdated       = "unit.updated"
+	unitEventStatusChanged = "unit.status_changed"
+	unitEventDeleted       = "unit.deleted"
+	// the stream could not be resumed, the client is expected to reload the units
+	unitEventReset = "reset"
+)
+
+// EventsHeartbeatKey - context key of the interval of the comments keeping the idle streams open
+const EventsHeartbeatKey = "events_heartbeat"
+
+const defaultEventsHeartbeat = 15 * time.Second
+
+// GetUnitEvents - Streams the changes of the units
+func (this *implUnitsAPI) GetUnitEvents(ctx *gin.Context) {
+	feed, err := db_service.GetChangeFeed[Unit](ctx, "change_feed_units")
+	if err != nil {
+		problem.Abort(ctx, problem.Internal("Failed to access the change feed", err))
+		return
+	}
+	filter := unitEventFilter{
+		bloodTypes: queryList(ctx, "bloodType"),
+		bloodRhs:   queryList(ctx, "bloodRh"),
+		locations:  queryList(ctx, "location"),
+	}
+	heartbeat := ctx.GetDuration(EventsHeartbeatKey)
+	if heartbeat <= 0 {
+		heartbeat = defaultEventsHeartbeat
+	}
+
+	ctx.Header("Content-Type", "text/event-stream")
+	ctx.Header("Cache-Control", "no-cache")
+	// the proxies like nginx would otherwise hold the events back
+	ctx.Header("X-Accel-Buffering", "no")
+	ctx.Status(http.StatusOK)
+	ctx.Writer.Flush()
+
+	resumeAfter := ctx.GetHeader("Last-Event-ID")
+	for {
+		err := streamUnitEvents(ctx, feed, resumeAfter, filter, heartbeat)
+		if !errors.Is(err, db_service.ErrResumeNotPossible) {
+			if err != nil && ctx.Request.Context().Err() == nil {
+				slog.WarnContext(ctx, "Unit events stream failed", "error", err)
+			}
+			return
+		}
+		// continues by the changes from now on, the client reloads the units it missed
+		if err := writeEvent(ctx, sse.Event{Event: unitEventReset, Data: "the missed events are not available"}); err != nil {
+			return
+		}
+		resumeAfter = ""
+	}
+}
+
+// streamUnitEvents writes the matching changes until the client leaves, the feed is done or fails
+func streamUnitEvents(ctx *gin.Context, feed db_service.ChangeFeed[Unit], resumeAfter string, filter unitEventFilter, heartbeat time.Duration) error {
+	streamCtx, cancel := context.WithCancel(ctx.Request.Context())
+	defer cancel()
+
+	// the feed is read aside, so that the heartbeats are sent while it waits for the changes
+	changes := make(chan *db_service.Change[Unit])
+	done := make(chan error, 1)
+	go func() {
+		done <- feed.Changes(streamCtx, resumeAfter, func(change *db_service.Change[Unit]) error {
+			select {
+			case changes <- change:
+				return nil
+			case <-streamCtx.Done():
+				return streamCtx.Err()
+			}
+		})
+	}()
+
+	ticker := time.NewTicker(heartbeat)
+	defer ticker.Stop()
+	for {
+		select {
+		case err := <-done:
+			return err
+		case <-ticker.C:
+			if _, err := ctx.Writer.WriteString(": heartbeat\n\n"); err != nil {
+				return err
+			}
+			ctx.Writer.Flush()
+		case change := <-changes:
+			if !filter.matches(change) {
+				continue
+			}
+			name, event := newUnitChangeEvent(change)
+			if err := writeEvent(ctx, sse.Event{Id: change.Id, Event: name, Data: rbac.Redact(ctx, "UnitChangeEvent", event)}); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func writeEvent(ctx *gin.Context, event sse.Event) error {
+	if err := sse.Encode(ctx.Writer, event); err != nil {
+		return err
+	}
+	ctx.Writer.Flush()
+	return nil
+}
+
+// newUnitChangeEvent names the change, a change of the status is reported as such even if other fields changed too
+func newUnitChangeEvent(change *db_service.Change[Unit]) (string, *UnitChangeEvent) {
+	event := &UnitChangeEvent{
+		UnitId:    change.DocumentId,
+		Unit:      change.After,
+		Timestamp: change.Timestamp,
+	}
+	switch {
+	case change.Operation == db_service.AuditOperationCreate:
+		return unitEventCreated, event
+	case change.Operation == db_service.AuditOperationDelete:
+		return unitEventDeleted, event
+	case change.Before != nil && change.After != nil && change.Before.Status != change.After.Status:
+		event.PreviousStatus = change.Before.Status
+		return unitEventStatusChanged, event
+	default:
+		return unitEventUpdated, event
+	}
+}
+
+// unitEventFilter - filters of the stream, the lists match any of their values, empty lists match all
+type unitEventFilter struct {
+	bloodTypes []interface{}
+	bloodRhs   []interface{}
+	locations  []interface{}
+}
+
+// matches the unit either before or after the change, so that the clients learn about the units leaving the filter
+func (this unitEventFilter) matches(change *db_service.Change[Unit]) bool {
+	return this.matchesUnit(change.Before) || this.matchesUnit(change.After)
+}
+
+func (this unitEventFilter) matchesUnit(unit *Unit) bool {
+	return unit != nil &&
+		matchesAny(this.bloodTypes, unit.BloodType) &&
+		matchesAny(this.bloodRhs, unit.BloodRh) &&
+		matchesAny(this.locations, unit.Location)
+}
+
+func matchesAny(values []interface{}, value string) bool {
+	return len(values) == 0 || slices.Contains(values, interface{}(value))
+}
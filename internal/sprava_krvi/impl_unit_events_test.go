@@ -0,0 +1,131 @@
+package sprava_krvi
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Marek-FIIT/sprava-krvi-webapi/internal/db_service"
+	"github.com/gin-gonic/gin"
+)
+
+func TestUnitEventFilter(t *testing.T) {
+	positive := &Unit{BloodType: "A", BloodRh: "+", Location: "Bratislava"}
+	negative := &Unit{BloodType: "A", BloodRh: "-", Location: "Bratislava"}
+	tests := []struct {
+		name   string
+		filter unitEventFilter
+		change *db_service.Change[Unit]
+		want   bool
+	}{
+		{"no filters", unitEventFilter{}, &db_service.Change[Unit]{After: positive}, true},
+		{"any of the values", unitEventFilter{bloodTypes: []interface{}{"0", "A"}}, &db_service.Change[Unit]{After: positive}, true},
+		{"all of the filters", unitEventFilter{bloodTypes: []interface{}{"A"}, bloodRhs: []interface{}{"-"}}, &db_service.Change[Unit]{After: positive}, false},
+		{"other location", unitEventFilter{locations: []interface{}{"Košice"}}, &db_service.Change[Unit]{After: positive}, false},
+		{"leaving the filter", unitEventFilter{bloodRhs: []interface{}{"+"}}, &db_service.Change[Unit]{Before: positive, After: negative}, true},
+		{"deleted", unitEventFilter{bloodRhs: []interface{}{"-"}}, &db_service.Change[Unit]{Before: negative}, true},
+		{"no unit", unitEventFilter{}, &db_service.Change[Unit]{}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.filter.matches(test.change); got != test.want {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestNewUnitChangeEvent(t *testing.T) {
+	available, reserved := &Unit{Status: "available"}, &Unit{Status: "reserved"}
+	tests := []struct {
+		name           string
+		change         *db_service.Change[Unit]
+		event          string
+		previousStatus string
+	}{
+		{"created", &db_service.Change[Unit]{Operation: db_service.AuditOperationCreate, After: available}, unitEventCreated, ""},
+		{"deleted", &db_service.Change[Unit]{Operation: db_service.AuditOperationDelete, Before: available}, unitEventDeleted, ""},
+		{"status changed", &db_service.Change[Unit]{Operation: db_service.AuditOperationUpdate, Before: available, After: reserved}, unitEventStatusChanged, "available"},
+		{"updated", &db_service.Change[Unit]{Operation: db_service.AuditOperationUpdate, Before: available, After: available}, unitEventUpdated, ""},
+		{"updated without the pre-image", &db_service.Change[Unit]{Operation: db_service.AuditOperationUpdate, After: reserved}, unitEventUpdated, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			name, event := newUnitChangeEvent(test.change)
+			if name != test.event || event.PreviousStatus != test.previousStatus {
+				t.Errorf("got %v from %q, want %v from %q", name, event.PreviousStatus, test.event, test.previousStatus)
+			}
+		})
+	}
+}
+
+// streamRecorder - recorder read while the stream is written
+type streamRecorder struct {
+	*httptest.ResponseRecorder
+	mutex sync.Mutex
+}
+
+func (this *streamRecorder) Write(data []byte) (int, error) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	return this.ResponseRecorder.Write(data)
+}
+
+func (this *streamRecorder) WriteString(data string) (int, error) {
+	return this.Write([]byte(data))
+}
+
+func (this *streamRecorder) body() string {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	return this.Body.String()
+}
+
+func TestGetUnitEventsResetsUnknownResume(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	done, stop := context.WithCancel(context.Background())
+	defer stop()
+	bus := db_service.NewChangeBus[Unit](done, 10)
+
+	recorder := &streamRecorder{ResponseRecorder: httptest.NewRecorder()}
+	ctx, _ := gin.CreateTestContext(recorder)
+	ctx.Request = httptest.NewRequest(http.MethodGet, "/api/units/events?bloodRh=%2B", nil).WithContext(done)
+	// resumes after the event of another bus, e.g. of a replica restarted meanwhile
+	ctx.Request.Header.Set("Last-Event-ID", "0a1b2c3d-7")
+	ctx.Set("change_feed_units", bus)
+	ctx.Set(EventsHeartbeatKey, time.Hour)
+
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		(&implUnitsAPI{}).GetUnitEvents(ctx)
+	}()
+
+	// the changes made before the stream is subscribed again are not delivered, they are repeated until one is
+	deadline := time.Now().Add(5 * time.Second)
+	for !strings.Contains(recorder.body(), "unit.created") {
+		if time.Now().After(deadline) {
+			t.Fatalf("no change was streamed: %q", recorder.body())
+		}
+		bus.Publish(&db_service.Change[Unit]{Operation: db_service.AuditOperationCreate, DocumentId: "negative", After: &Unit{Id: "negative", BloodRh: "-"}})
+		bus.Publish(&db_service.Change[Unit]{Operation: db_service.AuditOperationCreate, DocumentId: "positive", After: &Unit{Id: "positive", BloodRh: "+"}})
+		time.Sleep(10 * time.Millisecond)
+	}
+	stop()
+	<-finished
+
+	body := recorder.body()
+	if recorder.Code != http.StatusOK || recorder.Header().Get("Content-Type") != "text/event-stream" {
+		t.Errorf("responded %v %v", recorder.Code, recorder.Header().Get("Content-Type"))
+	}
+	if !strings.HasPrefix(body, "event:reset\n") {
+		t.Errorf("the stream does not start by the reset: %q", body)
+	}
+	if strings.Contains(body, `"negative"`) || !strings.Contains(body, `"unit_id":"positive"`) {
+		t.Errorf("the stream is not filtered: %q", body)
+	}
+}
@@ -1,45 +1,41 @@
 package sprava_krvi
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/Marek-FIIT/sprava-krvi-webapi/internal/db_service"
+	"github.com/Marek-FIIT/sprava-krvi-webapi/internal/problem"
+	"github.com/Marek-FIIT/sprava-krvi-webapi/internal/rbac"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 )
 
+// units separated from a single donation, the amount is limited so that a request cannot insert unboundedly
+const maxUnitsPerDonation = 10
+
 // CreateUnits - Creates new units
 func (this *implUnitsAPI) CreateUnits(ctx *gin.Context) {
 	/* Process request data */
 	sAmount := ctx.Query("amount")
 	amount, err := strconv.Atoi(sAmount)
-	if err != nil {
-		message := "amount has to be integer"
+	if err != nil || amount < 1 || amount > maxUnitsPerDonation {
+		message := fmt.Sprintf("amount has to be an integer from 1 to %v", maxUnitsPerDonation)
 		if sAmount == "" {
 			message = "amount is required"
 		}
-		ctx.JSON(
-			http.StatusBadRequest,
-			gin.H{
-				"status":  http.StatusBadRequest,
-				"message": message,
-			},
-		)
+		problem.Abort(ctx, problem.BadRequest("Invalid amount", problem.FieldError{Field: "amount", In: "query", Message: message}))
 		return
 	}
 
 	var unit Unit
 	if err := ctx.ShouldBindJSON(&unit); err != nil {
-		ctx.JSON(
-			http.StatusBadRequest,
-			gin.H{
-				"status":  http.StatusBadRequest,
-				"message": "Invalid request body",
-				"error":   err.Error(),
-			},
-		)
+		problem.Abort(ctx, problem.FromBindError(err))
 		return
 	}
 
@@ -53,93 +49,19 @@ func (this *implUnitsAPI) CreateUnits(ctx *gin.Context) {
 		unit.UpdatedAt = time.Now()
 	}
 
-	/* Validate & update donor */
 	if unit.DonorId == "" {
-		ctx.JSON(
-			http.StatusBadRequest,
-			gin.H{
-				"status":  "Bad request",
-				"message": "Donor Id is mandatory",
-			})
+		problem.Abort(ctx, problem.BadRequest("Donor Id is mandatory", problem.FieldError{Field: "donor_id", In: "body", Message: "is required"}))
 		return
 	}
 
 	dbDonor, err := db_service.GetDbService[Donor](ctx, "db_service_donors")
 	if err != nil {
-		ctx.JSON(
-			http.StatusInternalServerError,
-			gin.H{
-				"status":  "Internal Server Error",
-				"message": "failed to access db_service",
-				"error":   err.Error(),
-			})
-		return
-	}
-
-	donor, err := dbDonor.FindDocument(ctx, unit.DonorId)
-	switch err {
-	case nil:
-
-	case db_service.ErrNotFound:
-		ctx.JSON(
-			http.StatusNotFound,
-			gin.H{
-				"status":  "Not Found",
-				"message": "Donor not found",
-				"error":   err.Error(),
-			},
-		)
-		return
-	default:
-		ctx.JSON(
-			http.StatusBadGateway,
-			gin.H{
-				"status":  "Bad Gateway",
-				"message": "Failed to load donor from database",
-				"error":   err.Error(),
-			})
+		problem.Abort(ctx, problem.Internal("Failed to access db_service", err))
 		return
 	}
-
-	donor.LastDonation = time.Now()
-	donor.UpdatedAt = time.Now()
-
-	err = dbDonor.UpdateDocument(ctx, unit.DonorId, donor)
-	switch err {
-	case nil:
-
-	case db_service.ErrNotFound:
-		ctx.JSON(
-			http.StatusNotFound,
-			gin.H{
-				"status":  "Not Found",
-				"message": "Donor was deleted while processing the request",
-				"error":   err.Error(),
-			},
-		)
-		return
-	default:
-		ctx.JSON(
-			http.StatusBadGateway,
-			gin.H{
-				"status":  "Bad Gateway",
-				"message": "Failed to update the donor in the database",
-				"error":   err.Error(),
-			},
-		)
-		return
-	}
-
-	/* Create multiple blood units */
 	dbUnit, err := db_service.GetDbService[Unit](ctx, "db_service_units")
 	if err != nil {
-		ctx.JSON(
-			http.StatusInternalServerError,
-			gin.H{
-				"status":  "Internal Server Error",
-				"message": "failed to access db_service",
-				"error":   err.Error(),
-			})
+		problem.Abort(ctx, problem.Internal("Failed to access db_service", err))
 		return
 	}
 
@@ -150,37 +72,45 @@ func (this *implUnitsAPI) CreateUnits(ctx *gin.Context) {
 		unitCopy.Id = uuid.New().String()
 		ids = append(ids, unitCopy.Id)
 		units = append(units, &unitCopy)
-
 	}
-	err = dbUnit.CreateDocuments(ctx, ids, units)
-	switch err {
-	case nil:
 
-	case db_service.ErrConflict:
-		ctx.JSON(
-			http.StatusConflict,
-			gin.H{
-				"status":  "Conflict",
-				"message": "A unit already exists",
-				"error":   err.Error(),
-			},
-		)
+	// the last donation of the donor is updated only together with the creation of the units
+	err = dbDonor.WithTransaction(ctx, func(txCtx context.Context) error {
+		/* Validate & update donor */
+		donor, err := dbDonor.FindDocument(txCtx, unit.DonorId)
+		if errors.Is(err, db_service.ErrNotFound) {
+			return problem.NotFound("Donor not found")
+		} else if err != nil {
+			return err
+		}
+
+		donor.LastDonation = time.Now()
+		donor.UpdatedAt = time.Now()
+		err = dbDonor.UpdateDocument(txCtx, unit.DonorId, donor)
+		if errors.Is(err, db_service.ErrNotFound) {
+			return problem.NotFound("Donor was deleted while processing the request")
+		} else if err != nil {
+			return err
+		}
+
+		/* Create multiple blood units */
+		return dbUnit.CreateDocuments(txCtx, ids, units)
+	})
+	var conflict *db_service.ConflictError
+	switch {
+	case err == nil:
+
+	case errors.As(err, &conflict):
+		problem.Abort(ctx, problem.Conflict(fmt.Sprintf("Units already exist: %v", strings.Join(conflict.Ids, ", "))))
 		return
 	default:
-		ctx.JSON(
-			http.StatusBadGateway,
-			gin.H{
-				"status":  "Bad Gateway",
-				"message": "Failed to create a unit in database",
-				"error":   err.Error(),
-			},
-		)
+		problem.Abort(ctx, problem.FromError(err, "Failed to create the units in database"))
 		return
 	}
 
 	ctx.JSON(
 		http.StatusCreated,
-		units,
+		rbac.Redact(ctx, "Unit", units),
 	)
 }
 
@@ -188,25 +118,13 @@ func (this *implUnitsAPI) CreateUnits(ctx *gin.Context) {
 func (this *implUnitsAPI) GetUnit(ctx *gin.Context) {
 	unitId := ctx.Param("unitId")
 	if unitId == "" {
-		ctx.JSON(
-			http.StatusBadRequest,
-			gin.H{
-				"status":  http.StatusBadRequest,
-				"message": "Unit ID is required",
-			},
-		)
+		problem.Abort(ctx, problem.BadRequest("Unit ID is required"))
 		return
 	}
 
 	db, err := db_service.GetDbService[Unit](ctx, "db_service_units")
 	if err != nil {
-		ctx.JSON(
-			http.StatusInternalServerError,
-			gin.H{
-				"status":  "Internal Server Error",
-				"message": "failed to access db_service",
-				"error":   err.Error(),
-			})
+		problem.Abort(ctx, problem.Internal("Failed to access db_service", err))
 		return
 	}
 
@@ -216,118 +134,225 @@ func (this *implUnitsAPI) GetUnit(ctx *gin.Context) {
 	case nil:
 		ctx.JSON(
 			http.StatusOK,
-			unit,
+			rbac.Redact(ctx, "Unit", unit),
 		)
 	case db_service.ErrNotFound:
-		ctx.JSON(
-			http.StatusNotFound,
-			gin.H{
-				"status":  "Not Found",
-				"message": "Unit not found",
-				"error":   err.Error(),
-			},
-		)
+		problem.Abort(ctx, problem.NotFound("Unit not found"))
 		return
 	default:
-		ctx.JSON(
-			http.StatusBadGateway,
-			gin.H{
-				"status":  "Bad Gateway",
-				"message": "Failed to load unit from database",
-				"error":   err.Error(),
-			})
+		problem.Abort(ctx, problem.FromError(err, "Failed to load unit from database"))
 		return
 	}
 }
 
+// GetUnitHistory - Provides the audit history of a unit
+func (this *implUnitsAPI) GetUnitHistory(ctx *gin.Context) {
+	unitId := ctx.Param("unitId")
+	if unitId == "" {
+		problem.Abort(ctx, problem.BadRequest("Unit ID is required"))
+		return
+	}
+
+	trail, err := db_service.GetAuditTrail[Unit](ctx, "db_service_units")
+	if err != nil {
+		problem.Abort(ctx, problem.Internal("Failed to access db_service", err))
+		return
+	}
+
+	if sAt := ctx.Query("at"); sAt != "" {
+		at, err := time.Parse(time.RFC3339, sAt)
+		if err != nil {
+			problem.Abort(ctx, problem.BadRequest("Invalid time parameter", problem.FieldError{Field: "at", In: "query", Message: "has to be a RFC 3339 date-time"}))
+			return
+		}
+
+		unit, err := trail.DocumentAt(ctx, unitId, at)
+		switch err {
+		case nil:
+			ctx.JSON(
+				http.StatusOK,
+				rbac.Redact(ctx, "Unit", unit),
+			)
+		case db_service.ErrNotFound:
+			problem.Abort(ctx, problem.NotFound("Unit did not exist at the requested time"))
+		default:
+			problem.Abort(ctx, problem.FromError(err, "Failed to reconstruct the unit from the audit trail"))
+		}
+		return
+	}
+
+	entries, err := trail.History(ctx, unitId)
+	if err != nil {
+		problem.Abort(ctx, problem.FromError(err, "Failed to load the unit history from database"))
+		return
+	}
+
+	ctx.JSON(
+		http.StatusOK,
+		toAuditEntries(entries, rbac.HiddenFields(ctx, "Unit")),
+	)
+}
+
 // GetUnits - Provides the list of blood units
 func (this *implUnitsAPI) GetUnits(ctx *gin.Context) {
-	filters := make(map[string]interface{})
-	var filterErrs []error
-	if bloodType := ctx.Query("bloodType"); bloodType != "" {
-		filters["bloodtype"] = bloodType
+	query := db_service.NewQuery()
+	var filterErrs []problem.FieldError
+	if bloodTypes := queryList(ctx, "bloodType"); len(bloodTypes) > 0 {
+		query = query.In("blood_type", bloodTypes...)
+	}
+	if bloodRhs := queryList(ctx, "bloodRh"); len(bloodRhs) > 0 {
+		query = query.In("blood_rh", bloodRhs...)
+	}
+	if statuses := queryList(ctx, "status"); len(statuses) > 0 {
+		query = query.In("status", statuses...)
+	}
+	if locations := queryList(ctx, "location"); len(locations) > 0 {
+		query = query.In("location", locations...)
+	}
+	if donorId := ctx.Query("donorId"); donorId != "" {
+		query = query.Eq("donor_id", donorId)
+	}
+	if donationId := ctx.Query("donationId"); donationId != "" {
+		query = query.Eq("donation_id", donationId)
+	}
+	for _, filter := range []struct {
+		param string
+		field string
+		after bool
+	}{
+		{"expirationBefore", "expiration", false},
+		{"expirationAfter", "expiration", true},
+		{"createdBefore", "created_at", false},
+		{"createdAfter", "created_at", true},
+	} {
+		value := ctx.Query(filter.param)
+		if value == "" {
+			continue
+		}
+		parsed, err := parseTimeFilter(value)
+		if err != nil {
+			filterErrs = append(filterErrs, problem.FieldError{Field: filter.param, In: "query", Message: err.Error()})
+		} else if filter.after {
+			query = query.Gte(filter.field, parsed)
+		} else {
+			query = query.Lt(filter.field, parsed)
+		}
 	}
-	if bloodRh := ctx.Query("bloodRh"); bloodRh != "" {
-		filters["bloodrh"] = bloodRh
+	for _, filter := range []struct {
+		param string
+		min   bool
+	}{
+		{"hemoglobinMin", true},
+		{"hemoglobinMax", false},
+	} {
+		value := ctx.Query(filter.param)
+		if value == "" {
+			continue
+		}
+		hemoglobin, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			filterErrs = append(filterErrs, problem.FieldError{Field: filter.param, In: "query", Message: "has to be a number"})
+		} else if filter.min {
+			query = query.Gte("contents.hemoglobin", hemoglobin)
+		} else {
+			query = query.Lte("contents.hemoglobin", hemoglobin)
+		}
 	}
-	if status := ctx.Query("status"); status != "" {
-		filters["status"] = status
+	if diseases := queryList(ctx, "diseases"); len(diseases) > 0 {
+		query = query.In("diseases", diseases...)
 	}
-	if location := ctx.Query("location"); location != "" {
-		filters["location"] = location
+	if additional := queryList(ctx, "additional"); len(additional) > 0 {
+		query = query.In("contents.additional", additional...)
 	}
 	if erythrocytes := ctx.Query("erythrocytes"); erythrocytes != "" {
 		erythrocytesBool, err := strconv.ParseBool(erythrocytes)
-		filterErrs = append(filterErrs, err)
-		filters["contents.erythrocytes"] = erythrocytesBool
+		if err != nil {
+			filterErrs = append(filterErrs, problem.FieldError{Field: "erythrocytes", In: "query", Message: "has to be a boolean"})
+		}
+		query = query.Eq("contents.erythrocytes", erythrocytesBool)
 	}
 	if leukocytes := ctx.Query("leukocytes"); leukocytes != "" {
 		leukocytesBool, err := strconv.ParseBool(leukocytes)
-		filterErrs = append(filterErrs, err)
-		filters["contents.leukocytes"] = leukocytesBool
+		if err != nil {
+			filterErrs = append(filterErrs, problem.FieldError{Field: "leukocytes", In: "query", Message: "has to be a boolean"})
+		}
+		query = query.Eq("contents.leukocytes", leukocytesBool)
 	}
 	if platelets := ctx.Query("platelets"); platelets != "" {
 		plateletsBool, err := strconv.ParseBool(platelets)
-		filterErrs = append(filterErrs, err)
-		filters["contents.platelets"] = plateletsBool
+		if err != nil {
+			filterErrs = append(filterErrs, problem.FieldError{Field: "platelets", In: "query", Message: "has to be a boolean"})
+		}
+		query = query.Eq("contents.platelets", plateletsBool)
 	}
 	if plasma := ctx.Query("plasma"); plasma != "" {
 		plasmaBool, err := strconv.ParseBool(plasma)
-		filterErrs = append(filterErrs, err)
-		filters["contents.plasma"] = plasmaBool
+		if err != nil {
+			filterErrs = append(filterErrs, problem.FieldError{Field: "plasma", In: "query", Message: "has to be a boolean"})
+		}
+		query = query.Eq("contents.plasma", plasmaBool)
 	}
 	if frozen := ctx.Query("frozen"); frozen != "" {
 		frozenBool, err := strconv.ParseBool(frozen)
-		filterErrs = append(filterErrs, err)
-		filters["frozen"] = frozenBool
-	}
-	for _, err := range filterErrs {
 		if err != nil {
-			ctx.JSON(
-				http.StatusBadRequest,
-				gin.H{
-					"status":  http.StatusBadRequest,
-					"message": "Could not parse filters",
-				},
-			)
-			return
+			filterErrs = append(filterErrs, problem.FieldError{Field: "frozen", In: "query", Message: "has to be a boolean"})
+		}
+		query = query.Eq("frozen", frozenBool)
+	}
+	fields := make(map[string]bool)
+	for _, field := range queryList(ctx, "fields") {
+		if !unitListFields[field.(string)] {
+			filterErrs = append(filterErrs, problem.FieldError{Field: "fields", In: "query", Message: fmt.Sprintf("unknown field %v", field)})
+		}
+		fields[field.(string)] = true
+	}
+	if len(filterErrs) > 0 {
+		problem.Abort(ctx, problem.BadRequest("Could not parse filters", filterErrs...))
+		return
+	}
+
+	// filtering by a hidden field would reveal its value
+	for _, hidden := range rbac.HiddenFields(ctx, "Unit") {
+		for _, condition := range query.Conditions {
+			if condition.Field == hidden {
+				problem.Abort(ctx, problem.Forbidden(fmt.Sprintf("Filtering by %v is not permitted", hidden)))
+				return
+			}
 		}
 	}
 
+	export, exportProblem := newExport(ctx, "units", "Unit", unitExportColumns)
+	if exportProblem != nil {
+		problem.Abort(ctx, exportProblem)
+		return
+	}
+
 	db, err := db_service.GetDbService[Unit](ctx, "db_service_units")
 	if err != nil {
-		ctx.JSON(
-			http.StatusInternalServerError,
-			gin.H{
-				"status":  "Internal Server Error",
-				"message": "failed to access db_service",
-				"error":   err.Error(),
-			})
+		problem.Abort(ctx, problem.Internal("Failed to access db_service", err))
+		return
+	}
+
+	if export != nil {
+		export.write(ctx, db, query)
 		return
 	}
 
-	units, err := db.FindDocuments(ctx, filters)
+	selected := []string{"id", "blood_type", "blood_rh", "status", "location"}
+	for field := range fields {
+		selected = append(selected, field)
+	}
+	query = query.Select(selected...)
+
+	units, err := db.FindDocuments(ctx, query)
 	switch err {
 	case nil:
 		// pass
 	case db_service.ErrNotFound:
-		ctx.JSON(
-			http.StatusNotFound,
-			gin.H{
-				"status":  "Not Found",
-				"message": "Unit not found",
-				"error":   err.Error(),
-			},
-		)
+		problem.Abort(ctx, problem.NotFound("Unit not found"))
 		return
 	default:
-		ctx.JSON(
-			http.StatusBadGateway,
-			gin.H{
-				"status":  "Bad Gateway",
-				"message": "Failed to load unit from database",
-				"error":   err.Error(),
-			})
+		problem.Abort(ctx, problem.FromError(err, "Failed to load unit from database"))
 		return
 	}
 
@@ -340,12 +365,36 @@ func (this *implUnitsAPI) GetUnits(ctx *gin.Context) {
 			Status:    unit.Status,
 			Location:  unit.Location,
 		}
+		if fields["donor_id"] {
+			entry.DonorId = unit.DonorId
+		}
+		if fields["donation_id"] {
+			entry.DonationId = unit.DonationId
+		}
+		if fields["contents"] {
+			entry.Contents = &unit.Contents
+		}
+		if fields["frozen"] {
+			entry.Frozen = &unit.Frozen
+		}
+		if fields["diseases"] {
+			entry.Diseases = unit.Diseases
+		}
+		if fields["expiration"] {
+			entry.Expiration = &unit.Expiration
+		}
+		if fields["created_at"] {
+			entry.CreatedAt = &unit.CreatedAt
+		}
+		if fields["updated_at"] {
+			entry.UpdatedAt = &unit.UpdatedAt
+		}
 		listEntries = append(listEntries, entry)
 	}
 
 	ctx.JSON(
 		http.StatusOK,
-		listEntries,
+		rbac.Redact(ctx, "UnitListEntry", listEntries),
 	)
 }
 
@@ -353,49 +402,24 @@ func (this *implUnitsAPI) GetUnits(ctx *gin.Context) {
 func (this *implUnitsAPI) UpdateUnit(ctx *gin.Context) {
 	unitId := ctx.Param("unitId")
 	if unitId == "" {
-		ctx.JSON(
-			http.StatusBadRequest,
-			gin.H{
-				"status":  http.StatusBadRequest,
-				"message": "Unit ID is required",
-			},
-		)
+		problem.Abort(ctx, problem.BadRequest("Unit ID is required"))
 		return
 	}
 
 	var unit Unit
 	if err := ctx.ShouldBindJSON(&unit); err != nil {
-		ctx.JSON(
-			http.StatusBadRequest,
-			gin.H{
-				"status":  http.StatusBadRequest,
-				"message": "Invalid request body",
-				"error":   err.Error(),
-			},
-		)
+		problem.Abort(ctx, problem.FromBindError(err))
 		return
 	}
 
 	if unit.Id != "" && unitId != unit.Id {
-		ctx.JSON(
-			http.StatusBadRequest,
-			gin.H{
-				"status":  http.StatusBadRequest,
-				"message": "Id missmatch (body vs query)",
-			},
-		)
+		problem.Abort(ctx, problem.BadRequest("Id mismatch (body vs path)", problem.FieldError{Field: "id", In: "body", Message: "does not match the unitId path parameter"}))
 		return
 	}
 
 	db, err := db_service.GetDbService[Unit](ctx, "db_service_units")
 	if err != nil {
-		ctx.JSON(
-			http.StatusInternalServerError,
-			gin.H{
-				"status":  "Internal Server Error",
-				"message": "failed to access db_service",
-				"error":   err.Error(),
-			})
+		problem.Abort(ctx, problem.Internal("Failed to access db_service", err))
 		return
 	}
 
@@ -404,53 +428,29 @@ func (this *implUnitsAPI) UpdateUnit(ctx *gin.Context) {
 	case nil:
 		//pass
 	case db_service.ErrNotFound:
-		ctx.JSON(
-			http.StatusNotFound,
-			gin.H{
-				"status":  "Not Found",
-				"message": "Unit not found",
-				"error":   err.Error(),
-			},
-		)
+		problem.Abort(ctx, problem.NotFound("Unit not found"))
 		return
 	default:
-		ctx.JSON(
-			http.StatusBadGateway,
-			gin.H{
-				"status":  "Bad Gateway",
-				"message": "Failed to retrieve the existing unit from the database",
-				"error":   err.Error(),
-			},
-		)
+		problem.Abort(ctx, problem.FromError(err, "Failed to retrieve the existing unit from the database"))
 		return
 	}
 	unit.Id = existing_unit.Id
 	unit.CreatedAt = existing_unit.CreatedAt
+	if err := rbac.RestoreHidden(ctx, "Unit", existing_unit, &unit); err != nil {
+		problem.Abort(ctx, problem.Internal("Failed to preserve the fields hidden from the caller", err))
+		return
+	}
 	unit.UpdatedAt = time.Now()
 	err = db.UpdateDocument(ctx, unitId, &unit)
 	switch err {
 	case nil:
-		ctx.JSON(http.StatusOK, unit)
+		ctx.JSON(http.StatusOK, rbac.Redact(ctx, "Unit", unit))
 		return
 	case db_service.ErrNotFound:
-		ctx.JSON(
-			http.StatusNotFound,
-			gin.H{
-				"status":  "Not Found",
-				"message": "Unit was deleted while processing the request",
-				"error":   err.Error(),
-			},
-		)
+		problem.Abort(ctx, problem.NotFound("Unit was deleted while processing the request"))
 		return
 	default:
-		ctx.JSON(
-			http.StatusBadGateway,
-			gin.H{
-				"status":  "Bad Gateway",
-				"message": "Failed to update the unit in the database",
-				"error":   err.Error(),
-			},
-		)
+		problem.Abort(ctx, problem.FromError(err, "Failed to update the unit in the database"))
 		return
 	}
 }
@@ -459,25 +459,13 @@ func (this *implUnitsAPI) UpdateUnit(ctx *gin.Context) {
 func (this *implUnitsAPI) DeleteUnit(ctx *gin.Context) {
 	unitId := ctx.Param("unitId")
 	if unitId == "" {
-		ctx.JSON(
-			http.StatusBadRequest,
-			gin.H{
-				"status":  http.StatusBadRequest,
-				"message": "Unit ID is required",
-			},
-		)
+		problem.Abort(ctx, problem.BadRequest("Unit ID is required"))
 		return
 	}
 
 	db, err := db_service.GetDbService[Unit](ctx, "db_service_units")
 	if err != nil {
-		ctx.JSON(
-			http.StatusInternalServerError,
-			gin.H{
-				"status":  "Internal Server Error",
-				"message": "failed to access db_service",
-				"error":   err.Error(),
-			})
+		problem.Abort(ctx, problem.Internal("Failed to access db_service", err))
 		return
 	}
 
@@ -487,24 +475,35 @@ func (this *implUnitsAPI) DeleteUnit(ctx *gin.Context) {
 		ctx.JSON(http.StatusNoContent, struct{}{})
 		return
 	case db_service.ErrNotFound:
-		ctx.JSON(
-			http.StatusNotFound,
-			gin.H{
-				"status":  "Not Found",
-				"message": "Unit was deleted while processing the request",
-				"error":   err.Error(),
-			},
-		)
+		problem.Abort(ctx, problem.NotFound("Unit was deleted while processing the request"))
 		return
 	default:
-		ctx.JSON(
-			http.StatusBadGateway,
-			gin.H{
-				"status":  "Bad Gateway",
-				"message": "Failed to delete the unit from the database",
-				"error":   err.Error(),
-			},
-		)
+		problem.Abort(ctx, problem.FromError(err, "Failed to delete the unit from the database"))
 		return
 	}
 }
+
+// extra fields of the list entry selectable by the fields parameter
+var unitListFields = map[string]bool{
+	"donor_id":    true,
+	"donation_id": true,
+	"contents":    true,
+	"frozen":      true,
+	"diseases":    true,
+	"expiration":  true,
+	"created_at":  true,
+	"updated_at":  true,
+}
+
+// queryList returns the comma separated values of the parameter, which may be also repeated
+func queryList(ctx *gin.Context, param string) []interface{} {
+	var values []interface{}
+	for _, value := range ctx.QueryArray(param) {
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				values = append(values, item)
+			}
+		}
+	}
+	return values
+}
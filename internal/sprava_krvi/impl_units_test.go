@@ -0,0 +1,114 @@
+package sprava_krvi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Marek-FIIT/sprava-krvi-webapi/internal/db_service"
+	"github.com/gin-gonic/gin"
+)
+
+type transactionKey struct{}
+
+// memoryDonors - donors in memory, the transaction only marks the context of its operations
+type memoryDonors struct {
+	db_service.DbService[Donor]
+	donors map[string]Donor
+}
+
+func (this *memoryDonors) FindDocument(ctx context.Context, id string) (*Donor, error) {
+	donor, ok := this.donors[id]
+	if !ok {
+		return nil, db_service.ErrNotFound
+	}
+	return &donor, nil
+}
+
+func (this *memoryDonors) UpdateDocument(ctx context.Context, id string, donor *Donor) error {
+	if ctx.Value(transactionKey{}) == nil {
+		return errors.New("the donor is updated outside of the transaction")
+	}
+	this.donors[id] = *donor
+	return nil
+}
+
+func (this *memoryDonors) WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	return fn(context.WithValue(ctx, transactionKey{}, true))
+}
+
+type memoryUnits struct {
+	db_service.DbService[Unit]
+	created []*Unit
+	err     error
+}
+
+func (this *memoryUnits) CreateDocuments(ctx context.Context, ids []string, units []*Unit) error {
+	if ctx.Value(transactionKey{}) == nil {
+		return errors.New("the units are created outside of the transaction")
+	}
+	if this.err != nil {
+		return this.err
+	}
+	this.created = append(this.created, units...)
+	return nil
+}
+
+func TestCreateUnits(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tests := []struct {
+		name    string
+		amount  string
+		donorId string
+		err     error
+		status  int
+		created int
+	}{
+		{"created", "2", "1", nil, http.StatusCreated, 2},
+		{"maximum", "10", "1", nil, http.StatusCreated, 10},
+		{"missing amount", "", "1", nil, http.StatusBadRequest, 0},
+		{"not a number", "two", "1", nil, http.StatusBadRequest, 0},
+		{"zero", "0", "1", nil, http.StatusBadRequest, 0},
+		{"negative", "-3", "1", nil, http.StatusBadRequest, 0},
+		{"above maximum", "11", "1", nil, http.StatusBadRequest, 0},
+		{"unknown donor", "2", "2", nil, http.StatusNotFound, 0},
+		{"conflict", "2", "1", &db_service.ConflictError{Ids: []string{"x"}}, http.StatusConflict, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			donors := &memoryDonors{donors: map[string]Donor{"1": {Id: "1"}}}
+			units := &memoryUnits{err: test.err}
+
+			recorder := httptest.NewRecorder()
+			ctx, _ := gin.CreateTestContext(recorder)
+			body := `{"donor_id":"` + test.donorId + `","blood_type":"A","blood_rh":"+"}`
+			ctx.Request = httptest.NewRequest(http.MethodPost, "/api/units?amount="+test.amount, strings.NewReader(body))
+			ctx.Request.Header.Set("Content-Type", "application/json")
+			ctx.Set("db_service_donors", db_service.DbService[Donor](donors))
+			ctx.Set("db_service_units", db_service.DbService[Unit](units))
+
+			(&implUnitsAPI{}).CreateUnits(ctx)
+			if recorder.Code != test.status || len(units.created) != test.created {
+				t.Fatalf("responded %v %v with %v units created, want %v with %v", recorder.Code, recorder.Body.String(), len(units.created), test.status, test.created)
+			}
+			if test.status != http.StatusCreated {
+				return
+			}
+			var created []Unit
+			if err := json.Unmarshal(recorder.Body.Bytes(), &created); err != nil || len(created) != test.created {
+				t.Fatalf("responded %v, %v", recorder.Body.String(), err)
+			}
+			if created[0].Id == created[1].Id || created[0].DonationId != created[1].DonationId {
+				t.Errorf("the units of a donation share the donation id, not the id: %+v", created)
+			}
+			if time.Since(donors.donors["1"].LastDonation) > time.Minute {
+				t.Error("the last donation of the donor is not updated")
+			}
+		})
+	}
+}
@@ -0,0 +1,353 @@
+package sprava_krvi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/Marek-FIIT/sprava-krvi-webapi/internal/db_service"
+	"github.com/Marek-FIIT/sprava-krvi-webapi/internal/problem"
+	"github.com/Marek-FIIT/sprava-krvi-webapi/internal/webhooks"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// shorter secrets are too easy to guess from the signed deliveries
+const minWebhookSecretLength = 16
+
+const (
+	defaultDeliveryLimit = 100
+	maxDeliveryLimit     = 500
+)
+
+// CreateWebhook - Registers a webhook subscription
+func (this *implWebhooksAPI) CreateWebhook(ctx *gin.Context) {
+	var webhook WebhookSubscription
+	if err := ctx.ShouldBindJSON(&webhook); err != nil {
+		problem.Abort(ctx, problem.FromBindError(err))
+		return
+	}
+	if fieldErrs := validateWebhook(ctx, &webhook, true); len(fieldErrs) > 0 {
+		problem.Abort(ctx, problem.BadRequest("Invalid webhook subscription", fieldErrs...))
+		return
+	}
+
+	db, err := db_service.GetDbService[webhooks.Subscription](ctx, "db_service_webhooks")
+	if err != nil {
+		problem.Abort(ctx, problem.Internal("Failed to access db_service", err))
+		return
+	}
+
+	subscription := &webhooks.Subscription{
+		Id:        uuid.New().String(),
+		Url:       webhook.Url,
+		Secret:    webhook.Secret,
+		Events:    webhook.Events,
+		Active:    webhook.Active == nil || *webhook.Active,
+		CreatedAt: time.Now(),
+	}
+	subscription.UpdatedAt = subscription.CreatedAt
+	if err := db.CreateDocument(ctx, subscription.Id, subscription); err != nil {
+		problem.Abort(ctx, problem.FromError(err, "Failed to create the webhook subscription in database"))
+		return
+	}
+	ctx.JSON(http.StatusCreated, toWebhookSubscription(subscription))
+}
+
+// DeleteWebhook - Deletes the webhook subscription
+func (this *implWebhooksAPI) DeleteWebhook(ctx *gin.Context) {
+	webhookId := ctx.Param("webhookId")
+	if webhookId == "" {
+		problem.Abort(ctx, problem.BadRequest("Webhook ID is required"))
+		return
+	}
+
+	db, err := db_service.GetDbService[webhooks.Subscription](ctx, "db_service_webhooks")
+	if err != nil {
+		problem.Abort(ctx, problem.Internal("Failed to access db_service", err))
+		return
+	}
+
+	err = db.DeleteDocument(ctx, webhookId)
+	switch err {
+	case nil:
+		ctx.JSON(http.StatusNoContent, struct{}{})
+	case db_service.ErrNotFound:
+		problem.Abort(ctx, problem.NotFound("Webhook subscription not found"))
+	default:
+		problem.Abort(ctx, problem.FromError(err, "Failed to delete the webhook subscription from the database"))
+	}
+}
+
+// GetWebhook - Provides the webhook subscription
+func (this *implWebhooksAPI) GetWebhook(ctx *gin.Context) {
+	webhookId := ctx.Param("webhookId")
+	if webhookId == "" {
+		problem.Abort(ctx, problem.BadRequest("Webhook ID is required"))
+		return
+	}
+
+	db, err := db_service.GetDbService[webhooks.Subscription](ctx, "db_service_webhooks")
+	if err != nil {
+		problem.Abort(ctx, problem.Internal("Failed to access db_service", err))
+		return
+	}
+
+	subscription, err := db.FindDocument(ctx, webhookId)
+	switch err {
+	case nil:
+		ctx.JSON(http.StatusOK, toWebhookSubscription(subscription))
+	case db_service.ErrNotFound:
+		problem.Abort(ctx, problem.NotFound("Webhook subscription not found"))
+	default:
+		problem.Abort(ctx, problem.FromError(err, "Failed to load the webhook subscription from database"))
+	}
+}
+
+// GetWebhookDeliveries - Provides the webhook deliveries
+func (this *implWebhooksAPI) GetWebhookDeliveries(ctx *gin.Context) {
+	query := db_service.NewQuery()
+	var filterErrs []problem.FieldError
+	if status := ctx.Query("status"); status != "" {
+		switch status {
+		case webhooks.DeliveryPending, webhooks.DeliveryDelivered, webhooks.DeliveryDead:
+			query = query.Eq("status", status)
+		default:
+			filterErrs = append(filterErrs, problem.FieldError{Field: "status", In: "query", Message: "has to be pending, delivered or dead"})
+		}
+	}
+	if webhookId := ctx.Query("webhookId"); webhookId != "" {
+		query = query.Eq("subscription_id", webhookId)
+	}
+	limit := defaultDeliveryLimit
+	if sLimit := ctx.Query("limit"); sLimit != "" {
+		var err error
+		limit, err = strconv.Atoi(sLimit)
+		if err != nil || limit < 1 || limit > maxDeliveryLimit {
+			filterErrs = append(filterErrs, problem.FieldError{Field: "limit", In: "query", Message: fmt.Sprintf("has to be an integer from 1 to %v", maxDeliveryLimit)})
+		}
+	}
+	if len(filterErrs) > 0 {
+		problem.Abort(ctx, problem.BadRequest("Could not parse filters", filterErrs...))
+		return
+	}
+
+	db, err := db_service.GetDbService[webhooks.Delivery](ctx, "db_service_webhook_deliveries")
+	if err != nil {
+		problem.Abort(ctx, problem.Internal("Failed to access db_service", err))
+		return
+	}
+
+	deliveries, err := db.FindDocuments(ctx, query.OrderBy("created_at", true).Take(int64(limit)))
+	if err != nil {
+		problem.Abort(ctx, problem.FromError(err, "Failed to load the webhook deliveries from database"))
+		return
+	}
+
+	webhookDeliveries := []*WebhookDelivery{}
+	for _, delivery := range deliveries {
+		webhookDeliveries = append(webhookDeliveries, toWebhookDelivery(delivery))
+	}
+	ctx.JSON(http.StatusOK, webhookDeliveries)
+}
+
+// GetWebhooks - Provides the list of webhook subscriptions
+func (this *implWebhooksAPI) GetWebhooks(ctx *gin.Context) {
+	db, err := db_service.GetDbService[webhooks.Subscription](ctx, "db_service_webhooks")
+	if err != nil {
+		problem.Abort(ctx, problem.Internal("Failed to access db_service", err))
+		return
+	}
+
+	subscriptions, err := db.FindDocuments(ctx, db_service.NewQuery().OrderBy("created_at", false))
+	if err != nil {
+		problem.Abort(ctx, problem.FromError(err, "Failed to load the webhook subscriptions from database"))
+		return
+	}
+
+	webhookSubscriptions := []*WebhookSubscription{}
+	for _, subscription := range subscriptions {
+		webhookSubscriptions = append(webhookSubscriptions, toWebhookSubscription(subscription))
+	}
+	ctx.JSON(http.StatusOK, webhookSubscriptions)
+}
+
+// RetryWebhookDelivery - Retries the webhook delivery
+func (this *implWebhooksAPI) RetryWebhookDelivery(ctx *gin.Context) {
+	deliveryId := ctx.Param("deliveryId")
+	if deliveryId == "" {
+		problem.Abort(ctx, problem.BadRequest("Delivery ID is required"))
+		return
+	}
+
+	db, err := db_service.GetDbService[webhooks.Delivery](ctx, "db_service_webhook_deliveries")
+	if err != nil {
+		problem.Abort(ctx, problem.Internal("Failed to access db_service", err))
+		return
+	}
+
+	delivery, err := db.FindDocument(ctx, deliveryId)
+	switch err {
+	case nil:
+		//pass
+	case db_service.ErrNotFound:
+		problem.Abort(ctx, problem.NotFound("Webhook delivery not found"))
+		return
+	default:
+		problem.Abort(ctx, problem.FromError(err, "Failed to load the webhook delivery from database"))
+		return
+	}
+	if delivery.Status == webhooks.DeliveryDelivered {
+		problem.Abort(ctx, problem.Conflict("Webhook delivery was already delivered"))
+		return
+	}
+
+	// all the attempts are available again
+	delivery.Status = webhooks.DeliveryPending
+	delivery.Attempts = 0
+	delivery.NextAttemptAt = time.Now()
+	err = db.UpdateDocument(ctx, delivery.Id, delivery)
+	switch err {
+	case nil:
+		webhooks.Notify(ctx)
+		ctx.JSON(http.StatusAccepted, toWebhookDelivery(delivery))
+	case db_service.ErrNotFound:
+		problem.Abort(ctx, problem.NotFound("Webhook delivery was deleted while processing the request"))
+	default:
+		problem.Abort(ctx, problem.FromError(err, "Failed to update the webhook delivery in the database"))
+	}
+}
+
+// UpdateWebhook - Updates the webhook subscription
+func (this *implWebhooksAPI) UpdateWebhook(ctx *gin.Context) {
+	webhookId := ctx.Param("webhookId")
+	if webhookId == "" {
+		problem.Abort(ctx, problem.BadRequest("Webhook ID is required"))
+		return
+	}
+
+	var webhook WebhookSubscription
+	if err := ctx.ShouldBindJSON(&webhook); err != nil {
+		problem.Abort(ctx, problem.FromBindError(err))
+		return
+	}
+	if webhook.Id != "" && webhookId != webhook.Id {
+		problem.Abort(ctx, problem.BadRequest("Id mismatch (body vs path)", problem.FieldError{Field: "id", In: "body", Message: "does not match the webhookId path parameter"}))
+		return
+	}
+	if fieldErrs := validateWebhook(ctx, &webhook, false); len(fieldErrs) > 0 {
+		problem.Abort(ctx, problem.BadRequest("Invalid webhook subscription", fieldErrs...))
+		return
+	}
+
+	db, err := db_service.GetDbService[webhooks.Subscription](ctx, "db_service_webhooks")
+	if err != nil {
+		problem.Abort(ctx, problem.Internal("Failed to access db_service", err))
+		return
+	}
+
+	subscription, err := db.FindDocument(ctx, webhookId)
+	switch err {
+	case nil:
+		//pass
+	case db_service.ErrNotFound:
+		problem.Abort(ctx, problem.NotFound("Webhook subscription not found"))
+		return
+	default:
+		problem.Abort(ctx, problem.FromError(err, "Failed to retrieve the existing webhook subscription from the database"))
+		return
+	}
+
+	subscription.Url = webhook.Url
+	subscription.Events = webhook.Events
+	if webhook.Secret != "" {
+		subscription.Secret = webhook.Secret
+	}
+	if webhook.Active != nil {
+		subscription.Active = *webhook.Active
+	}
+	subscription.UpdatedAt = time.Now()
+	err = db.UpdateDocument(ctx, webhookId, subscription)
+	switch err {
+	case nil:
+		ctx.JSON(http.StatusOK, toWebhookSubscription(subscription))
+	case db_service.ErrNotFound:
+		problem.Abort(ctx, problem.NotFound("Webhook subscription was deleted while processing the request"))
+	default:
+		problem.Abort(ctx, problem.FromError(err, "Failed to update the webhook subscription in the database"))
+	}
+}
+
+// validateWebhook checks the target and the events, the secret is required only on the creation
+func validateWebhook(ctx *gin.Context, webhook *WebhookSubscription, create bool) []problem.FieldError {
+	var fieldErrs []problem.FieldError
+	if target, err := url.Parse(webhook.Url); err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		fieldErrs = append(fieldErrs, problem.FieldError{Field: "url", In: "body", Message: "has to be an absolute http(s) URL"})
+	} else {
+		targets, _ := ctx.Value(webhooks.TargetsKey).(*webhooks.Targets)
+		if err := targets.Check(ctx, target); err != nil {
+			fieldErrs = append(fieldErrs, problem.FieldError{Field: "url", In: "body", Message: err.Error()})
+		}
+	}
+	if create && webhook.Secret == "" {
+		fieldErrs = append(fieldErrs, problem.FieldError{Field: "secret", In: "body", Message: "is required"})
+	} else if webhook.Secret != "" && len(webhook.Secret) < minWebhookSecretLength {
+		fieldErrs = append(fieldErrs, problem.FieldError{Field: "secret", In: "body", Message: fmt.Sprintf("has to be at least %v characters long", minWebhookSecretLength)})
+	}
+	if len(webhook.Events) == 0 {
+		fieldErrs = append(fieldErrs, problem.FieldError{Field: "events", In: "body", Message: "at least one event type is required"})
+	}
+	seen := make(map[string]bool)
+	var events []string
+	for _, event := range webhook.Events {
+		if !webhooks.IsEventType(event) {
+			fieldErrs = append(fieldErrs, problem.FieldError{Field: "events", In: "body", Message: fmt.Sprintf("unknown event type %v", event)})
+		} else if !seen[event] {
+			events = append(events, event)
+		}
+		seen[event] = true
+	}
+	webhook.Events = events
+	return fieldErrs
+}
+
+// toWebhookSubscription converts the subscription to the api model, leaving the secret out
+func toWebhookSubscription(subscription *webhooks.Subscription) *WebhookSubscription {
+	active := subscription.Active
+	return &WebhookSubscription{
+		Id:        subscription.Id,
+		Url:       subscription.Url,
+		Events:    subscription.Events,
+		Active:    &active,
+		CreatedAt: subscription.CreatedAt,
+		UpdatedAt: subscription.UpdatedAt,
+	}
+}
+
+func toWebhookDelivery(delivery *webhooks.Delivery) *WebhookDelivery {
+	webhookDelivery := &WebhookDelivery{
+		Id:             delivery.Id,
+		WebhookId:      delivery.SubscriptionId,
+		EventId:        delivery.EventId,
+		EventType:      delivery.EventType,
+		Status:         delivery.Status,
+		Attempts:       int32(delivery.Attempts),
+		LastError:      delivery.LastError,
+		LastStatusCode: int32(delivery.LastStatusCode),
+		CreatedAt:      delivery.CreatedAt,
+	}
+	if delivery.Status == webhooks.DeliveryPending {
+		webhookDelivery.NextAttemptAt = &delivery.NextAttemptAt
+	}
+	if !delivery.DeliveredAt.IsZero() {
+		webhookDelivery.DeliveredAt = &delivery.DeliveredAt
+	}
+	var event WebhookEvent
+	if err := json.Unmarshal([]byte(delivery.Payload), &event); err == nil {
+		webhookDelivery.Event = &event
+	}
+	return webhookDelivery
+}
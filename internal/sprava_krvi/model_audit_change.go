@@ -0,0 +1,21 @@
+/*
+ * Blood management API
+ *
+ * Management of blood donors and blood units
+ *
+ * API version: 1.0.0
+ * Contact: xsykoram3@stuba.sk
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package sprava_krvi
+
+// AuditChange - Change of a single document field, nested fields are separated by dots
+type AuditChange struct {
+
+	Field string `json:"field" bson:"field"`
+
+	Before interface{} `json:"before,omitempty" bson:"before"`
+
+	After interface{} `json:"after,omitempty" bson:"after"`
+}
@@ -0,0 +1,38 @@
+/*
+ * Blood management API
+ *
+ * Management of blood donors and blood units
+ *
+ * API version: 1.0.0
+ * Contact: xsykoram3@stuba.sk
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package sprava_krvi
+
+import (
+	"time"
+)
+
+// AuditEntry - Single recorded change of a donor or unit
+type AuditEntry struct {
+
+	Id string `json:"id" bson:"id"`
+
+	Collection string `json:"collection" bson:"collection"`
+
+	DocumentId string `json:"document_id" bson:"document_id"`
+
+	Operation string `json:"operation" bson:"operation"`
+
+	Actor string `json:"actor" bson:"actor"`
+
+	RequestId string `json:"request_id,omitempty" bson:"request_id"`
+
+	// explanation of the change, e.g. the merge of a duplicate donor
+	Reason string `json:"reason,omitempty" bson:"reason"`
+
+	Timestamp time.Time `json:"timestamp" bson:"timestamp"`
+
+	Changes []AuditChange `json:"changes" bson:"changes"`
+}
@@ -0,0 +1,23 @@
+package sprava_krvi
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestBsonFieldsMatchJson - the stored documents use the api field names, so that the queries,
+// the audit trail and the migrations address the fields by the names of the specification
+func TestBsonFieldsMatchJson(t *testing.T) {
+	for _, model := range []interface{}{Donor{}, Unit{}, UnitContents{}} {
+		modelType := reflect.TypeOf(model)
+		for i := 0; i < modelType.NumField(); i++ {
+			field := modelType.Field(i)
+			jsonName, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+			bsonName, _, _ := strings.Cut(field.Tag.Get("bson"), ",")
+			if bsonName != jsonName {
+				t.Errorf("%v.%v is stored as %q, but named %q by the api", modelType.Name(), field.Name, bsonName, jsonName)
+			}
+		}
+	}
+}
@@ -17,36 +17,37 @@ import (
 // Donor - Contains the data being stored, regaring a single blood donor
 type Donor struct {
 
-	Id string `json:"id,omitempty"`
+	Id string `json:"id,omitempty" bson:"id"`
 
-	BirthNumber string `json:"birth_number"`
+	// stored without the slash
+	BirthNumber string `json:"birth_number" bson:"birth_number"`
 
-	FirstName string `json:"first_name"`
+	FirstName string `json:"first_name" bson:"first_name"`
 
-	LastName string `json:"last_name"`
+	LastName string `json:"last_name" bson:"last_name"`
 
 	// for broad location
-	PostalCode string `json:"postal_code"`
+	PostalCode string `json:"postal_code" bson:"postal_code"`
 
-	BloodType string `json:"blood_type,omitempty"`
+	BloodType string `json:"blood_type,omitempty" bson:"blood_type"`
 
-	BloodRh string `json:"blood_rh,omitempty"`
+	BloodRh string `json:"blood_rh,omitempty" bson:"blood_rh"`
 
-	Eligible bool `json:"eligible"`
+	Eligible bool `json:"eligible" bson:"eligible"`
 
-	LastDonation time.Time `json:"last_donation,omitempty"`
+	LastDonation time.Time `json:"last_donation,omitempty" bson:"last_donation"`
 
-	Email string `json:"email,omitempty"`
+	Email string `json:"email,omitempty" bson:"email"`
 
-	PhoneNumber string `json:"phone_number,omitempty"`
+	PhoneNumber string `json:"phone_number,omitempty" bson:"phone_number"`
 
-	Diseases []string `json:"diseases,omitempty"`
+	Diseases []string `json:"diseases,omitempty" bson:"diseases"`
 
-	Medications []string `json:"medications,omitempty"`
+	Medications []string `json:"medications,omitempty" bson:"medications"`
 
-	Substances []string `json:"substances,omitempty"`
+	Substances []string `json:"substances,omitempty" bson:"substances"`
 
-	CreatedAt time.Time `json:"created_at,omitempty"`
+	CreatedAt time.Time `json:"created_at,omitempty" bson:"created_at"`
 
-	UpdatedAt time.Time `json:"updated_at,omitempty"`
+	UpdatedAt time.Time `json:"updated_at,omitempty" bson:"updated_at"`
 }
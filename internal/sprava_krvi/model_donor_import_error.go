@@ -0,0 +1,21 @@
+/*
+ * Blood management API
+ *
+ * Management of blood donors and blood units
+ *
+ * API version: 1.0.0
+ * Contact: xsykoram3@stuba.sk
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package sprava_krvi
+
+// DonorImportError - Invalid value of an imported row
+type DonorImportError struct {
+
+	Field string `json:"field,omitempty" bson:"field"`
+
+	Column string `json:"column,omitempty" bson:"column"`
+
+	Message string `json:"message" bson:"message"`
+}
@@ -0,0 +1,35 @@
+/*
+ * Blood management API
+ *
+ * Management of blood donors and blood units
+ *
+ * API version: 1.0.0
+ * Contact: xsykoram3@stuba.sk
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package sprava_krvi
+
+// DonorImportReport - Outcome of a donor import
+type DonorImportReport struct {
+
+	Mode string `json:"mode" bson:"mode"`
+
+	// Whether all rows were imported in the single transaction, none if any of them failed
+	Atomic bool `json:"atomic" bson:"atomic"`
+
+	// Whether the changes were stored, false in the dry run and in the atomic import with a failed row
+	Committed bool `json:"committed" bson:"committed"`
+
+	Total int32 `json:"total" bson:"total"`
+
+	Created int32 `json:"created" bson:"created"`
+
+	Updated int32 `json:"updated" bson:"updated"`
+
+	Unchanged int32 `json:"unchanged" bson:"unchanged"`
+
+	Failed int32 `json:"failed" bson:"failed"`
+
+	Rows []DonorImportRow `json:"rows" bson:"rows"`
+}
@@ -0,0 +1,25 @@
+/*
+ * Blood management API
+ *
+ * Management of blood donors and blood units
+ *
+ * API version: 1.0.0
+ * Contact: xsykoram3@stuba.sk
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package sprava_krvi
+
+// DonorImportRow - Outcome of a single imported row
+type DonorImportRow struct {
+
+	// number of the row in the file, the header is the row 1
+	Row int32 `json:"row" bson:"row"`
+
+	// in the dry run what would be done
+	Status string `json:"status" bson:"status"`
+
+	DonorId string `json:"donor_id,omitempty" bson:"donor_id"`
+
+	Errors []DonorImportError `json:"errors,omitempty" bson:"errors"`
+}
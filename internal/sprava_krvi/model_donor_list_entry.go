@@ -17,17 +17,17 @@ import (
 // DonorListEntry - Contains simplified data, regaring a single blood donor
 type DonorListEntry struct {
 
-	Id string `json:"id"`
+	Id string `json:"id" bson:"id"`
 
-	FirstName string `json:"first_name"`
+	FirstName string `json:"first_name" bson:"first_name"`
 
-	LastName string `json:"last_name"`
+	LastName string `json:"last_name" bson:"last_name"`
 
-	BloodType string `json:"blood_type,omitempty"`
+	BloodType string `json:"blood_type,omitempty" bson:"blood_type"`
 
-	BloodRh string `json:"blood_rh,omitempty"`
+	BloodRh string `json:"blood_rh,omitempty" bson:"blood_rh"`
 
-	Eligible bool `json:"eligible"`
+	Eligible bool `json:"eligible" bson:"eligible"`
 
-	LastDonation time.Time `json:"last_donation,omitempty"`
+	LastDonation time.Time `json:"last_donation,omitempty" bson:"last_donation"`
 }
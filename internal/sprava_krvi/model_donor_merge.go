@@ -0,0 +1,17 @@
+/*
+ * Blood management API
+ *
+ * Management of blood donors and blood units
+ *
+ * API version: 1.0.0
+ * Contact: xsykoram3@stuba.sk
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package sprava_krvi
+
+// DonorMerge - Duplicate registration to merge into the donor
+type DonorMerge struct {
+
+	DuplicateId string `json:"duplicate_id" bson:"duplicate_id"`
+}
@@ -0,0 +1,23 @@
+/*
+ * Blood management API
+ *
+ * Management of blood donors and blood units
+ *
+ * API version: 1.0.0
+ * Contact: xsykoram3@stuba.sk
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package sprava_krvi
+
+// DuplicateCandidate - Pair of donors likely registered twice
+type DuplicateCandidate struct {
+
+	Donor DonorListEntry `json:"donor" bson:"donor"`
+
+	Duplicate DonorListEntry `json:"duplicate" bson:"duplicate"`
+
+	Score float32 `json:"score" bson:"score"`
+
+	Reasons []string `json:"reasons" bson:"reasons"`
+}
@@ -17,31 +17,31 @@ import (
 // Unit - Contains the data being stored, regaring a single blood unit
 type Unit struct {
 
-	Id string `json:"id,omitempty"`
+	Id string `json:"id,omitempty" bson:"id"`
 
-	DonorId string `json:"donor_id"`
+	DonorId string `json:"donor_id" bson:"donor_id"`
 
 	// common for all units from one donation
-	DonationId string `json:"donation_id,omitempty"`
+	DonationId string `json:"donation_id,omitempty" bson:"donation_id"`
 
-	BloodType string `json:"blood_type,omitempty"`
+	BloodType string `json:"blood_type,omitempty" bson:"blood_type"`
 
-	BloodRh string `json:"blood_rh,omitempty"`
+	BloodRh string `json:"blood_rh,omitempty" bson:"blood_rh"`
 
-	Status string `json:"status,omitempty"`
+	Status string `json:"status,omitempty" bson:"status"`
 
 	// for broad location
-	Location string `json:"location"`
+	Location string `json:"location" bson:"location"`
 
-	Contents UnitContents `json:"contents,omitempty"`
+	Contents UnitContents `json:"contents,omitempty" bson:"contents"`
 
-	Frozen bool `json:"frozen,omitempty"`
+	Frozen bool `json:"frozen,omitempty" bson:"frozen"`
 
-	Diseases []string `json:"diseases,omitempty"`
+	Diseases []string `json:"diseases,omitempty" bson:"diseases"`
 
-	Expiration time.Time `json:"expiration,omitempty"`
+	Expiration time.Time `json:"expiration,omitempty" bson:"expiration"`
 
-	CreatedAt time.Time `json:"created_at,omitempty"`
+	CreatedAt time.Time `json:"created_at,omitempty" bson:"created_at"`
 
-	UpdatedAt time.Time `json:"updated_at,omitempty"`
+	UpdatedAt time.Time `json:"updated_at,omitempty" bson:"updated_at"`
 }
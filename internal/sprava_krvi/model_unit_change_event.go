@@ -0,0 +1,29 @@
+/*
+ * Blood management API
+ *
+ * Management of blood donors and blood units
+ *
+ * API version: 1.0.0
+ * Contact: xsykoram3@stuba.sk
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package sprava_krvi
+
+import (
+	"time"
+)
+
+// UnitChangeEvent - Data of the server-sent events of the unit changes
+type UnitChangeEvent struct {
+
+	UnitId string `json:"unit_id" bson:"unit_id"`
+
+	// the unit after the change, missing on the deletion
+	Unit *Unit `json:"unit,omitempty" bson:"unit,omitempty"`
+
+	// set by unit.status_changed
+	PreviousStatus string `json:"previous_status,omitempty" bson:"previous_status,omitempty"`
+
+	Timestamp time.Time `json:"timestamp" bson:"timestamp"`
+}
@@ -12,15 +12,15 @@ package sprava_krvi
 
 type UnitContents struct {
 
-	Hemoglobin float32 `json:"hemoglobin,omitempty"`
+	Hemoglobin float32 `json:"hemoglobin,omitempty" bson:"hemoglobin"`
 
-	Erythrocytes bool `json:"erythrocytes,omitempty"`
+	Erythrocytes bool `json:"erythrocytes,omitempty" bson:"erythrocytes"`
 
-	Leukocytes bool `json:"leukocytes,omitempty"`
+	Leukocytes bool `json:"leukocytes,omitempty" bson:"leukocytes"`
 
-	Platelets bool `json:"platelets,omitempty"`
+	Platelets bool `json:"platelets,omitempty" bson:"platelets"`
 
-	Plasma bool `json:"plasma,omitempty"`
+	Plasma bool `json:"plasma,omitempty" bson:"plasma"`
 
-	Additional []string `json:"additional,omitempty"`
+	Additional []string `json:"additional,omitempty" bson:"additional"`
 }
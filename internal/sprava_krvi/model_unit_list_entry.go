@@ -10,17 +10,38 @@
 
 package sprava_krvi
 
+import (
+	"time"
+)
+
 // UnitListEntry - Contains simplified blood unit data
 type UnitListEntry struct {
 
-	Id string `json:"id"`
+	Id string `json:"id" bson:"id"`
 
-	BloodType string `json:"blood_type,omitempty"`
+	BloodType string `json:"blood_type,omitempty" bson:"blood_type"`
 
-	BloodRh string `json:"blood_rh,omitempty"`
+	BloodRh string `json:"blood_rh,omitempty" bson:"blood_rh"`
 
-	Status string `json:"status"`
+	Status string `json:"status" bson:"status"`
 
 	// for broad location
-	Location string `json:"location"`
+	Location string `json:"location" bson:"location"`
+
+	// only if requested by the fields parameter, as the following properties
+	DonorId string `json:"donor_id,omitempty" bson:"donor_id"`
+
+	DonationId string `json:"donation_id,omitempty" bson:"donation_id"`
+
+	Contents *UnitContents `json:"contents,omitempty" bson:"contents"`
+
+	Frozen *bool `json:"frozen,omitempty" bson:"frozen"`
+
+	Diseases []string `json:"diseases,omitempty" bson:"diseases"`
+
+	Expiration *time.Time `json:"expiration,omitempty" bson:"expiration"`
+
+	CreatedAt *time.Time `json:"created_at,omitempty" bson:"created_at"`
+
+	UpdatedAt *time.Time `json:"updated_at,omitempty" bson:"updated_at"`
 }
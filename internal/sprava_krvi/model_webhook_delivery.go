@@ -0,0 +1,43 @@
+/*
+ * Blood management API
+ *
+ * Management of blood donors and blood units
+ *
+ * API version: 1.0.0
+ * Contact: xsykoram3@stuba.sk
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package sprava_krvi
+
+import (
+	"time"
+)
+
+// WebhookDelivery - Single event sent to a single subscription
+type WebhookDelivery struct {
+
+	Id string `json:"id" bson:"id"`
+
+	WebhookId string `json:"webhook_id" bson:"webhook_id"`
+
+	EventId string `json:"event_id" bson:"event_id"`
+
+	EventType string `json:"event_type" bson:"event_type"`
+
+	Status string `json:"status" bson:"status"`
+
+	Attempts int32 `json:"attempts" bson:"attempts"`
+
+	NextAttemptAt *time.Time `json:"next_attempt_at,omitempty" bson:"next_attempt_at"`
+
+	LastError string `json:"last_error,omitempty" bson:"last_error"`
+
+	LastStatusCode int32 `json:"last_status_code,omitempty" bson:"last_status_code"`
+
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+
+	DeliveredAt *time.Time `json:"delivered_at,omitempty" bson:"delivered_at"`
+
+	Event *WebhookEvent `json:"event,omitempty" bson:"event"`
+}
@@ -0,0 +1,27 @@
+/*
+ * Blood management API
+ *
+ * Management of blood donors and blood units
+ *
+ * API version: 1.0.0
+ * Contact: xsykoram3@stuba.sk
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package sprava_krvi
+
+import (
+	"time"
+)
+
+// WebhookEvent - Body of a delivery
+type WebhookEvent struct {
+
+	Id string `json:"id" bson:"id"`
+
+	Type string `json:"type" bson:"type"`
+
+	OccurredAt time.Time `json:"occurred_at" bson:"occurred_at"`
+
+	Data map[string]interface{} `json:"data" bson:"data"`
+}
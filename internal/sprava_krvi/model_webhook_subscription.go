@@ -0,0 +1,36 @@
+/*
+ * Blood management API
+ *
+ * Management of blood donors and blood units
+ *
+ * API version: 1.0.0
+ * Contact: xsykoram3@stuba.sk
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package sprava_krvi
+
+import (
+	"time"
+)
+
+// WebhookSubscription - Receiver of the events, the deliveries are signed by the secret
+type WebhookSubscription struct {
+
+	Id string `json:"id,omitempty" bson:"id"`
+
+	// has to resolve to a public address, the internal ones only if allowed by API_WEBHOOKS_ALLOWED_TARGETS
+	Url string `json:"url" bson:"url"`
+
+	// key of the HMAC-SHA256 signatures, required on the creation and never returned
+	Secret string `json:"secret,omitempty" bson:"secret"`
+
+	Events []string `json:"events" bson:"events"`
+
+	// the inactive subscriptions receive no deliveries
+	Active *bool `json:"active,omitempty" bson:"active"`
+
+	CreatedAt time.Time `json:"created_at,omitempty" bson:"created_at"`
+
+	UpdatedAt time.Time `json:"updated_at,omitempty" bson:"updated_at"`
+}
@@ -14,8 +14,8 @@ import (
     "github.com/gin-gonic/gin"
 )
 
-func AddRoutes(engine *gin.Engine) {
-  group := engine.Group("/api")
+func AddRoutes(engine *gin.Engine, handlers ...gin.HandlerFunc) {
+  group := engine.Group("/api", handlers...)
   
   {
     api := newDonorsAPI()
@@ -27,4 +27,9 @@ func AddRoutes(engine *gin.Engine) {
     api.addRoutes(group)
   }
   
+  {
+    api := newWebhooksAPI()
+    api.addRoutes(group)
+  }
+  
 }
@@ -0,0 +1,159 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+
+	"github.com/Marek-FIIT/sprava-krvi-webapi/internal/db_service"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/Marek-FIIT/sprava-krvi-webapi/internal/tracing"
+
+type tracedSvc[DocType interface{}] struct {
+	svc        db_service.DbService[DocType]
+	collection string
+	tracer     trace.Tracer
+}
+
+// NewTracedService wraps every operation of the db service in a child span of the request.
+// Wrap the plain service, the audited service has to stay outermost to be found by GetAuditTrail.
+func NewTracedService[DocType interface{}](svc db_service.DbService[DocType], collection string) db_service.DbService[DocType] {
+	return &tracedSvc[DocType]{
+		svc:        svc,
+		collection: collection,
+		tracer:     otel.Tracer(tracerName),
+	}
+}
+
+func (this *tracedSvc[DocType]) start(ctx context.Context, operation string, attributes ...attribute.KeyValue) (context.Context, trace.Span) {
+	attributes = append(attributes,
+		attribute.String("db.system", "mongodb"),
+		attribute.String("db.collection.name", this.collection),
+		attribute.String("db.operation.name", operation),
+	)
+	return this.tracer.Start(
+		spanContext(ctx),
+		"db_service."+operation+" "+this.collection,
+		trace.WithSpanKind(trace.SpanKindInternal),
+		trace.WithAttributes(attributes...),
+	)
+}
+
+func (this *tracedSvc[DocType]) CreateDocument(ctx context.Context, id string, document *DocType) error {
+	ctx, span := this.start(ctx, "CreateDocument", attribute.String("db.document.id", id))
+	defer span.End()
+	return end(span, this.svc.CreateDocument(ctx, id, document))
+}
+
+func (this *tracedSvc[DocType]) CreateDocuments(ctx context.Context, ids []string, documents []*DocType) error {
+	ctx, span := this.start(ctx, "CreateDocuments", attribute.Int("db.document.count", len(documents)))
+	defer span.End()
+	return end(span, this.svc.CreateDocuments(ctx, ids, documents))
+}
+
+func (this *tracedSvc[DocType]) FindDocument(ctx context.Context, id string) (*DocType, error) {
+	ctx, span := this.start(ctx, "FindDocument", attribute.String("db.document.id", id))
+	defer span.End()
+	document, err := this.svc.FindDocument(ctx, id)
+	return document, end(span, err)
+}
+
+func (this *tracedSvc[DocType]) FindDocuments(ctx context.Context, query db_service.Query) ([]*DocType, error) {
+	ctx, span := this.start(ctx, "FindDocuments")
+	defer span.End()
+	documents, err := this.svc.FindDocuments(ctx, query)
+	span.SetAttributes(attribute.Int("db.document.count", len(documents)))
+	return documents, end(span, err)
+}
+
+func (this *tracedSvc[DocType]) StreamDocuments(ctx context.Context, query db_service.Query, fn func(document *DocType) error) error {
+	ctx, span := this.start(ctx, "StreamDocuments")
+	defer span.End()
+	count := 0
+	err := this.svc.StreamDocuments(ctx, query, func(document *DocType) error {
+		count++
+		return fn(document)
+	})
+	span.SetAttributes(attribute.Int("db.document.count", count))
+	return end(span, err)
+}
+
+func (this *tracedSvc[DocType]) UpdateDocument(ctx context.Context, id string, document *DocType) error {
+	ctx, span := this.start(ctx, "UpdateDocument", attribute.String("db.document.id", id))
+	defer span.End()
+	return end(span, this.svc.UpdateDocument(ctx, id, document))
+}
+
+func (this *tracedSvc[DocType]) DeleteDocument(ctx context.Context, id string) error {
+	ctx, span := this.start(ctx, "DeleteDocument", attribute.String("db.document.id", id))
+	defer span.End()
+	return end(span, this.svc.DeleteDocument(ctx, id))
+}
+
+func (this *tracedSvc[DocType]) BeginTransaction(ctx context.Context) (db_service.Transaction[DocType], error) {
+	ctx, span := this.start(ctx, "BeginTransaction")
+	defer span.End()
+	transaction, err := this.svc.BeginTransaction(ctx)
+	if err != nil {
+		return nil, end(span, err)
+	}
+	return &tracedTransaction[DocType]{transaction: transaction, svc: this}, nil
+}
+
+func (this *tracedSvc[DocType]) WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	// the operations of the transaction become children of its span
+	ctx, span := this.start(ctx, "WithTransaction")
+	defer span.End()
+	return end(span, this.svc.WithTransaction(ctx, fn))
+}
+
+func (this *tracedSvc[DocType]) HealthCheck(ctx context.Context) error {
+	// probes are too frequent to be worth tracing
+	return this.svc.HealthCheck(ctx)
+}
+
+func (this *tracedSvc[DocType]) EnsureSchema(ctx context.Context) error {
+	ctx, span := this.start(ctx, "EnsureSchema")
+	defer span.End()
+	return end(span, this.svc.EnsureSchema(ctx))
+}
+
+func (this *tracedSvc[DocType]) Disconnect(ctx context.Context) error {
+	return this.svc.Disconnect(ctx)
+}
+
+type tracedTransaction[DocType interface{}] struct {
+	transaction db_service.Transaction[DocType]
+	svc         *tracedSvc[DocType]
+}
+
+func (this *tracedTransaction[DocType]) CreateDocument(ctx context.Context, id string, document *DocType) error {
+	ctx, span := this.svc.start(ctx, "Transaction.CreateDocument", attribute.String("db.document.id", id))
+	defer span.End()
+	return end(span, this.transaction.CreateDocument(ctx, id, document))
+}
+
+func (this *tracedTransaction[DocType]) Commit() error {
+	return this.transaction.Commit()
+}
+
+func (this *tracedTransaction[DocType]) Rollback() error {
+	return this.transaction.Rollback()
+}
+
+func (this *tracedTransaction[DocType]) Context(ctx context.Context) context.Context {
+	return this.transaction.Context(ctx)
+}
+
+// end marks the span as failed, missing documents are an expected outcome
+func end(span trace.Span, err error) error {
+	if err != nil && !errors.Is(err, db_service.ErrNotFound) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return err
+}
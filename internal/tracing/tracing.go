@@ -0,0 +1,129 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const ServiceName = "sprava-krvi-webapi"
+
+const (
+	ExporterNone   = "none"
+	ExporterStdout = "stdout"
+	ExporterFile   = "file"
+	ExporterOtlp   = "otlp"
+)
+
+type Config struct {
+	// none, stdout, file or otlp
+	Exporter string
+	// target of the file exporter
+	File string
+}
+
+// Setup installs the global tracer provider and the W3C trace context propagator.
+// The returned function flushes the pending spans and has to be called before exiting.
+// The OTLP exporter is configured by the standard OTEL_EXPORTER_OTLP_* variables.
+func Setup(ctx context.Context, config Config) (func(context.Context) error, error) {
+	if config.Exporter == "" {
+		config.Exporter = ExporterNone
+	}
+	if config.File == "" {
+		config.File = "traces.json"
+	}
+
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	var exporter sdktrace.SpanExporter
+	var closer io.Closer
+	var err error
+	switch strings.ToLower(config.Exporter) {
+	case ExporterNone:
+		slog.Info("Tracing is disabled")
+		return func(context.Context) error { return nil }, nil
+	case ExporterStdout:
+		exporter, err = stdouttrace.New(stdouttrace.WithPrettyPrint())
+	case ExporterFile:
+		var file *os.File
+		file, err = os.OpenFile(config.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, err
+		}
+		closer = file
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(file))
+	case ExporterOtlp:
+		exporter, err = otlptracehttp.New(ctx)
+	default:
+		return nil, fmt.Errorf("unknown tracing exporter: %v", config.Exporter)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES take precedence
+	res, err := resource.Merge(
+		resource.NewSchemaless(semconv.ServiceName(ServiceName)),
+		resource.Default(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+	slog.Info("Tracing config", "exporter", config.Exporter)
+
+	return func(ctx context.Context) error {
+		err := provider.Shutdown(ctx)
+		if closer != nil {
+			if closeErr := closer.Close(); err == nil {
+				err = closeErr
+			}
+		}
+		return err
+	}, nil
+}
+
+// Middleware starts the server span of the request, continuing the trace of the caller if any.
+// Requests of the probes and the metrics scraper are not traced.
+func Middleware(untraced ...string) gin.HandlerFunc {
+	return otelgin.Middleware(ServiceName, otelgin.WithFilter(func(request *http.Request) bool {
+		for _, path := range untraced {
+			if request.URL.Path == path {
+				return false
+			}
+		}
+		return true
+	}))
+}
+
+// spanContext makes the span of the request visible to the child spans,
+// the gin context does not fall back to the request context by default
+func spanContext(ctx context.Context) context.Context {
+	if ginCtx, ok := ctx.(*gin.Context); ok && ginCtx.Request != nil {
+		return trace.ContextWithSpan(ctx, trace.SpanFromContext(ginCtx.Request.Context()))
+	}
+	return ctx
+}
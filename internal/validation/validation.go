@@ -0,0 +1,232 @@
+package validation
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/Marek-FIIT/sprava-krvi-webapi/api"
+	"github.com/Marek-FIIT/sprava-krvi-webapi/internal/problem"
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/gin-gonic/gin"
+)
+
+func init() {
+	// the parts of the multipart forms are decoded by their own content type,
+	// the browsers label the uploaded spreadsheets by their extension
+	for _, contentType := range []string{
+		"application/vnd.ms-excel",
+		"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+	} {
+		openapi3filter.RegisterBodyDecoder(contentType, openapi3filter.FileBodyDecoder)
+	}
+	openapi3filter.RegisterBodyDecoder("application/fhir+json", openapi3filter.JSONBodyDecoder)
+	// the fields of the multipart forms are plain text, also those of the boolean or numeric properties
+	openapi3filter.RegisterBodyDecoder("text/plain", plainBodyDecoder)
+}
+
+// plainBodyDecoder converts the plain text to the primitive type of its schema, the unconvertible text is left
+// for the validation to report
+func plainBodyDecoder(body io.Reader, header http.Header, schema *openapi3.SchemaRef, encFn openapi3filter.EncodingFn) (any, error) {
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, &openapi3filter.ParseError{Kind: openapi3filter.KindInvalidFormat, Cause: err}
+	}
+	value := string(data)
+	if schema == nil || schema.Value == nil {
+		return value, nil
+	}
+	switch {
+	case schema.Value.Type.Is("boolean"):
+		if parsed, err := strconv.ParseBool(value); err == nil {
+			return parsed, nil
+		}
+	case schema.Value.Type.Is("integer"), schema.Value.Type.Is("number"):
+		if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+			return parsed, nil
+		}
+	}
+	return value, nil
+}
+
+type Config struct {
+	// validate also the responses against the specification, meant for the tests
+	ValidateResponses bool
+}
+
+// Middleware validates the path, query and body of the requests against the embedded openapi specification
+// before the handlers run. Routes not declared in the specification are passed through.
+func Middleware(config Config) gin.HandlerFunc {
+	if config.ValidateResponses {
+		slog.Info("Response validation is enabled")
+	}
+
+	options := &openapi3filter.Options{
+		MultiError:         true,
+		AuthenticationFunc: openapi3filter.NoopAuthenticationFunc,
+	}
+
+	return func(ctx *gin.Context) {
+		route, ok := api.Route(ctx.Request.Method, ctx.FullPath())
+		if !ok {
+			ctx.Next()
+			return
+		}
+
+		pathParams := make(map[string]string)
+		for _, param := range ctx.Params {
+			pathParams[param.Key] = param.Value
+		}
+		requestInput := &openapi3filter.RequestValidationInput{
+			Request:    ctx.Request,
+			PathParams: pathParams,
+			Route:      route,
+			Options:    options,
+		}
+		if err := openapi3filter.ValidateRequest(ctx, requestInput); err != nil {
+			problem.Abort(ctx, problem.BadRequest("Request does not conform to the api specification", fieldErrors(err)...))
+			return
+		}
+
+		// the streamed responses cannot be held back until validated
+		if !config.ValidateResponses || streamed(route.Operation) {
+			ctx.Next()
+			return
+		}
+
+		writer := &bufferedWriter{ResponseWriter: ctx.Writer, status: http.StatusOK}
+		ctx.Writer = writer
+		ctx.Next()
+		ctx.Writer = writer.ResponseWriter
+
+		responseInput := &openapi3filter.ResponseValidationInput{
+			RequestValidationInput: requestInput,
+			Status:                 writer.status,
+			Header:                 writer.Header(),
+			Options:                options,
+		}
+		responseInput.SetBodyBytes(writer.body.Bytes())
+		if err := openapi3filter.ValidateResponse(ctx, responseInput); err != nil {
+			ctx.Writer.Header().Del("Content-Length")
+			problem.Abort(ctx, problem.Internal("Response does not conform to the api specification", err))
+			return
+		}
+		writer.flush()
+	}
+}
+
+// streamed tells whether the successful response of the operation is a stream of server-sent events
+func streamed(operation *openapi3.Operation) bool {
+	response := operation.Responses.Status(http.StatusOK)
+	return response != nil && response.Value != nil && response.Value.Content.Get("text/event-stream") != nil
+}
+
+// fieldErrors lists the failures of the individual request fields
+func fieldErrors(err error) []problem.FieldError {
+	var fields []problem.FieldError
+
+	// not errors.As, which would unwrap also the failures of the items of a single parameter
+	if multiError, ok := err.(openapi3.MultiError); ok {
+		for _, item := range multiError {
+			fields = append(fields, fieldErrors(item)...)
+		}
+		return fields
+	}
+
+	var requestError *openapi3filter.RequestError
+	if errors.As(err, &requestError) {
+		switch {
+		case requestError.Parameter != nil:
+			fields = append(fields, problem.FieldError{
+				Field:   requestError.Parameter.Name,
+				In:      requestError.Parameter.In,
+				Message: reason(requestError),
+			})
+			return fields
+		case requestError.RequestBody != nil && requestError.Err != nil:
+			for _, field := range fieldErrors(requestError.Err) {
+				field.In = "body"
+				fields = append(fields, field)
+			}
+			return fields
+		}
+	}
+
+	var schemaError *openapi3.SchemaError
+	if errors.As(err, &schemaError) {
+		return append(fields, problem.FieldError{
+			Field:   strings.Join(schemaError.JSONPointer(), "."),
+			In:      "body",
+			Message: schemaError.Reason,
+		})
+	}
+
+	return append(fields, problem.FieldError{Message: err.Error()})
+}
+
+func reason(requestError *openapi3filter.RequestError) string {
+	var schemaError *openapi3.SchemaError
+	switch {
+	case errors.As(requestError.Err, &schemaError):
+		return schemaError.Reason
+	case requestError.Reason != "":
+		return requestError.Reason
+	case requestError.Err != nil:
+		return requestError.Err.Error()
+	default:
+		return "is invalid"
+	}
+}
+
+// bufferedWriter holds the response back until it is validated
+type bufferedWriter struct {
+	gin.ResponseWriter
+	status  int
+	written bool
+	body    bytes.Buffer
+}
+
+func (this *bufferedWriter) WriteHeader(status int) {
+	this.status = status
+}
+
+func (this *bufferedWriter) WriteHeaderNow() {
+	this.written = true
+}
+
+func (this *bufferedWriter) Write(data []byte) (int, error) {
+	this.written = true
+	return this.body.Write(data)
+}
+
+func (this *bufferedWriter) WriteString(data string) (int, error) {
+	this.written = true
+	return this.body.WriteString(data)
+}
+
+func (this *bufferedWriter) Status() int {
+	return this.status
+}
+
+func (this *bufferedWriter) Size() int {
+	if !this.written {
+		return -1
+	}
+	return this.body.Len()
+}
+
+func (this *bufferedWriter) Written() bool {
+	return this.written
+}
+
+func (this *bufferedWriter) flush() {
+	this.ResponseWriter.WriteHeader(this.status)
+	if _, err := this.ResponseWriter.Write(this.body.Bytes()); err != nil {
+		slog.Error("Failed to write the validated response", "error", err)
+	}
+}
@@ -0,0 +1,140 @@
+package validation
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/gin-gonic/gin"
+)
+
+func TestMiddlewareValidatesResponses(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tests := []struct {
+		name     string
+		path     string
+		status   int
+		response string
+		want     int
+		body     string
+	}{
+		{
+			name:     "conforming response",
+			path:     "/api/webhooks/1",
+			status:   http.StatusOK,
+			response: `{"id":"1","url":"https://lis.hospital.example/hooks","events":["unit.created"]}`,
+			want:     http.StatusOK,
+			body:     `"events":["unit.created"]`,
+		},
+		{
+			name:     "missing required field",
+			path:     "/api/webhooks/1",
+			status:   http.StatusOK,
+			response: `{"id":"1","url":"https://lis.hospital.example/hooks"}`,
+			want:     http.StatusInternalServerError,
+			body:     "Response does not conform",
+		},
+		{
+			name:     "undeclared event",
+			path:     "/api/webhooks/1",
+			status:   http.StatusOK,
+			response: `{"id":"1","url":"https://lis.hospital.example/hooks","events":["unit.sold"]}`,
+			want:     http.StatusInternalServerError,
+			body:     "Response does not conform",
+		},
+		{
+			name:     "error response",
+			path:     "/api/webhooks/1",
+			status:   http.StatusNotFound,
+			response: `{"type":"about:blank","title":"Not Found","status":404}`,
+			want:     http.StatusNotFound,
+			body:     `"status":404`,
+		},
+		{
+			name:     "route outside of the specification",
+			path:     "/health",
+			status:   http.StatusOK,
+			response: `{"anything":true}`,
+			want:     http.StatusOK,
+			body:     `"anything":true`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			engine := gin.New()
+			engine.Use(Middleware(Config{ValidateResponses: true}))
+			respond := func(ctx *gin.Context) {
+				contentType := "application/json"
+				if test.status >= 400 {
+					contentType = "application/problem+json"
+				}
+				ctx.Data(test.status, contentType, []byte(test.response))
+			}
+			engine.GET("/api/webhooks/:webhookId", respond)
+			engine.GET("/health", respond)
+
+			recorder := httptest.NewRecorder()
+			engine.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, test.path, nil))
+			if recorder.Code != test.want || !strings.Contains(recorder.Body.String(), test.body) {
+				t.Errorf("responded %v %v, want %v containing %v", recorder.Code, recorder.Body.String(), test.want, test.body)
+			}
+		})
+	}
+}
+
+func TestMiddlewareRejectsInvalidRequests(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.Use(Middleware(Config{ValidateResponses: true}))
+	engine.GET("/api/units/events", func(ctx *gin.Context) {
+		t.Error("the handler of the invalid request was called")
+	})
+
+	recorder := httptest.NewRecorder()
+	engine.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/units/events?bloodType=C", nil))
+	if recorder.Code != http.StatusBadRequest || !strings.Contains(recorder.Body.String(), `"bloodType"`) {
+		t.Errorf("responded %v %v, want 400 naming the bloodType", recorder.Code, recorder.Body.String())
+	}
+}
+
+func TestMiddlewareDoesNotBufferStreams(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.Use(Middleware(Config{ValidateResponses: true}))
+	engine.GET("/api/units/events", func(ctx *gin.Context) {
+		if _, buffered := ctx.Writer.(*bufferedWriter); buffered {
+			t.Error("the stream is buffered")
+		}
+		ctx.Header("Content-Type", "text/event-stream")
+		ctx.String(http.StatusOK, "event:reset\ndata:the missed events are not available\n\n")
+	})
+
+	recorder := httptest.NewRecorder()
+	engine.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/units/events?bloodType=A", nil))
+	if recorder.Code != http.StatusOK || !strings.HasPrefix(recorder.Body.String(), "event:reset") {
+		t.Errorf("responded %v %v", recorder.Code, recorder.Body.String())
+	}
+}
+
+func TestPlainBodyDecoder(t *testing.T) {
+	tests := []struct {
+		schema *openapi3.Schema
+		text   string
+		want   interface{}
+	}{
+		{openapi3.NewBoolSchema(), "true", true},
+		{openapi3.NewBoolSchema(), "yes", "yes"},
+		{openapi3.NewIntegerSchema(), "42", 42.0},
+		{openapi3.NewFloat64Schema(), "1.5", 1.5},
+		{openapi3.NewStringSchema(), "true", "true"},
+	}
+	for _, test := range tests {
+		value, err := plainBodyDecoder(strings.NewReader(test.text), nil, openapi3.NewSchemaRef("", test.schema), nil)
+		if err != nil || fmt.Sprintf("%T %v", value, value) != fmt.Sprintf("%T %v", test.want, test.want) {
+			t.Errorf("decoded %q as %T %v, %v, want %T %v", test.text, value, value, err, test.want, test.want)
+		}
+	}
+}
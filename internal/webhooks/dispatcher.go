@@ -0,0 +1,305 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/Marek-FIIT/sprava-krvi-webapi/internal/db_service"
+	"github.com/google/uuid"
+)
+
+// headers of the delivery request, the receivers verify the signature of the timestamp and the body
+// and drop the repeated event ids, a delivery is retried until it is acknowledged by a 2xx response
+const (
+	HeaderEventId   = "X-Webhook-Id"
+	HeaderEventType = "X-Webhook-Event"
+	HeaderTimestamp = "X-Webhook-Timestamp"
+	HeaderSignature = "X-Webhook-Signature"
+)
+
+// deliveries sent per poll
+const batchSize = 100
+
+// LeaseName - name of the lease held by the dispatcher sending the deliveries, see Config.Leases
+const LeaseName = "webhook_dispatcher"
+
+type Config struct {
+	Subscriptions db_service.DbService[Subscription]
+	Deliveries    db_service.DbService[Delivery]
+	// timeout of a single delivery request
+	Timeout     time.Duration
+	MaxAttempts int
+	// the delay before the n-th retry is InitialBackoff * 2^(n-1), up to MaxBackoff
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// addresses the deliveries may be sent to, see Targets
+	Targets *Targets
+	// interval of looking up the due retries
+	PollInterval time.Duration
+	// the dispatchers of all replicas take turns holding the LeaseName lease, only its holder sends
+	Leases db_service.Counters
+	// how long a crashed dispatcher holds the lease, longer than a delivery request, 2 minutes by default
+	Lease time.Duration
+}
+
+// Dispatcher records the outbox events as deliveries to the subscriptions and sends them in background.
+// The dispatchers of the replicas send only while holding the lease, since concurrent dispatchers
+// would send the same due deliveries more than once.
+type Dispatcher struct {
+	config Config
+	client *http.Client
+	wake   chan struct{}
+	// identifies the dispatcher as the holder of the lease
+	owner   string
+	leading bool
+}
+
+func NewDispatcher(config Config) *Dispatcher {
+	if config.Lease == 0 {
+		config.Lease = max(2*time.Minute, 2*config.Timeout)
+	}
+	host, _ := os.Hostname()
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = config.Targets.DialContext
+	return &Dispatcher{
+		config: config,
+		client: &http.Client{
+			Timeout:   config.Timeout,
+			Transport: transport,
+			// the subscriber has to acknowledge the delivery itself
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		wake:  make(chan struct{}, 1),
+		owner: fmt.Sprintf("%v/%v/%v", host, os.Getpid(), uuid.New().String()[:8]),
+	}
+}
+
+// Run sends the due deliveries until the context is done
+func (this *Dispatcher) Run(ctx context.Context) {
+	defer func() {
+		// released even though the context is done, so that another replica takes over without waiting for the lease
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := this.config.Leases.Release(ctx, LeaseName, this.owner); err != nil {
+			slog.Warn("Failed to release the webhook dispatcher lease", "error", err)
+		}
+	}()
+
+	ticker := time.NewTicker(this.config.PollInterval)
+	defer ticker.Stop()
+	for {
+		if err := this.deliverDue(ctx); err != nil {
+			slog.ErrorContext(ctx, "Failed to send the webhook deliveries", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-this.wake:
+		}
+	}
+}
+
+// Notify makes the dispatcher look the due deliveries up without waiting for the next poll,
+// the deliveries recorded while another replica holds the lease wait for its poll
+func (this *Dispatcher) Notify() {
+	select {
+	case this.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Name identifies the dispatcher as a sink of the outbox relay
+func (this *Dispatcher) Name() string {
+	return "webhook"
+}
+
+// Publish records a pending delivery of the outbox event for every active subscription of its type.
+// The deliveries are identified by the event and the subscription, so a republished event is not delivered twice.
+func (this *Dispatcher) Publish(ctx context.Context, outboxEvent *db_service.OutboxEvent) error {
+	subscriptions, err := this.config.Subscriptions.FindDocuments(ctx, db_service.NewQuery().
+		Eq("active", true).
+		In("events", outboxEvent.Type))
+	if err != nil {
+		return err
+	}
+	if len(subscriptions) == 0 {
+		return nil
+	}
+
+	payload, err := json.Marshal(Event{
+		Id:         outboxEvent.Id,
+		Type:       outboxEvent.Type,
+		OccurredAt: outboxEvent.OccurredAt.UTC(),
+		Data:       json.RawMessage(outboxEvent.Payload),
+	})
+	if err != nil {
+		return err
+	}
+	for _, subscription := range subscriptions {
+		delivery := &Delivery{
+			Id:             outboxEvent.Id + ":" + subscription.Id,
+			SubscriptionId: subscription.Id,
+			EventId:        outboxEvent.Id,
+			EventType:      outboxEvent.Type,
+			Payload:        string(payload),
+			Status:         DeliveryPending,
+			NextAttemptAt:  time.Now(),
+			CreatedAt:      time.Now(),
+		}
+		if err := this.config.Deliveries.CreateDocument(ctx, delivery.Id, delivery); err != nil && !errors.Is(err, db_service.ErrConflict) {
+			return err
+		}
+	}
+	this.Notify()
+	return nil
+}
+
+// deliverDue sends the pending deliveries whose attempt is due, the oldest first
+func (this *Dispatcher) deliverDue(ctx context.Context) error {
+	for {
+		held, err := this.lease(ctx)
+		if err != nil || !held {
+			return err
+		}
+
+		deliveries, err := this.config.Deliveries.FindDocuments(ctx, db_service.NewQuery().
+			Eq("status", DeliveryPending).
+			Lte("next_attempt_at", time.Now()).
+			OrderBy("next_attempt_at", false).
+			Take(batchSize))
+		if err != nil {
+			return err
+		}
+
+		subscriptions := make(map[string]*Subscription)
+		for _, delivery := range deliveries {
+			if ctx.Err() != nil {
+				return nil
+			}
+			// renewed before every delivery, so that the lease does not expire while sending
+			if held, err := this.lease(ctx); err != nil || !held {
+				return err
+			}
+			subscription, found := subscriptions[delivery.SubscriptionId]
+			if !found {
+				subscription, err = this.config.Subscriptions.FindDocument(ctx, delivery.SubscriptionId)
+				if err != nil && err != db_service.ErrNotFound {
+					return err
+				}
+				subscriptions[delivery.SubscriptionId] = subscription
+			}
+
+			this.attempt(ctx, delivery, subscription)
+			if err := this.config.Deliveries.UpdateDocument(ctx, delivery.Id, delivery); err != nil {
+				return err
+			}
+		}
+		if len(deliveries) < batchSize {
+			return nil
+		}
+	}
+}
+
+// lease takes or renews the lease of the dispatcher
+func (this *Dispatcher) lease(ctx context.Context) (bool, error) {
+	held, err := this.config.Leases.Acquire(ctx, LeaseName, this.owner, this.config.Lease)
+	if err != nil {
+		return false, err
+	}
+	if held != this.leading {
+		slog.InfoContext(ctx, "Webhook dispatcher changed its role", "sending", held)
+		this.leading = held
+	}
+	return held, nil
+}
+
+// attempt sends the delivery once and schedules the next attempt or gives up on it
+func (this *Dispatcher) attempt(ctx context.Context, delivery *Delivery, subscription *Subscription) {
+	if subscription == nil || !subscription.Active {
+		delivery.Status = DeliveryDead
+		delivery.LastError = "subscription was deleted or deactivated"
+		return
+	}
+
+	delivery.Attempts++
+	statusCode, err := this.send(ctx, delivery, subscription)
+	delivery.LastStatusCode = statusCode
+	if err == nil {
+		delivery.Status = DeliveryDelivered
+		delivery.DeliveredAt = time.Now()
+		delivery.LastError = ""
+		return
+	}
+
+	delivery.LastError = err.Error()
+	if delivery.Attempts >= this.config.MaxAttempts {
+		delivery.Status = DeliveryDead
+		slog.WarnContext(ctx, "Webhook delivery failed, giving up", "delivery_id", delivery.Id, "subscription_id", subscription.Id, "attempts", delivery.Attempts, "error", err)
+		return
+	}
+	delivery.NextAttemptAt = time.Now().Add(this.backoff(delivery.Attempts))
+}
+
+func (this *Dispatcher) send(ctx context.Context, delivery *Delivery, subscription *Subscription) (int, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, subscription.Url, bytes.NewBufferString(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("User-Agent", "sprava-krvi-webhooks")
+	request.Header.Set(HeaderEventId, delivery.EventId)
+	request.Header.Set(HeaderEventType, delivery.EventType)
+	request.Header.Set(HeaderTimestamp, timestamp)
+	request.Header.Set(HeaderSignature, Sign(subscription.Secret, timestamp, []byte(delivery.Payload)))
+
+	response, err := this.client.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+	// drained so that the connection is reused
+	_, _ = io.Copy(io.Discard, io.LimitReader(response.Body, 64<<10))
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return response.StatusCode, fmt.Errorf("subscriber responded with %v", response.Status)
+	}
+	return response.StatusCode, nil
+}
+
+// backoff doubles the delay with every failed attempt, the jitter of up to 10 % spreads
+// the retries of the deliveries failed together
+func (this *Dispatcher) backoff(attempts int) time.Duration {
+	delay := this.config.InitialBackoff
+	for i := 1; i < attempts && delay < this.config.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > this.config.MaxBackoff {
+		delay = this.config.MaxBackoff
+	}
+	return delay + time.Duration(rand.Int63n(int64(delay)/10+1))
+}
+
+// Sign computes the signature header value, HMAC-SHA256 of the timestamp and the body joined by a dot
+func Sign(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
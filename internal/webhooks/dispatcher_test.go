@@ -0,0 +1,180 @@
+package webhooks
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/Marek-FIIT/sprava-krvi-webapi/internal/db_service"
+)
+
+func TestSign(t *testing.T) {
+	tests := []struct {
+		secret    string
+		timestamp string
+		body      string
+		want      string
+	}{
+		{"secret", "1700000000", `{"id":"1"}`, "sha256=086f6aff7bd084c98679825129c5a64dbad88c760016d6d2c0fb123f27951d54"},
+		{"other", "1700000000", `{"id":"1"}`, "sha256=0c9dcd041b074d1b31727e0c1f821d11366e9db9f94c18bf202eb66cd0bd4d40"},
+	}
+	for _, test := range tests {
+		if got := Sign(test.secret, test.timestamp, []byte(test.body)); got != test.want {
+			t.Errorf("Sign(%q, %q, %q) = %v, want %v", test.secret, test.timestamp, test.body, got, test.want)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	dispatcher := NewDispatcher(Config{InitialBackoff: time.Second, MaxBackoff: time.Minute})
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{4, 8 * time.Second},
+		{6, 32 * time.Second},
+		{7, time.Minute},
+		{100, time.Minute},
+	}
+	for _, test := range tests {
+		// the jitter adds up to 10 %
+		if got := dispatcher.backoff(test.attempts); got < test.want || got > test.want+test.want/10 {
+			t.Errorf("backoff(%v) = %v, want %v up to 10 %% more", test.attempts, got, test.want)
+		}
+	}
+}
+
+func TestTargetsCheck(t *testing.T) {
+	allowing, err := NewTargets([]string{"receiver.local", "10.1.0.0/16"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		targets *Targets
+		url     string
+		err     error
+	}{
+		{"public address", nil, "https://8.8.8.8/hook", nil},
+		{"loopback", nil, "http://127.0.0.1:8080/hook", ErrForbiddenTarget},
+		{"loopback v6", nil, "http://[::1]/hook", ErrForbiddenTarget},
+		{"private", nil, "http://10.1.2.3/hook", ErrForbiddenTarget},
+		{"link-local metadata", nil, "http://169.254.169.254/latest", ErrForbiddenTarget},
+		{"unspecified", nil, "http://0.0.0.0/hook", ErrForbiddenTarget},
+		{"allowed network", allowing, "http://10.1.2.3/hook", nil},
+		{"outside of the allowed network", allowing, "http://10.2.0.1/hook", ErrForbiddenTarget},
+		{"allowed host", allowing, "http://receiver.local/hook", nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			target, err := url.Parse(test.url)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := test.targets.Check(context.Background(), target); !errors.Is(err, test.err) {
+				t.Errorf("got %v, want %v", err, test.err)
+			}
+		})
+	}
+}
+
+func TestNewTargetsRejectsInvalidRanges(t *testing.T) {
+	if _, err := NewTargets([]string{"10.1.0.0/33"}); err == nil {
+		t.Error("expected the invalid range to be rejected")
+	}
+}
+
+// memoryDeliveries - deliveries in memory, all of them are due
+type memoryDeliveries struct {
+	db_service.DbService[Delivery]
+	deliveries map[string]*Delivery
+}
+
+func (this *memoryDeliveries) FindDocuments(ctx context.Context, query db_service.Query) ([]*Delivery, error) {
+	var due []*Delivery
+	for _, delivery := range this.deliveries {
+		if delivery.Status == DeliveryPending {
+			copied := *delivery
+			due = append(due, &copied)
+		}
+	}
+	return due, nil
+}
+
+func (this *memoryDeliveries) UpdateDocument(ctx context.Context, id string, delivery *Delivery) error {
+	this.deliveries[id] = delivery
+	return nil
+}
+
+type memorySubscriptions struct {
+	db_service.DbService[Subscription]
+	subscription *Subscription
+}
+
+func (this *memorySubscriptions) FindDocument(ctx context.Context, id string) (*Subscription, error) {
+	return this.subscription, nil
+}
+
+// leases - the lease is held by the dispatcher or by another one
+type leases struct {
+	held bool
+}
+
+func (this *leases) Next(ctx context.Context, name string) (int64, error) {
+	return 0, errors.New("not supported")
+}
+
+func (this *leases) Acquire(ctx context.Context, name string, owner string, lease time.Duration) (bool, error) {
+	return this.held, nil
+}
+
+func (this *leases) Release(ctx context.Context, name string, owner string) error {
+	return nil
+}
+
+func TestDispatcherSendsOnlyWithLease(t *testing.T) {
+	tests := []struct {
+		held     bool
+		requests int
+		status   string
+	}{
+		{true, 1, DeliveryDelivered},
+		{false, 0, DeliveryPending},
+	}
+	for _, test := range tests {
+		requests := 0
+		server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			requests++
+		}))
+		targets, err := NewTargets([]string{"127.0.0.0/8"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		deliveries := &memoryDeliveries{deliveries: map[string]*Delivery{
+			"1": {Id: "1", SubscriptionId: "s", Payload: "{}", Status: DeliveryPending},
+		}}
+		dispatcher := NewDispatcher(Config{
+			Subscriptions: &memorySubscriptions{subscription: &Subscription{Id: "s", Url: server.URL, Secret: "secret", Active: true}},
+			Deliveries:    deliveries,
+			Timeout:       time.Second,
+			MaxAttempts:   3,
+			Targets:       targets,
+			Leases:        &leases{held: test.held},
+		})
+
+		if err := dispatcher.deliverDue(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		server.Close()
+		if requests != test.requests || deliveries.deliveries["1"].Status != test.status {
+			t.Errorf("with the lease held %v sent %v requests and left the delivery %v, want %v and %v",
+				test.held, requests, deliveries.deliveries["1"].Status, test.requests, test.status)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package webhooks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ErrForbiddenTarget - the target of the webhook is an address of the internal network
+var ErrForbiddenTarget = errors.New("the target is a loopback, link-local, private or unspecified address")
+
+// context key of the targets policy, set by the middleware
+const TargetsKey = "webhook_targets"
+
+// Targets - policy of the webhook targets. The addresses of the internal network are refused unless allowed,
+// so that the subscriptions cannot make the api call the services next to it. The nil policy allows none of them.
+type Targets struct {
+	hosts    map[string]bool
+	networks []*net.IPNet
+}
+
+// NewTargets allows the hosts or the CIDR ranges despite being internal, e.g. "receiver.local" or "10.1.0.0/16"
+func NewTargets(allowed []string) (*Targets, error) {
+	targets := &Targets{hosts: make(map[string]bool)}
+	for _, entry := range allowed {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if strings.Contains(entry, "/") {
+			_, network, err := net.ParseCIDR(entry)
+			if err != nil {
+				return nil, fmt.Errorf("invalid allowed webhook target %q: %w", entry, err)
+			}
+			targets.networks = append(targets.networks, network)
+		} else if entry != "" {
+			targets.hosts[entry] = true
+		}
+	}
+	return targets, nil
+}
+
+// Check resolves the host of the target, it fails with ErrForbiddenTarget if any of its addresses is not allowed
+func (this *Targets) Check(ctx context.Context, target *url.URL) error {
+	host := strings.ToLower(target.Hostname())
+	if this != nil && this.hosts[host] {
+		return nil
+	}
+	ips, err := net.DefaultResolver.LookupIP(ctx, "ip", host)
+	if err != nil {
+		return fmt.Errorf("the host cannot be resolved: %w", err)
+	}
+	for _, ip := range ips {
+		if !this.allowedIp(ip) {
+			return ErrForbiddenTarget
+		}
+	}
+	return nil
+}
+
+// DialContext checks the address being connected, so that the host cannot be moved into the internal network
+// by its DNS record after the subscription was checked
+func (this *Targets) DialContext(ctx context.Context, network string, address string) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	if this == nil || !this.hosts[strings.ToLower(host)] {
+		dialer.Control = func(network string, address string, _ syscall.RawConn) error {
+			ip, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if !this.allowedIp(net.ParseIP(ip)) {
+				return ErrForbiddenTarget
+			}
+			return nil
+		}
+	}
+	return dialer.DialContext(ctx, network, address)
+}
+
+func (this *Targets) allowedIp(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	if this != nil {
+		for _, network := range this.networks {
+			if network.Contains(ip) {
+				return true
+			}
+		}
+	}
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast())
+}
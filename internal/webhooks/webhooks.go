@@ -0,0 +1,101 @@
+package webhooks
+
+import (
+	"context"
+	"time"
+
+	"github.com/Marek-FIIT/sprava-krvi-webapi/internal/db_service"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// types of the events the clients can subscribe to
+const (
+	EventUnitCreated             = "unit.created"
+	EventUnitStatusChanged       = "unit.status_changed"
+	EventUnitExpired             = "unit.expired"
+	EventDonorEligibilityChanged = "donor.eligibility_changed"
+)
+
+var EventTypes = []string{
+	EventUnitCreated,
+	EventUnitStatusChanged,
+	EventUnitExpired,
+	EventDonorEligibilityChanged,
+}
+
+// states of a delivery, the dead deliveries are the dead-letter list waiting for a manual retry
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryDead      = "dead"
+)
+
+// context key of the dispatcher, set by the middleware only when the webhooks are enabled
+const DispatcherKey = "webhooks"
+
+// Subscription - registered receiver of the events, the secret signs the deliveries
+type Subscription struct {
+	Id        string    `json:"id" bson:"id"`
+	Url       string    `json:"url" bson:"url"`
+	Secret    string    `json:"secret" bson:"secret"`
+	Events    []string  `json:"events" bson:"events"`
+	Active    bool      `json:"active" bson:"active"`
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" bson:"updated_at"`
+}
+
+// Delivery - single event sent to a single subscription, the payload is kept as sent so that
+// the retries carry the same signed body
+type Delivery struct {
+	Id             string    `json:"id" bson:"id"`
+	SubscriptionId string    `json:"subscription_id" bson:"subscription_id"`
+	EventId        string    `json:"event_id" bson:"event_id"`
+	EventType      string    `json:"event_type" bson:"event_type"`
+	Payload        string    `json:"payload" bson:"payload"`
+	Status         string    `json:"status" bson:"status"`
+	Attempts       int       `json:"attempts" bson:"attempts"`
+	NextAttemptAt  time.Time `json:"next_attempt_at" bson:"next_attempt_at"`
+	LastError      string    `json:"last_error,omitempty" bson:"last_error,omitempty"`
+	LastStatusCode int       `json:"last_status_code,omitempty" bson:"last_status_code,omitempty"`
+	CreatedAt      time.Time `json:"created_at" bson:"created_at"`
+	DeliveredAt    time.Time `json:"delivered_at,omitempty" bson:"delivered_at,omitempty"`
+}
+
+// Event - body of the delivery, the id is the id of the outbox event
+type Event struct {
+	Id         string      `json:"id"`
+	Type       string      `json:"type"`
+	OccurredAt time.Time   `json:"occurred_at"`
+	Data       interface{} `json:"data"`
+}
+
+// SubscriptionIndexes - indexes of the subscription collection, the dispatcher looks the active subscribers of an event up
+var SubscriptionIndexes = []db_service.Index{
+	{Name: "id_unique", Keys: bson.D{{Key: "id", Value: 1}}, Unique: true},
+	{Name: "events_active", Keys: bson.D{{Key: "events", Value: 1}, {Key: "active", Value: 1}}},
+}
+
+// DeliveryIndexes - indexes of the delivery collection, the dispatcher polls the due pending deliveries
+var DeliveryIndexes = []db_service.Index{
+	{Name: "id_unique", Keys: bson.D{{Key: "id", Value: 1}}, Unique: true},
+	{Name: "status_next_attempt", Keys: bson.D{{Key: "status", Value: 1}, {Key: "next_attempt_at", Value: 1}}},
+	{Name: "subscription_created_at", Keys: bson.D{{Key: "subscription_id", Value: 1}, {Key: "created_at", Value: 1}}},
+	{Name: "created_at", Keys: bson.D{{Key: "created_at", Value: 1}}},
+}
+
+// IsEventType tells whether the clients can subscribe to the event type
+func IsEventType(eventType string) bool {
+	for _, known := range EventTypes {
+		if known == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// Notify wakes the dispatcher of the request context up after a delivery was scheduled
+func Notify(ctx context.Context) {
+	if dispatcher, ok := ctx.Value(DispatcherKey).(*Dispatcher); ok {
+		dispatcher.Notify()
+	}
+}
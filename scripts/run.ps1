@@ -12,6 +12,8 @@ $env:API_ENVIRONMENT="Development"
 $env:API_PORT="8080"
 $env:API_MONGODB_USERNAME="root"
 $env:API_MONGODB_PASSWORD="neUhaDnes"
+# the compose MongoDB is a single-node replica set, the api connects to it directly
+$env:API_EVENTS_SOURCE="memory"
 
 function mongo {
     docker compose --file ${ProjectRoot}/deployments/docker-compose/compose.yaml $args
@@ -20,7 +22,7 @@ function mongo {
 switch ($command) {
     "start" {
         try {
-            mongo up --detach
+            mongo up --detach --wait
             go run ${ProjectRoot}/cmd/sprava-krvi-api-service
         }
         finally {